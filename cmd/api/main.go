@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/sanskarm98/auth-service/internal/auth"
 	"github.com/sanskarm98/auth-service/internal/config"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
 	"github.com/sanskarm98/auth-service/internal/handlers"
+	"github.com/sanskarm98/auth-service/internal/ldapauth"
+	"github.com/sanskarm98/auth-service/internal/mail"
+	"github.com/sanskarm98/auth-service/internal/oidc"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/pii"
+	"github.com/sanskarm98/auth-service/internal/ratelimit"
+	"github.com/sanskarm98/auth-service/internal/revocation"
+	"github.com/sanskarm98/auth-service/internal/saml"
 	"github.com/sanskarm98/auth-service/internal/store"
 )
 
@@ -16,33 +29,327 @@ func main() {
 	// Initialize configuration
 	cfg := config.LoadConfig()
 
+	// Initialize password hashing
+	argon2id := password.NewArgon2id(uint32(cfg.Argon2Memory), uint32(cfg.Argon2Time), uint8(cfg.Argon2Threads))
+	bcrypt := password.NewBcrypt(cfg.BcryptCost)
+
+	// Hashes imported from other systems can be verified, and are upgraded at sign-in
+	pbkdf2 := password.NewPBKDF2()
+	scrypt := password.NewScrypt()
+	saltedSHA256 := password.NewSaltedSHA256()
+	// Mix in a server-side pepper if a key file is configured
+	var pepper *password.Pepper
+	if cfg.PepperFile != "" {
+		var err error
+		if pepper, err = password.LoadPepperFile(cfg.PepperFile); err != nil {
+			log.Fatalf("Failed to load password pepper: %v", err)
+		}
+	}
+
+	var hasher *password.Hasher
+	switch cfg.PasswordHasher {
+	case "argon2id":
+		hasher = password.NewHasher(pepper, argon2id, bcrypt, pbkdf2, scrypt, saltedSHA256)
+	case "bcrypt":
+		hasher = password.NewHasher(pepper, bcrypt, argon2id, pbkdf2, scrypt, saltedSHA256)
+	default:
+		log.Fatalf("Unknown password hasher %q", cfg.PasswordHasher)
+	}
+
+	// Initialize email normalization
+	emails := emailaddr.NewNormalizer(cfg.EmailProviderRules)
+	var disposableDomains *emailaddr.DomainBlocklist
+	if cfg.DisposableDomainFile != "" {
+		var err error
+		if disposableDomains, err = emailaddr.LoadDomainBlocklist(cfg.DisposableDomainFile); err != nil {
+			log.Fatalf("Failed to load disposable domains: %v", err)
+		}
+	}
+
+	// Encrypt users' personal fields at rest if a KMS is configured
+	var fieldCipher store.FieldCipher
+	if cfg.KMSFile != "" {
+		kms, err := pii.LoadFileKMS(cfg.KMSFile)
+		if err != nil {
+			log.Fatalf("Failed to load PII_KMS_FILE: %v", err)
+		}
+		keyring, err := pii.OpenKeyring(context.Background(), cfg.KeyringFile, kms)
+		if errors.Is(err, pii.ErrNoKeyring) {
+			log.Fatalf("Failed to open keyring: %v; create it once with init-keyring", err)
+		}
+		if err != nil {
+			log.Fatalf("Failed to open keyring: %v", err)
+		}
+		fieldCipher = keyring
+	}
+
 	// Initialize stores
-	userStore := store.NewInMemoryUserStore()
-	tokenStore := store.NewInMemoryTokenStore()
+	var userStore store.UserStore = store.NewInMemoryUserStore(hasher, emails)
+	var tokenStore store.TokenStore = store.NewInMemoryTokenStore(cfg.AccessTokenExp)
+	var revocationBus revocation.Bus = revocation.NewLocalBus()
+	switch {
+	case cfg.Raft.NodeID != "":
+		raftTLS, err := store.LoadRaftTLS(cfg.Raft.TLSCertFile, cfg.Raft.TLSKeyFile, cfg.Raft.TLSCAFile)
+		if err != nil {
+			log.Fatalf("Failed to load Raft TLS settings: %v", err)
+		}
+		raftStore, err := store.OpenRaftStore(store.RaftConfig{
+			NodeID:        cfg.Raft.NodeID,
+			Address:       cfg.Raft.Address,
+			Dir:           cfg.Raft.Dir,
+			Peers:         cfg.Raft.Peers,
+			ApplyTimeout:  cfg.Raft.ApplyTimeout,
+			RevocationTTL: cfg.AccessTokenExp,
+			Cipher:        fieldCipher,
+			TLS:           raftTLS,
+		}, hasher, emails)
+		if err != nil {
+			log.Fatalf("Failed to start Raft node: %v", err)
+		}
+		userStore, tokenStore = raftStore.Users(), raftStore.Tokens()
+		revocationBus = revocation.NewStoreBus(raftStore.Revocations())
+	case cfg.DataDir != "":
+		fileStore, err := store.OpenFileStore(cfg.DataDir, hasher, emails, cfg.SnapshotEvery, cfg.AccessTokenExp, fieldCipher)
+		if err != nil {
+			log.Fatalf("Failed to open data directory: %v", err)
+		}
+		userStore, tokenStore = fileStore.Users(), fileStore.Tokens()
+		revocationBus = revocation.NewStoreBus(fileStore.Revocations())
+	}
+	var rateLimitStore store.RateLimitStore = store.NewInMemoryRateLimitStore()
+	if cfg.RedisURL != "" {
+		redisOptions, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		redisClient := redis.NewClient(redisOptions)
+		tokenStore = store.NewRedisTokenStore(redisClient, cfg.RedisKeyPrefix, cfg.RefreshTokenExp, cfg.AccessTokenExp)
+		revocationBus = revocation.NewRedisBus(redisClient, cfg.RedisKeyPrefix)
+		rateLimitStore = store.NewRedisRateLimitStore(redisClient, cfg.RedisKeyPrefix)
+	}
+
+	// Keep revocations from every replica in memory; with a shared bus, token
+	// revocation checks are answered from there instead of the token store
+	revocations := revocation.NewCache(revocationBus, cfg.AccessTokenExp, cfg.RefreshTokenExp)
+	if cfg.RedisURL != "" {
+		tokenStore = revocation.NewTokenStore(tokenStore, revocations)
+	}
+	if cfg.UserCacheSize > 0 {
+		cachedUsers := store.NewCachedUserStore(userStore, cfg.UserCacheSize, cfg.UserCacheTTL)
+		expvar.Publish("user_cache", expvar.Func(func() any { return cachedUsers.Stats() }))
+		userStore = cachedUsers
+	}
+	deviceStore := store.NewInMemoryDeviceCodeStore()
+	clientStore := store.NewInMemoryClientStore()
+	identityStore := store.NewInMemoryIdentityStore()
+	loginStateStore := store.NewInMemoryLoginStateStore()
+	loginAttemptStore := store.NewInMemoryLoginAttemptStore()
+
+	// Register OAuth clients and API keys
+	for clientID, secret := range cfg.OAuthClients {
+		if _, err := clientStore.RegisterClient(clientID, secret); err != nil {
+			log.Fatalf("Failed to register OAuth client %q: %v", clientID, err)
+		}
+	}
+	for _, clientID := range cfg.OAuthPublicClients {
+		clientStore.RegisterPublicClient(clientID)
+	}
+	for clientID, apiKey := range cfg.APIKeys {
+		clientStore.RegisterAPIKey(clientID, apiKey)
+	}
+
+	// Initialize upstream OIDC providers
+	var oidcProviders []*oidc.Provider
+	for _, providerCfg := range cfg.OIDCProviders {
+		oidcProviders = append(oidcProviders, oidc.NewProvider(oidc.ProviderConfig{
+			Name:         providerCfg.Name,
+			Issuer:       providerCfg.Issuer,
+			ClientID:     providerCfg.ClientID,
+			ClientSecret: providerCfg.ClientSecret,
+			RedirectURL:  providerCfg.RedirectURL,
+			Scopes:       providerCfg.Scopes,
+		}, nil))
+	}
+
+	// Initialize the authenticator chain: local passwords first, then the directory
+	authenticators := []auth.Authenticator{userStore}
+	if cfg.LDAP.URL != "" {
+		ldapAuthenticator, err := ldapauth.NewAuthenticator(ldapauth.Config{
+			URL:            cfg.LDAP.URL,
+			StartTLS:       cfg.LDAP.StartTLS,
+			BindDN:         cfg.LDAP.BindDN,
+			BindPassword:   cfg.LDAP.BindPassword,
+			BaseDN:         cfg.LDAP.BaseDN,
+			UserFilter:     cfg.LDAP.UserFilter,
+			EmailAttribute: cfg.LDAP.EmailAttribute,
+			GroupAttribute: cfg.LDAP.GroupAttribute,
+			GroupRoles:     cfg.LDAP.GroupRoles,
+		}, userStore)
+		if err != nil {
+			log.Fatalf("Failed to configure LDAP: %v", err)
+		}
+		authenticators = append(authenticators, ldapAuthenticator)
+	}
+	authenticator := auth.NewChainAuthenticator(authenticators...)
+	lockout := auth.NewAccountLockout(auth.LockoutPolicy{
+		Threshold:     cfg.LockoutThreshold,
+		BaseDelay:     cfg.LockoutBaseDelay,
+		MaxDelay:      cfg.LockoutMaxDelay,
+		FailureWindow: cfg.LockoutFailureWindow,
+	}, loginAttemptStore, emails)
+
+	// Initialize mail delivery
+	var mailer mail.Sender = mail.NewLogSender()
+	if cfg.SMTPAddr != "" {
+		mailer = mail.NewSMTPSender(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	}
+
+	// Initialize password policy
+	passwordPolicy := password.Policy{
+		MinLength:      cfg.PasswordMinLength,
+		MaxLength:      cfg.PasswordMaxLength,
+		MinCharClasses: cfg.PasswordMinCharClasses,
+		MinEntropyBits: float64(cfg.PasswordMinEntropyBits),
+		History:        cfg.PasswordHistory,
+	}
+	passwordExpiry := password.ExpiryPolicy{
+		MaxAge:     cfg.PasswordMaxAge,
+		RoleMaxAge: cfg.PasswordRoleMaxAge,
+	}
+	if cfg.BreachedPasswordsDir != "" {
+		corpus, err := password.OpenRangeCorpus(cfg.BreachedPasswordsDir)
+		if err != nil {
+			log.Fatalf("Failed to open breached passwords: %v", err)
+		}
+		passwordPolicy.Breached = corpus
+	}
 
 	// Initialize auth service
-	authService := auth.NewJWTAuthService(cfg.JWTSecret, cfg.AccessTokenExp, cfg.RefreshTokenExp, tokenStore)
+	var authService auth.AuthService = auth.NewJWTAuthService(cfg.JWTSecret, cfg.AccessTokenExp, cfg.RefreshTokenExp, tokenStore, revocations)
+	if cfg.TokenCacheSize > 0 {
+		cachedTokens := auth.NewCachedAuthService(authService, cfg.TokenCacheSize, cfg.TokenCacheTTL)
+		expvar.Publish("token_cache", expvar.Func(func() any { return cachedTokens.Stats() }))
+		authService = cachedTokens
+	}
 
 	// Initialize middleware
-	authMiddleware := auth.NewAuthMiddleware(authService, tokenStore)
+	authMiddleware := auth.NewAuthMiddleware(authService, tokenStore, revocations)
+	clientAuthMiddleware := auth.NewClientAuthMiddleware(clientStore)
+	rateLimiter := ratelimit.NewMiddleware(rateLimitStore, cfg.RateLimit.TrustProxy)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(userStore, authService, tokenStore)
+	authHandler := handlers.NewAuthHandler(
+		userStore,
+		authService,
+		tokenStore,
+		revocations,
+		authenticator,
+		lockout,
+		mailer,
+		cfg.PrivateSignUp,
+		passwordPolicy,
+		passwordExpiry,
+		disposableDomains,
+	)
 	userHandler := handlers.NewUserHandler(userStore)
+	adminHandler := handlers.NewAdminHandler(lockout, userStore, revocations)
+	oauthHandler := handlers.NewOAuthHandler(
+		userStore,
+		authService,
+		tokenStore,
+		revocations,
+		deviceStore,
+		clientStore,
+		cfg.DeviceVerificationURI,
+		cfg.DeviceCodeExp,
+		cfg.DevicePollInterval,
+	)
+
+	federationHandler := handlers.NewFederationHandler(
+		oidcProviders,
+		userStore,
+		identityStore,
+		loginStateStore,
+		authService,
+		cfg.OIDCStateExp,
+	)
+
+	// Initialize SAML service provider
+	var samlHandler *handlers.SAMLHandler
+	if cfg.SAML.IdPCertFile != "" {
+		certPEM, err := os.ReadFile(cfg.SAML.IdPCertFile)
+		if err != nil {
+			log.Fatalf("Failed to read SAML IdP certificate: %v", err)
+		}
+		idpCertificates, err := saml.ParseCertificates(certPEM)
+		if err != nil {
+			log.Fatalf("Failed to parse SAML IdP certificate: %v", err)
+		}
+		serviceProvider, err := saml.NewServiceProvider(saml.Config{
+			EntityID:        cfg.SAML.EntityID,
+			ACSURL:          cfg.SAML.ACSURL,
+			IdPEntityID:     cfg.SAML.IdPEntityID,
+			IdPCertificates: idpCertificates,
+			EmailAttribute:  cfg.SAML.EmailAttribute,
+			RoleAttribute:   cfg.SAML.RoleAttribute,
+		}, store.NewInMemoryReplayStore())
+		if err != nil {
+			log.Fatalf("Failed to configure SAML: %v", err)
+		}
+		samlHandler = handlers.NewSAMLHandler(serviceProvider, userStore, identityStore, authService)
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
 
 	// Auth routes
-	mux.HandleFunc("/api/auth/signup", authHandler.SignUp)
-	mux.HandleFunc("/api/auth/signin", authHandler.SignIn)
-	mux.HandleFunc("/api/auth/refresh", authHandler.RefreshToken)
+	mux.HandleFunc("/api/auth/signup", rateLimiter.Limit([]ratelimit.Rule{
+		{Policy: cfg.RateLimit.SignUpIP, Key: rateLimiter.ByIP()},
+	}, authHandler.SignUp))
+	mux.HandleFunc("/api/auth/signin", rateLimiter.Limit([]ratelimit.Rule{
+		{Policy: cfg.RateLimit.SignInIP, Key: rateLimiter.ByIP()},
+		{Policy: cfg.RateLimit.SignInEmail, Key: ratelimit.ByEmail(emails)},
+	}, authHandler.SignIn))
+	mux.HandleFunc("/api/auth/refresh", rateLimiter.Limit([]ratelimit.Rule{
+		{Policy: cfg.RateLimit.RefreshIP, Key: rateLimiter.ByIP()},
+		{Policy: cfg.RateLimit.RefreshToken, Key: ratelimit.ByRefreshToken()},
+	}, authHandler.RefreshToken))
 	mux.HandleFunc("/api/auth/revoke", authMiddleware.Authenticate(authHandler.RevokeToken))
+	mux.HandleFunc("/api/auth/signout", authMiddleware.Authenticate(authHandler.SignOut))
 	mux.HandleFunc("/api/auth/verify", authMiddleware.Authenticate(authHandler.VerifyToken))
+	mux.HandleFunc("/api/auth/password", authMiddleware.AuthenticatePasswordChange(authHandler.ChangePassword))
+
+	// Federated sign-in routes
+	mux.HandleFunc("/api/auth/oidc/login", federationHandler.Login)
+	mux.HandleFunc("/api/auth/oidc/callback", federationHandler.Callback)
+	mux.HandleFunc("/api/auth/oidc/link", authMiddleware.Authenticate(federationHandler.Link))
+
+	// SAML routes
+	if samlHandler != nil {
+		mux.HandleFunc("/saml/metadata", samlHandler.Metadata)
+		mux.HandleFunc("/saml/acs", samlHandler.AssertionConsumerService)
+	}
 
 	// User routes
 	mux.HandleFunc("/api/auth/me", authMiddleware.Authenticate(userHandler.GetUserInfo))
 
+	// Admin routes
+	mux.HandleFunc("/api/admin/unlock", authMiddleware.RequireRole(cfg.AdminRole, adminHandler.UnlockAccount))
+	mux.HandleFunc("/api/admin/users/import", authMiddleware.RequireRole(cfg.AdminRole, adminHandler.ImportUsers))
+	mux.HandleFunc("/api/admin/users/revoke", authMiddleware.RequireRole(cfg.AdminRole, adminHandler.RevokeSessions))
+	mux.HandleFunc("/api/admin/vars", authMiddleware.RequireRole(cfg.AdminRole, expvar.Handler().ServeHTTP))
+
+	// OAuth routes
+	mux.HandleFunc("/oauth/device/code", rateLimiter.Limit([]ratelimit.Rule{
+		{Policy: cfg.RateLimit.DeviceCodeIP, Key: rateLimiter.ByIP()},
+	}, oauthHandler.DeviceAuthorization))
+	mux.HandleFunc("/oauth/device/approve", authMiddleware.Authenticate(oauthHandler.ApproveDevice))
+	mux.HandleFunc("/oauth/token", rateLimiter.Limit([]ratelimit.Rule{
+		{Policy: cfg.RateLimit.OAuthTokenIP, Key: rateLimiter.ByIP()},
+	}, oauthHandler.Token))
+	mux.HandleFunc("/oauth/introspect", clientAuthMiddleware.Authenticate(oauthHandler.Introspect))
+	mux.HandleFunc("/oauth/revoke", clientAuthMiddleware.Authenticate(oauthHandler.Revoke))
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,112 @@
+// Command import-users bulk-imports users migrated from another system through
+// the admin import endpoint. The input file holds one JSON user record per
+// line: {"email": "...", "password_hash": "...", "roles": [...]}.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+func main() {
+	serverURL := flag.String("url", "http://localhost:8080", "auth service base URL")
+	file := flag.String("file", "", "JSON Lines file of users to import")
+	batchSize := flag.Int("batch", 500, "users per request (at most 1000)")
+	flag.Parse()
+
+	// The admin access token is read from the environment to keep it out of shell history
+	token := os.Getenv("AUTH_ADMIN_TOKEN")
+	if *file == "" || token == "" {
+		fmt.Fprintln(os.Stderr, "usage: AUTH_ADMIN_TOKEN=<admin access token> import-users -file users.jsonl [-url http://localhost:8080]")
+		os.Exit(2)
+	}
+
+	input, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *file, err)
+	}
+	defer input.Close()
+
+	// Read records, remembering the line each came from
+	var records []models.ImportUserRecord
+	var lines []int
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record models.ImportUserRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			log.Fatalf("%s:%d: %v", *file, line, err)
+		}
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read %s: %v", *file, err)
+	}
+
+	// Send records in batches
+	imported, failed := 0, 0
+	for start := 0; start < len(records); start += *batchSize {
+		end := start + *batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		result, err := importBatch(*serverURL, token, records[start:end])
+		if err != nil {
+			log.Fatalf("Import stopped at line %d: %v", lines[start], err)
+		}
+		imported += result.Imported
+		for _, failure := range result.Failures {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s:%d: %s: %s\n", *file, lines[start+failure.Index], failure.Email, failure.Error)
+		}
+	}
+
+	fmt.Printf("Imported %d users, %d failed\n", imported, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// importBatch posts one batch of records to the admin import endpoint
+func importBatch(serverURL, token string, records []models.ImportUserRecord) (models.ImportUsersResponse, error) {
+	var result models.ImportUsersResponse
+	body, err := json.Marshal(models.ImportUsersRequest{Users: records})
+	if err != nil {
+		return result, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(serverURL, "/")+"/api/admin/users/import", bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp models.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return result, fmt.Errorf("server returned %d: %s", resp.StatusCode, errResp.Message)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return result, err
+}
@@ -0,0 +1,37 @@
+// Command init-keyring creates the keyring that encrypts users' personal
+// fields, with new random data and index keys wrapped by the current
+// key-encryption key. Run it once, before enabling encryption, and copy the keyring to every
+// replica; the service refuses to start without it. An existing keyring is
+// never replaced.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sanskarm98/auth-service/internal/pii"
+)
+
+func main() {
+	kmsFile := flag.String("kms", os.Getenv("PII_KMS_FILE"), "key-encryption key file")
+	keyringFile := flag.String("keyring", os.Getenv("PII_KEYRING_FILE"), "keyring file to create")
+	flag.Parse()
+
+	if *kmsFile == "" || *keyringFile == "" {
+		fmt.Fprintln(os.Stderr, "usage: init-keyring -kms keys.txt -keyring keyring.json")
+		os.Exit(2)
+	}
+
+	kms, err := pii.LoadFileKMS(*kmsFile)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", *kmsFile, err)
+	}
+	if _, err := pii.CreateKeyring(context.Background(), *keyringFile, kms); err != nil {
+		log.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	fmt.Printf("Created %s wrapped with key-encryption key version %s\n", *keyringFile, kms.CurrentKeyID())
+}
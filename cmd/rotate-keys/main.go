@@ -0,0 +1,41 @@
+// Command rotate-keys re-wraps the keyring that encrypts users' personal
+// fields with the current key-encryption key. Add a key with a higher version
+// to the KMS file first. The data keys themselves don't change, so stored
+// records aren't rewritten and running replicas are unaffected.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sanskarm98/auth-service/internal/pii"
+)
+
+func main() {
+	kmsFile := flag.String("kms", os.Getenv("PII_KMS_FILE"), "key-encryption key file")
+	keyringFile := flag.String("keyring", os.Getenv("PII_KEYRING_FILE"), "keyring file")
+	flag.Parse()
+
+	if *kmsFile == "" || *keyringFile == "" {
+		fmt.Fprintln(os.Stderr, "usage: rotate-keys -kms keys.txt -keyring keyring.json")
+		os.Exit(2)
+	}
+
+	kms, err := pii.LoadFileKMS(*kmsFile)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", *kmsFile, err)
+	}
+	ctx := context.Background()
+	keyring, err := pii.OpenKeyring(ctx, *keyringFile, kms)
+	if err != nil {
+		log.Fatalf("Failed to open keyring: %v", err)
+	}
+	if err := keyring.Rewrap(ctx); err != nil {
+		log.Fatalf("Failed to re-wrap keyring: %v", err)
+	}
+
+	fmt.Printf("Re-wrapped %s with key-encryption key version %s\n", *keyringFile, kms.CurrentKeyID())
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// Authenticator verifies a user's credentials. store.UserStore satisfies it
+// for accounts with a locally stored password. Rejected credentials are
+// reported as store.ErrInvalidCredentials; any other error means the
+// credentials could not be checked.
+type Authenticator interface {
+	Authenticate(ctx context.Context, email, password string) (models.User, error)
+}
+
+// ChainAuthenticator tries a list of authenticators in order and accepts the
+// first one that verifies the credentials
+type ChainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// NewChainAuthenticator creates a new instance of ChainAuthenticator
+func NewChainAuthenticator(authenticators ...Authenticator) *ChainAuthenticator {
+	return &ChainAuthenticator{
+		authenticators: authenticators,
+	}
+}
+
+// Authenticate verifies user credentials against each authenticator in turn.
+// If none accepts them, the first error other than rejected credentials is
+// returned, so an unavailable backend isn't mistaken for a wrong password.
+func (c *ChainAuthenticator) Authenticate(ctx context.Context, email, password string) (models.User, error) {
+	var firstErr error
+	for _, authenticator := range c.authenticators {
+		user, err := authenticator.Authenticate(ctx, email, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, store.ErrInvalidCredentials) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return models.User{}, firstErr
+	}
+	return models.User{}, store.ErrInvalidCredentials
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/cache"
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// CachedAuthService wraps an AuthService, caching the claims of validated
+// tokens by token hash so a token used repeatedly is parsed and verified
+// once. Claims are cached no longer than the token is valid, and failed
+// validations aren't cached. Callers still check revocation on every use,
+// so a cached result never outlives a revocation.
+type CachedAuthService struct {
+	AuthService
+	tokens *cache.LRU[[sha256.Size]byte, *models.Claims]
+}
+
+// NewCachedAuthService creates a new instance of CachedAuthService holding up
+// to capacity validated tokens for at most ttl
+func NewCachedAuthService(authService AuthService, capacity int, ttl time.Duration) *CachedAuthService {
+	return &CachedAuthService{
+		AuthService: authService,
+		tokens:      cache.NewLRU[[sha256.Size]byte, *models.Claims](capacity, ttl),
+	}
+}
+
+// Stats returns the cache's hit and miss counts
+func (s *CachedAuthService) Stats() cache.Stats {
+	return s.tokens.Stats()
+}
+
+// ValidateToken validates a JWT token and returns its claims, from the cache
+// if possible
+func (s *CachedAuthService) ValidateToken(tokenString string) (*models.Claims, error) {
+	key := sha256.Sum256([]byte(tokenString))
+	if claims, ok := s.tokens.Get(key); ok {
+		copied := *claims
+		return &copied, nil
+	}
+
+	claims, err := s.AuthService.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	var expires time.Time
+	if claims.ExpiresAt != nil {
+		expires = claims.ExpiresAt.Time
+	}
+	copied := *claims
+	s.tokens.AddUntil(key, &copied, expires)
+	return claims, nil
+}
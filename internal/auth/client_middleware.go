@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+const (
+	// ClientContextKey is the key for the authenticated OAuth client in the request context
+	ClientContextKey contextKey = "client"
+
+	// APIKeyHeader is the header carrying an API key
+	APIKeyHeader = "X-API-Key"
+)
+
+// ClientAuthMiddleware handles OAuth client authentication for protocol endpoints
+type ClientAuthMiddleware struct {
+	clientStore store.ClientStore
+}
+
+// NewClientAuthMiddleware creates a new instance of ClientAuthMiddleware
+func NewClientAuthMiddleware(clientStore store.ClientStore) *ClientAuthMiddleware {
+	return &ClientAuthMiddleware{
+		clientStore: clientStore,
+	}
+}
+
+// Authenticate is a middleware that requires client credentials (HTTP Basic or
+// form parameters, RFC 6749 section 2.3.1) or an API key
+func (m *ClientAuthMiddleware) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := m.authenticateRequest(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth-service"`)
+			utils.SendOAuthErrorResponse(w, http.StatusUnauthorized, models.OAuthErrInvalidClient, models.ErrInvalidClient)
+			return
+		}
+
+		// Set client in context and proceed
+		ctx := context.WithValue(r.Context(), ClientContextKey, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// authenticateRequest checks the supported client authentication methods in turn
+func (m *ClientAuthMiddleware) authenticateRequest(r *http.Request) (models.Client, bool) {
+	// API key
+	if apiKey := r.Header.Get(APIKeyHeader); apiKey != "" {
+		return m.clientStore.AuthenticateAPIKey(apiKey)
+	}
+
+	// HTTP Basic, with form-urlencoded credentials
+	if clientID, secret, ok := r.BasicAuth(); ok {
+		clientID, err := url.QueryUnescape(clientID)
+		if err != nil {
+			return models.Client{}, false
+		}
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return models.Client{}, false
+		}
+		return m.clientStore.AuthenticateClient(clientID, secret)
+	}
+
+	// Credentials in the request body
+	if err := r.ParseForm(); err != nil {
+		return models.Client{}, false
+	}
+	clientID := r.PostForm.Get("client_id")
+	secret := r.PostForm.Get("client_secret")
+	if clientID == "" || secret == "" {
+		return models.Client{}, false
+	}
+	return m.clientStore.AuthenticateClient(clientID, secret)
+}
+
+// GetClientFromContext extracts the authenticated client from request context
+func GetClientFromContext(ctx context.Context) (models.Client, bool) {
+	client, ok := ctx.Value(ClientContextKey).(models.Client)
+	return client, ok
+}
@@ -1,51 +1,164 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/revocation"
 	"github.com/sanskarm98/auth-service/internal/store"
 )
 
 // AuthService defines the interface for authentication operations
 type AuthService interface {
-	GenerateTokenPair(user models.User) (models.TokenPair, error)
+	GenerateTokenPair(ctx context.Context, user models.User) (models.TokenPair, error)
+	GenerateClientTokenPair(ctx context.Context, user models.User, clientID, scope string) (models.TokenPair, error)
+	RotateTokenPair(ctx context.Context, user models.User, previous []string) (models.TokenPair, error)
+	RefreshSession(userID string, previous []string) (*models.Claims, error)
+	GeneratePasswordChangeToken(user models.User) (string, error)
 	ValidateToken(tokenString string) (*models.Claims, error)
 }
 
+// passwordChangeTokenExp is how long a restricted password-change token is valid
+const passwordChangeTokenExp = 5 * time.Minute
+
+// Errors returned by RotateTokenPair for refresh tokens that may no longer be used
+var (
+	ErrRefreshTokenExpired = errors.New("auth: refresh token expired")
+	ErrSessionRevoked      = errors.New("auth: session revoked")
+)
+
 // JWTAuthService implements AuthService with JWT tokens
 type JWTAuthService struct {
 	jwtSecret       string
 	accessTokenExp  time.Duration
 	refreshTokenExp time.Duration
 	tokenStore      store.TokenStore
+	revocations     *revocation.Cache
 }
 
-// NewJWTAuthService creates a new instance of JWTAuthService
+// NewJWTAuthService creates a new instance of JWTAuthService. Sessions and
+// users revoked through revocations can't be refreshed.
 func NewJWTAuthService(
 	jwtSecret string,
 	accessTokenExp time.Duration,
 	refreshTokenExp time.Duration,
 	tokenStore store.TokenStore,
+	revocations *revocation.Cache,
 ) *JWTAuthService {
 	return &JWTAuthService{
 		jwtSecret:       jwtSecret,
 		accessTokenExp:  accessTokenExp,
 		refreshTokenExp: refreshTokenExp,
 		tokenStore:      tokenStore,
+		revocations:     revocations,
 	}
 }
 
 // GenerateTokenPair creates a new access and refresh token pair
-func (s *JWTAuthService) GenerateTokenPair(user models.User) (models.TokenPair, error) {
+func (s *JWTAuthService) GenerateTokenPair(ctx context.Context, user models.User) (models.TokenPair, error) {
+	return s.GenerateClientTokenPair(ctx, user, "", "")
+}
+
+// GenerateClientTokenPair creates a new access and refresh token pair issued to
+// an OAuth client, recording the client and granted scope in the access token
+func (s *JWTAuthService) GenerateClientTokenPair(ctx context.Context, user models.User, clientID, scope string) (models.TokenPair, error) {
+	return s.generateTokenPair(ctx, user, clientID, scope, uuid.New().String(), jwt.NewNumericDate(time.Now()))
+}
+
+// RotateTokenPair creates a new token pair for a consumed refresh token, given
+// the access tokens issued with it. The new pair continues their session and
+// is issued to the same client with the same scope, and those that haven't
+// expired are carried over to the new refresh token so that
+// revoking it also revokes them. It fails with ErrRefreshTokenExpired once
+// the refresh token lifetime has passed since the newest of them was issued,
+// along with the refresh token, and with ErrSessionRevoked if the session or
+// the user's sessions have been revoked since.
+func (s *JWTAuthService) RotateTokenPair(ctx context.Context, user models.User, previous []string) (models.TokenPair, error) {
+	session, err := s.RefreshSession(user.ID, previous)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	// Continue the session, or start one if the tokens predate sessions
+	sessionID, authTime := session.SessionID, session.AuthTime
+	if sessionID == "" {
+		sessionID, authTime = uuid.New().String(), jwt.NewNumericDate(time.Now())
+	}
+
+	tokenPair, err := s.generateTokenPair(ctx, user, session.ClientID, session.Scope, sessionID, authTime)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+
+	// Expired tokens no longer need revoking, so only live ones are carried
+	// over and the list doesn't grow over the session's lifetime
+	for _, accessToken := range previous {
+		claims, err := s.parseToken(accessToken, jwt.WithoutClaimsValidation())
+		if err != nil || claims.ExpiresAt == nil || !claims.ExpiresAt.After(time.Now()) {
+			continue
+		}
+		if err := s.tokenStore.AddDerivedAccessToken(ctx, tokenPair.RefreshToken, accessToken); err != nil {
+			return models.TokenPair{}, err
+		}
+	}
+	return tokenPair, nil
+}
+
+// RefreshSession returns the session a refresh token of userID continues,
+// given the access tokens issued with it, which may have expired: its ID and
+// authentication time, and the client and scope it was issued to. The newest
+// of the tokens was issued with the refresh token, so it dates the refresh
+// token and names its client. It fails with the errors RotateTokenPair would
+// if the refresh token may no longer be used.
+func (s *JWTAuthService) RefreshSession(userID string, previous []string) (*models.Claims, error) {
+	session := &models.Claims{UserID: userID}
+	var issuedAt time.Time
+	for _, accessToken := range previous {
+		claims, err := s.parseToken(accessToken, jwt.WithoutClaimsValidation())
+		if err != nil || claims.UserID != userID {
+			continue
+		}
+		if session.SessionID == "" && claims.SessionID != "" {
+			session.SessionID, session.AuthTime = claims.SessionID, claims.AuthTime
+		}
+		if claims.IssuedAt != nil && claims.IssuedAt.After(issuedAt) {
+			issuedAt = claims.IssuedAt.Time
+			session.ClientID, session.Scope = claims.ClientID, claims.Scope
+		}
+	}
+	if issuedAt.IsZero() || time.Since(issuedAt) > s.refreshTokenExp {
+		return nil, ErrRefreshTokenExpired
+	}
+	session.IssuedAt = jwt.NewNumericDate(issuedAt)
+
+	// Revoking a session or user marks its refresh tokens too
+	revoked, err := s.revocations.ClaimsRevoked(session)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrSessionRevoked
+	}
+	return session, nil
+}
+
+// generateTokenPair creates a new access and refresh token pair in a session
+func (s *JWTAuthService) generateTokenPair(ctx context.Context, user models.User, clientID, scope, sessionID string, authTime *jwt.NumericDate) (models.TokenPair, error) {
 	// Create access token
 	accessExp := time.Now().Add(s.accessTokenExp)
 	accessClaims := models.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ClientID:  clientID,
+		Scope:     scope,
+		Roles:     user.AllRoles(),
+		SessionID: sessionID,
+		AuthTime:  authTime,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(accessExp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -62,8 +175,13 @@ func (s *JWTAuthService) GenerateTokenPair(user models.User) (models.TokenPair,
 	// Create refresh token (simple UUID)
 	refreshTokenString := uuid.New().String()
 
-	// Store refresh token
-	s.tokenStore.StoreRefreshToken(refreshTokenString, user.ID)
+	// Store refresh token and remember which access token was issued with it
+	if err := s.tokenStore.StoreRefreshToken(ctx, refreshTokenString, user.ID); err != nil {
+		return models.TokenPair{}, err
+	}
+	if err := s.tokenStore.AddDerivedAccessToken(ctx, refreshTokenString, accessTokenString); err != nil {
+		return models.TokenPair{}, err
+	}
 
 	return models.TokenPair{
 		AccessToken:  accessTokenString,
@@ -71,8 +189,32 @@ func (s *JWTAuthService) GenerateTokenPair(user models.User) (models.TokenPair,
 	}, nil
 }
 
+// GeneratePasswordChangeToken creates a short-lived access token that only
+// allows changing the user's password. No refresh token is issued with it.
+func (s *JWTAuthService) GeneratePasswordChangeToken(user models.User) (string, error) {
+	claims := models.Claims{
+		UserID: user.ID,
+		Email:  user.Email,
+		Scope:  models.ScopePasswordChange,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(passwordChangeTokenExp)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   user.ID,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.jwtSecret))
+}
+
 // ValidateToken validates a JWT token and returns its claims
 func (s *JWTAuthService) ValidateToken(tokenString string) (*models.Claims, error) {
+	return s.parseToken(tokenString)
+}
+
+// parseToken verifies a JWT token's signature and returns its claims, with
+// the claims validated unless options say otherwise
+func (s *JWTAuthService) parseToken(tokenString string, options ...jwt.ParserOption) (*models.Claims, error) {
 	// Parse and validate token
 	claims := &models.Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -81,7 +223,7 @@ func (s *JWTAuthService) ValidateToken(tokenString string) (*models.Claims, erro
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(s.jwtSecret), nil
-	})
+	}, options...)
 
 	// Handle parsing errors
 	if err != nil {
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/revocation"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// newTestService returns a service issuing refresh tokens valid for
+// refreshTokenExp, with its token store and revocations
+func newTestService(t *testing.T, refreshTokenExp time.Duration) (*JWTAuthService, *store.InMemoryTokenStore, *revocation.Cache) {
+	tokens := store.NewInMemoryTokenStore(0)
+	revocations := revocation.NewCache(revocation.NewLocalBus(), time.Minute, refreshTokenExp)
+	t.Cleanup(revocations.Close)
+	return NewJWTAuthService("secret", time.Minute, refreshTokenExp, tokens, revocations), tokens, revocations
+}
+
+func TestRotateTokenPairDropsExpiredTokens(t *testing.T) {
+	ctx := context.Background()
+	service, tokens, _ := newTestService(t, time.Hour)
+	user := models.User{ID: "user-1", Email: "user@example.com"}
+
+	first, err := service.GenerateTokenPair(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+
+	// An access token that expired earlier in the session
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, models.Claims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			Subject:   user.ID,
+		},
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("sign expired token: %v", err)
+	}
+
+	rotated, err := service.RotateTokenPair(ctx, user, []string{expired, first.AccessToken})
+	if err != nil {
+		t.Fatalf("RotateTokenPair: %v", err)
+	}
+	derived, err := tokens.GetDerivedAccessTokens(ctx, rotated.RefreshToken)
+	if err != nil {
+		t.Fatalf("GetDerivedAccessTokens: %v", err)
+	}
+	for _, token := range derived {
+		if token == expired {
+			t.Error("expired access token was carried over")
+		}
+	}
+	if len(derived) != 2 {
+		t.Errorf("got %d derived tokens, want the live earlier token and the new one", len(derived))
+	}
+
+	// The new access token continues the session of the earlier one
+	before, _ := service.ValidateToken(first.AccessToken)
+	after, _ := service.ValidateToken(rotated.AccessToken)
+	if before == nil || after == nil || before.SessionID != after.SessionID {
+		t.Error("rotation started a new session")
+	}
+}
+
+func TestRotateTokenPairKeepsClient(t *testing.T) {
+	ctx := context.Background()
+	service, tokens, _ := newTestService(t, time.Hour)
+	user := models.User{ID: "user-1", Email: "user@example.com"}
+
+	// A device grant's tokens are bound to the device's client
+	pair, err := service.GenerateClientTokenPair(ctx, user, "cli", "profile")
+	if err != nil {
+		t.Fatalf("GenerateClientTokenPair: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, derived, err := tokens.ConsumeRefreshToken(ctx, pair.RefreshToken)
+		if err != nil {
+			t.Fatalf("ConsumeRefreshToken: %v", err)
+		}
+		session, err := service.RefreshSession(user.ID, derived)
+		if err != nil {
+			t.Fatalf("RefreshSession: %v", err)
+		}
+		if session.ClientID != "cli" || session.Scope != "profile" {
+			t.Errorf("refresh %d: session has client %q and scope %q, want cli and profile", i+1, session.ClientID, session.Scope)
+		}
+		if pair, err = service.RotateTokenPair(ctx, user, derived); err != nil {
+			t.Fatalf("RotateTokenPair: %v", err)
+		}
+		claims, err := service.ValidateToken(pair.AccessToken)
+		if err != nil {
+			t.Fatalf("ValidateToken: %v", err)
+		}
+		if claims.ClientID != "cli" || claims.Scope != "profile" {
+			t.Errorf("refresh %d: access token has client %q and scope %q, want cli and profile", i+1, claims.ClientID, claims.Scope)
+		}
+	}
+}
+
+func TestRotateTokenPairRejectsRevokedSessions(t *testing.T) {
+	ctx := context.Background()
+	user := models.User{ID: "user-1", Email: "user@example.com"}
+	revoke := map[string]func(revocations *revocation.Cache, sessionID string) error{
+		"session": func(revocations *revocation.Cache, sessionID string) error {
+			return revocations.RevokeSession(ctx, sessionID)
+		},
+		"user": func(revocations *revocation.Cache, sessionID string) error {
+			// Sessions started within the epoch's second are kept, so start
+			// the revoked one a second earlier
+			time.Sleep(time.Second)
+			return revocations.RevokeUser(ctx, user.ID)
+		},
+	}
+	for name, revoke := range revoke {
+		t.Run(name, func(t *testing.T) {
+			service, tokens, revocations := newTestService(t, time.Hour)
+			pair, err := service.GenerateTokenPair(ctx, user)
+			if err != nil {
+				t.Fatalf("GenerateTokenPair: %v", err)
+			}
+			claims, _ := service.ValidateToken(pair.AccessToken)
+			if err := revoke(revocations, claims.SessionID); err != nil {
+				t.Fatalf("revoke: %v", err)
+			}
+
+			_, derived, err := tokens.ConsumeRefreshToken(ctx, pair.RefreshToken)
+			if err != nil {
+				t.Fatalf("ConsumeRefreshToken: %v", err)
+			}
+			if _, err := service.RefreshSession(user.ID, derived); !errors.Is(err, ErrSessionRevoked) {
+				t.Errorf("RefreshSession: got %v, want %v", err, ErrSessionRevoked)
+			}
+			if _, err := service.RotateTokenPair(ctx, user, derived); !errors.Is(err, ErrSessionRevoked) {
+				t.Errorf("RotateTokenPair: got %v, want %v", err, ErrSessionRevoked)
+			}
+
+			// A session started afterwards can still be refreshed
+			next, err := service.GenerateTokenPair(ctx, user)
+			if err != nil {
+				t.Fatalf("GenerateTokenPair: %v", err)
+			}
+			_, derived, err = tokens.ConsumeRefreshToken(ctx, next.RefreshToken)
+			if err != nil {
+				t.Fatalf("ConsumeRefreshToken: %v", err)
+			}
+			if _, err := service.RotateTokenPair(ctx, user, derived); err != nil {
+				t.Errorf("RotateTokenPair for a new session: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateTokenPairRejectsExpiredRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	service, tokens, _ := newTestService(t, time.Nanosecond)
+	user := models.User{ID: "user-1", Email: "user@example.com"}
+	pair, err := service.GenerateTokenPair(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+	_, derived, err := tokens.ConsumeRefreshToken(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("ConsumeRefreshToken: %v", err)
+	}
+	if _, err := service.RotateTokenPair(ctx, user, derived); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Errorf("RotateTokenPair: got %v, want %v", err, ErrRefreshTokenExpired)
+	}
+
+	// A refresh token without any access token to date it is refused too
+	service, _, _ = newTestService(t, time.Hour)
+	if _, err := service.RotateTokenPair(ctx, user, nil); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Errorf("RotateTokenPair without derived tokens: got %v, want %v", err, ErrRefreshTokenExpired)
+	}
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// LockoutPolicy configures how failed sign-ins delay further attempts
+type LockoutPolicy struct {
+	Threshold     int           // failures allowed before a delay applies; 0 disables lockout
+	BaseDelay     time.Duration // delay after reaching the threshold
+	MaxDelay      time.Duration // cap for the doubling delay; equal to BaseDelay for a fixed lockout
+	FailureWindow time.Duration // failures older than this are forgotten
+}
+
+// AccountLockout tracks failed sign-ins per account and locks accounts with
+// an exponentially growing delay once the threshold is reached. Accounts are
+// keyed by the normalized submitted email whether or not they exist, so a
+// lockout doesn't reveal which accounts are registered.
+type AccountLockout struct {
+	policy       LockoutPolicy
+	attemptStore store.LoginAttemptStore
+	emails       *emailaddr.Normalizer
+}
+
+// NewAccountLockout creates a new instance of AccountLockout
+func NewAccountLockout(policy LockoutPolicy, attemptStore store.LoginAttemptStore, emails *emailaddr.Normalizer) *AccountLockout {
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	return &AccountLockout{
+		policy:       policy,
+		attemptStore: attemptStore,
+		emails:       emails,
+	}
+}
+
+// Reserve claims an attempt at the account's password before it is checked.
+// If the account is locked, it returns how long for and true, and the
+// password must not be checked. Otherwise the attempt is counted as a failure
+// straight away, locking the account if that reaches the threshold, so
+// concurrent attempts can't check more passwords than the threshold allows
+// between a check and a count. The caller then settles the attempt: with
+// RecordSuccess after a successful sign-in, with Release if the password
+// turned out right or was never checked, and not at all if it was wrong.
+func (l *AccountLockout) Reserve(email string) (time.Duration, bool) {
+	if l.policy.Threshold <= 0 {
+		return 0, false
+	}
+	now := time.Now()
+	var remaining time.Duration
+	l.attemptStore.UpdateLoginAttempts(l.emails.Key(email), func(attempts *models.LoginAttempts) {
+		if attempts.LockedUntil.After(now) {
+			remaining = attempts.LockedUntil.Sub(now)
+			return
+		}
+		attempts.Failures++
+		attempts.LastFailure = now
+		if attempts.Failures >= l.policy.Threshold {
+			attempts.LockedUntil = now.Add(l.delay(attempts.Failures - l.policy.Threshold))
+		}
+		attempts.ExpiresAt = now.Add(l.policy.FailureWindow)
+		if attempts.ExpiresAt.Before(attempts.LockedUntil) {
+			attempts.ExpiresAt = attempts.LockedUntil
+		}
+	})
+	return remaining, remaining > 0
+}
+
+// Release gives back an attempt reserved by Reserve that didn't fail, such as
+// one whose password couldn't be checked because a backend was unavailable. A
+// lock the reservation caused is lifted; a longer lock from later failures is
+// kept.
+func (l *AccountLockout) Release(email string) {
+	if l.policy.Threshold <= 0 {
+		return
+	}
+	l.attemptStore.UpdateLoginAttempts(l.emails.Key(email), func(attempts *models.LoginAttempts) {
+		if attempts.Failures > 0 {
+			attempts.Failures--
+		}
+		if attempts.Failures < l.policy.Threshold {
+			attempts.LockedUntil = time.Time{}
+		}
+	})
+}
+
+// RecordSuccess clears the failures, including the reserved attempt, after a
+// successful sign-in
+func (l *AccountLockout) RecordSuccess(email string) {
+	l.attemptStore.ResetLoginAttempts(l.emails.Key(email))
+}
+
+// Unlock clears the failures and any lock for an account. It returns false
+// if nothing was recorded for it.
+func (l *AccountLockout) Unlock(email string) bool {
+	return l.attemptStore.ResetLoginAttempts(l.emails.Key(email))
+}
+
+// delay returns BaseDelay doubled once per failure beyond the threshold, capped at MaxDelay
+func (l *AccountLockout) delay(excess int) time.Duration {
+	d := l.policy.BaseDelay
+	for i := 0; i < excess && d < l.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.policy.MaxDelay {
+		d = l.policy.MaxDelay
+	}
+	return d
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// newTestLockout returns a lockout that locks accounts for a minute after
+// threshold failures
+func newTestLockout(threshold int) *AccountLockout {
+	return NewAccountLockout(LockoutPolicy{
+		Threshold:     threshold,
+		BaseDelay:     time.Minute,
+		MaxDelay:      time.Hour,
+		FailureWindow: time.Hour,
+	}, store.NewInMemoryLoginAttemptStore(), emailaddr.NewNormalizer(false))
+}
+
+func TestReserveLimitsConcurrentAttempts(t *testing.T) {
+	const threshold = 3
+	lockout := newTestLockout(threshold)
+
+	// However many attempts race, only threshold of them get to check a password
+	var reserved, rejected int
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			remaining, locked := lockout.Reserve("alice@example.com")
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !locked {
+				reserved++
+				return
+			}
+			rejected++
+			if remaining <= 0 || remaining > time.Minute {
+				t.Errorf("Reserve: locked for %v, want within (0, 1m]", remaining)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != threshold || rejected != 50-threshold {
+		t.Errorf("%d attempts reserved and %d rejected, want %d and %d", reserved, rejected, threshold, 50-threshold)
+	}
+
+	// Other accounts aren't affected, and accounts are keyed by normalized email
+	if _, locked := lockout.Reserve("bob@example.com"); locked {
+		t.Error("Reserve(other account): locked")
+	}
+	if _, locked := lockout.Reserve(" Alice@Example.com"); !locked {
+		t.Error("Reserve(differently spelled email): not locked")
+	}
+}
+
+func TestReserveSettlement(t *testing.T) {
+	const email = "alice@example.com"
+	lockout := newTestLockout(2)
+
+	// A failed attempt stays counted
+	if _, locked := lockout.Reserve(email); locked {
+		t.Fatal("Reserve: locked")
+	}
+
+	// Attempts that weren't failures are given back, including the lock the
+	// last allowed one put on the account
+	for i := 0; i < 3; i++ {
+		if _, locked := lockout.Reserve(email); locked {
+			t.Fatalf("Reserve after %d released attempts: locked", i)
+		}
+		lockout.Release(email)
+	}
+
+	// The second failure locks the account
+	if _, locked := lockout.Reserve(email); locked {
+		t.Fatal("Reserve(second): locked")
+	}
+	if _, locked := lockout.Reserve(email); !locked {
+		t.Fatal("Reserve after threshold failures: not locked")
+	}
+
+	// Unlocking clears the failures, and a successful sign-in clears them too
+	lockout.Unlock(email)
+	if _, locked := lockout.Reserve(email); locked {
+		t.Fatal("Reserve after unlock: locked")
+	}
+	lockout.RecordSuccess(email)
+	for i := 0; i < 2; i++ {
+		if _, locked := lockout.Reserve(email); locked {
+			t.Fatalf("Reserve %d after success: locked", i+1)
+		}
+	}
+}
+
+func TestReserveDisabled(t *testing.T) {
+	lockout := newTestLockout(0)
+	for i := 0; i < 10; i++ {
+		if _, locked := lockout.Reserve("alice@example.com"); locked {
+			t.Fatalf("Reserve %d with lockout disabled: locked", i+1)
+		}
+	}
+}
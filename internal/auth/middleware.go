@@ -3,11 +3,13 @@ package auth
 import (
 	"context"
 	"errors"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/revocation"
 	"github.com/sanskarm98/auth-service/internal/store"
 	"github.com/sanskarm98/auth-service/pkg/utils"
 )
@@ -24,18 +26,34 @@ const (
 type AuthMiddleware struct {
 	authService AuthService
 	tokenStore  store.TokenStore
+	revocations *revocation.Cache
 }
 
-// NewAuthMiddleware creates a new instance of AuthMiddleware
-func NewAuthMiddleware(authService AuthService, tokenStore store.TokenStore) *AuthMiddleware {
+// NewAuthMiddleware creates a new instance of AuthMiddleware. Revoked tokens
+// are looked up in tokenStore, and revoked sessions and users in revocations.
+func NewAuthMiddleware(authService AuthService, tokenStore store.TokenStore, revocations *revocation.Cache) *AuthMiddleware {
 	return &AuthMiddleware{
 		authService: authService,
 		tokenStore:  tokenStore,
+		revocations: revocations,
 	}
 }
 
-// Authenticate is a middleware that verifies the access token in the Authorization header
+// Authenticate is a middleware that verifies the access token in the Authorization header.
+// Restricted password-change tokens are rejected.
 func (m *AuthMiddleware) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return m.authenticate(next, false)
+}
+
+// AuthenticatePasswordChange is a middleware like Authenticate that also
+// accepts restricted password-change tokens, for the change-password endpoint
+func (m *AuthMiddleware) AuthenticatePasswordChange(next http.HandlerFunc) http.HandlerFunc {
+	return m.authenticate(next, true)
+}
+
+// authenticate verifies the access token, accepting restricted tokens only
+// when allowPasswordChange is set
+func (m *AuthMiddleware) authenticate(next http.HandlerFunc, allowPasswordChange bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := extractTokenFromHeader(r)
 		if tokenString == "" {
@@ -44,7 +62,13 @@ func (m *AuthMiddleware) Authenticate(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		// Check if token is revoked
-		if m.tokenStore.IsTokenRevoked(tokenString) {
+		revoked, err := m.tokenStore.IsTokenRevoked(r.Context(), tokenString)
+		if err != nil {
+			log.Printf("auth: checking token revocation: %v", err)
+			utils.SendErrorResponse(w, http.StatusServiceUnavailable, models.ErrServiceUnavailable)
+			return
+		}
+		if revoked {
 			utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrTokenRevoked)
 			return
 		}
@@ -60,12 +84,45 @@ func (m *AuthMiddleware) Authenticate(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// Check if the token's session or user has been revoked
+		revoked, err = m.revocations.ClaimsRevoked(claims)
+		if err != nil {
+			log.Printf("auth: checking session revocation: %v", err)
+			utils.SendErrorResponse(w, http.StatusServiceUnavailable, models.ErrServiceUnavailable)
+			return
+		}
+		if revoked {
+			utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrTokenRevoked)
+			return
+		}
+
+		// Restricted tokens may only be used to change an expired password
+		if claims.Scope == models.ScopePasswordChange && !allowPasswordChange {
+			utils.SendErrorResponse(w, http.StatusForbidden, models.ErrPasswordChangeRequired)
+			return
+		}
+
 		// Set claims in context and proceed
 		ctx := context.WithValue(r.Context(), ClaimsContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
+// RequireRole is a middleware that verifies the access token and requires its
+// claims to include role
+func (m *AuthMiddleware) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return m.Authenticate(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := GetClaimsFromContext(r.Context())
+		for _, claimRole := range claims.Roles {
+			if claimRole == role {
+				next(w, r)
+				return
+			}
+		}
+		utils.SendErrorResponse(w, http.StatusForbidden, models.ErrForbidden)
+	})
+}
+
 // extractTokenFromHeader extracts JWT from Authorization header
 func extractTokenFromHeader(r *http.Request) string {
 	bearerToken := r.Header.Get("Authorization")
@@ -0,0 +1,132 @@
+// Package cache provides the in-memory cache used by the caching decorators
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the lookups made in a cache
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// LRU is a cache holding at most capacity entries, evicting the least
+// recently used one to make room. Entries also expire after a TTL. It is
+// safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	capacity int
+	ttl      time.Duration
+
+	entries map[K]*list.Element
+	order   *list.List // most recently used first
+	mutex   sync.Mutex
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// entry is a cached value and the time it expires
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// NewLRU creates a new instance of LRU
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value cached for key, if it hasn't expired
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[key]
+	if exists && time.Now().Before(element.Value.(*entry[K, V]).expires) {
+		c.order.MoveToFront(element)
+		c.hits.Add(1)
+		return element.Value.(*entry[K, V]).value, true
+	}
+	if exists {
+		c.removeElement(element)
+	}
+	c.misses.Add(1)
+	var zero V
+	return zero, false
+}
+
+// Peek returns the value cached for key, if it hasn't expired, without
+// counting a lookup or marking it recently used
+func (c *LRU[K, V]) Peek(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, exists := c.entries[key]; exists && time.Now().Before(element.Value.(*entry[K, V]).expires) {
+		return element.Value.(*entry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Add caches value for key until the TTL passes
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.AddUntil(key, value, time.Time{})
+}
+
+// AddUntil caches value for key until expires, or until the TTL passes if
+// that is sooner or expires is zero
+func (c *LRU[K, V]) AddUntil(key K, value V, expires time.Time) {
+	if limit := time.Now().Add(c.ttl); expires.IsZero() || expires.After(limit) {
+		expires = limit
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		element.Value = &entry[K, V]{key: key, value: value, expires: expires}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Remove drops the value cached for key, if any
+func (c *LRU[K, V]) Remove(key K) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, exists := c.entries[key]; exists {
+		c.removeElement(element)
+	}
+}
+
+// removeElement drops an entry; callers must hold the lock
+func (c *LRU[K, V]) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry[K, V]).key)
+}
+
+// Stats returns the hits and misses counted so far and the current size
+func (c *LRU[K, V]) Stats() Stats {
+	c.mutex.Lock()
+	size := c.order.Len()
+	c.mutex.Unlock()
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   size,
+	}
+}
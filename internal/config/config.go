@@ -2,7 +2,11 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
 )
 
 // Config holds all configuration for the application
@@ -11,6 +15,160 @@ type Config struct {
 	JWTSecret       string
 	AccessTokenExp  time.Duration
 	RefreshTokenExp time.Duration
+
+	// Device authorization grant (RFC 8628)
+	DeviceCodeExp         time.Duration
+	DevicePollInterval    time.Duration
+	DeviceVerificationURI string
+
+	// OAuth clients (client ID -> secret) and API keys (client ID -> key)
+	// allowed to call protocol endpoints such as token introspection, and
+	// public clients without a secret that may start device authorizations
+	OAuthClients       map[string]string
+	APIKeys            map[string]string
+	OAuthPublicClients []string
+
+	// Upstream OIDC providers for federated sign-in
+	OIDCProviders []OIDCProviderConfig
+	OIDCStateExp  time.Duration
+
+	// LDAP / Active Directory authentication; disabled when LDAP.URL is empty
+	LDAP LDAPConfig
+
+	// SAML 2.0 service provider; disabled when SAML.IdPCertFile is empty
+	SAML SAMLConfig
+
+	// Rate limits for the credential endpoints
+	RateLimit RateLimitConfig
+
+	// Account lockout after repeated failed sign-ins
+	LockoutThreshold     int
+	LockoutBaseDelay     time.Duration
+	LockoutMaxDelay      time.Duration
+	LockoutFailureWindow time.Duration
+
+	// Role required for the admin endpoints
+	AdminRole string
+
+	// Respond to sign-up the same way whether or not the email is registered
+	PrivateSignUp bool
+
+	// Password policy for new passwords
+	PasswordMinLength      int
+	PasswordMaxLength      int
+	PasswordMinCharClasses int
+	PasswordMinEntropyBits int
+	BreachedPasswordsDir   string
+	PasswordHistory        int
+
+	// Password expiry; zero means passwords don't expire
+	PasswordMaxAge     time.Duration
+	PasswordRoleMaxAge map[string]time.Duration
+
+	// Password hashing: "argon2id" or "bcrypt"; hashes made with the other
+	// algorithm or older parameters are upgraded at sign-in
+	PasswordHasher string
+	Argon2Memory   int // in KiB
+	Argon2Time     int
+	Argon2Threads  int
+	BcryptCost     int
+	PepperFile     string // optional "version:base64key" lines
+
+	// Email normalization and sign-up domain block list
+	EmailProviderRules   bool
+	DisposableDomainFile string
+
+	// Outgoing mail; messages are logged when SMTPAddr is empty
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Directory where users and tokens are persisted, with a snapshot taken
+	// every SnapshotEvery changes; everything is kept in memory when empty
+	DataDir       string
+	SnapshotEvery int
+
+	// Redis server shared by all replicas for refresh tokens and revocations,
+	// e.g. "redis://localhost:6379/0"; tokens are kept in memory when empty
+	RedisURL       string
+	RedisKeyPrefix string
+
+	// Raft cluster replicating users and tokens across replicas; disabled
+	// when Raft.NodeID is empty
+	Raft RaftConfig
+
+	// Key-encryption keys, as "version:base64key" lines, that wrap the keys in
+	// KeyringFile; users' personal fields are stored encrypted when set
+	KMSFile     string
+	KeyringFile string
+
+	// Caches of users by ID and of validated access tokens; a size of zero
+	// disables the cache
+	UserCacheSize  int
+	UserCacheTTL   time.Duration
+	TokenCacheSize int
+	TokenCacheTTL  time.Duration
+}
+
+// RaftConfig holds the settings for this node of the Raft cluster
+type RaftConfig struct {
+	NodeID       string
+	Address      string // listen address; defaults to the node's entry in Peers
+	Dir          string
+	Peers        map[string]string // node ID -> "host:port"
+	ApplyTimeout time.Duration
+	// PEM files for the mutual TLS between nodes: this node's certificate
+	// and key, and the CA that issued every node's certificate
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+}
+
+// RateLimitConfig holds the token-bucket policies for each limited route. A
+// policy with a zero Limit is disabled.
+type RateLimitConfig struct {
+	TrustProxy   bool // key clients by the last X-Forwarded-For address
+	SignInIP     models.RateLimitPolicy
+	SignInEmail  models.RateLimitPolicy
+	SignUpIP     models.RateLimitPolicy
+	RefreshIP    models.RateLimitPolicy
+	RefreshToken models.RateLimitPolicy
+	DeviceCodeIP models.RateLimitPolicy
+	OAuthTokenIP models.RateLimitPolicy
+}
+
+// SAMLConfig holds the settings for the SAML service provider
+type SAMLConfig struct {
+	EntityID       string
+	ACSURL         string
+	IdPEntityID    string
+	IdPCertFile    string
+	EmailAttribute string
+	RoleAttribute  string
+}
+
+// LDAPConfig holds the settings for the LDAP authenticator
+type LDAPConfig struct {
+	URL            string
+	StartTLS       bool
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string
+	EmailAttribute string
+	GroupAttribute string
+	GroupRoles     map[string]string
+}
+
+// OIDCProviderConfig holds the settings for one upstream OIDC provider
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 // LoadConfig loads configuration from environment variables with defaults
@@ -31,10 +189,281 @@ func LoadConfig() *Config {
 	// Default to 7 days for refresh token
 	refreshTokenExp := 7 * 24 * time.Hour
 
+	// Device codes are valid for 10 minutes and may be polled every 5 seconds
+	deviceCodeExp := 10 * time.Minute
+	devicePollInterval := 5 * time.Second
+
+	deviceVerificationURI := os.Getenv("DEVICE_VERIFICATION_URI")
+	if deviceVerificationURI == "" {
+		deviceVerificationURI = "http://localhost:" + port + "/device"
+	}
+
+	// Clients and API keys are given as comma-separated "id:secret" pairs
+	oauthClients := parsePairs(os.Getenv("OAUTH_CLIENTS"))
+	apiKeys := parsePairs(os.Getenv("API_KEYS"))
+	var publicClients []string
+	for _, clientID := range strings.Split(os.Getenv("OAUTH_PUBLIC_CLIENTS"), ",") {
+		if clientID = strings.TrimSpace(clientID); clientID != "" {
+			publicClients = append(publicClients, clientID)
+		}
+	}
+
+	// Upstream logins must complete within 10 minutes
+	oidcStateExp := 10 * time.Minute
+
+	// Lock an account for 30 seconds after 5 failed sign-ins, doubling with
+	// each further failure up to 15 minutes; failures are forgotten after a day
+	lockoutThreshold := envInt("LOCKOUT_THRESHOLD", 5)
+	lockoutBaseDelay := envDuration("LOCKOUT_BASE_DELAY", 30*time.Second)
+	lockoutMaxDelay := envDuration("LOCKOUT_MAX_DELAY", 15*time.Minute)
+	lockoutFailureWindow := envDuration("LOCKOUT_FAILURE_WINDOW", 24*time.Hour)
+
+	adminRole := os.Getenv("ADMIN_ROLE")
+	if adminRole == "" {
+		adminRole = "admin"
+	}
+
+	passwordHasher := os.Getenv("PASSWORD_HASHER")
+	if passwordHasher == "" {
+		passwordHasher = "argon2id"
+	}
+
+	smtpFrom := os.Getenv("SMTP_FROM")
+	if smtpFrom == "" {
+		smtpFrom = "no-reply@localhost"
+	}
+
+	redisKeyPrefix := os.Getenv("REDIS_KEY_PREFIX")
+	if redisKeyPrefix == "" {
+		redisKeyPrefix = "auth:"
+	}
+
+	raftDir := os.Getenv("RAFT_DIR")
+	if raftDir == "" {
+		raftDir = "raft"
+	}
+	keyringFile := os.Getenv("PII_KEYRING_FILE")
+	if keyringFile == "" {
+		keyringFile = "keyring.json"
+	}
+
+	// SAML endpoints default to this service's metadata and ACS URLs
+	samlEntityID := os.Getenv("SAML_ENTITY_ID")
+	if samlEntityID == "" {
+		samlEntityID = "http://localhost:" + port + "/saml/metadata"
+	}
+	samlACSURL := os.Getenv("SAML_ACS_URL")
+	if samlACSURL == "" {
+		samlACSURL = "http://localhost:" + port + "/saml/acs"
+	}
+
 	return &Config{
-		Port:            port,
-		JWTSecret:       jwtSecret,
-		AccessTokenExp:  accessTokenExp,
-		RefreshTokenExp: refreshTokenExp,
+		Port:                  port,
+		JWTSecret:             jwtSecret,
+		AccessTokenExp:        accessTokenExp,
+		RefreshTokenExp:       refreshTokenExp,
+		DeviceCodeExp:         deviceCodeExp,
+		DevicePollInterval:    devicePollInterval,
+		DeviceVerificationURI: deviceVerificationURI,
+		OAuthClients:          oauthClients,
+		APIKeys:               apiKeys,
+		OAuthPublicClients:    publicClients,
+		OIDCProviders:         loadOIDCProviders(),
+		OIDCStateExp:          oidcStateExp,
+		LDAP: LDAPConfig{
+			URL:            os.Getenv("LDAP_URL"),
+			StartTLS:       os.Getenv("LDAP_START_TLS") == "true",
+			BindDN:         os.Getenv("LDAP_BIND_DN"),
+			BindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:         os.Getenv("LDAP_BASE_DN"),
+			UserFilter:     os.Getenv("LDAP_USER_FILTER"),
+			EmailAttribute: os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+			GroupAttribute: os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+			GroupRoles:     parseGroupRoles(os.Getenv("LDAP_GROUP_ROLES")),
+		},
+		SAML: SAMLConfig{
+			EntityID:       samlEntityID,
+			ACSURL:         samlACSURL,
+			IdPEntityID:    os.Getenv("SAML_IDP_ENTITY_ID"),
+			IdPCertFile:    os.Getenv("SAML_IDP_CERT_FILE"),
+			EmailAttribute: os.Getenv("SAML_EMAIL_ATTRIBUTE"),
+			RoleAttribute:  os.Getenv("SAML_ROLE_ATTRIBUTE"),
+		},
+		RateLimit: RateLimitConfig{
+			TrustProxy:   os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true",
+			SignInIP:     parseRateLimit("signin_ip", "RATE_LIMIT_SIGNIN_IP", "20/1m"),
+			SignInEmail:  parseRateLimit("signin_email", "RATE_LIMIT_SIGNIN_EMAIL", "5/1m"),
+			SignUpIP:     parseRateLimit("signup_ip", "RATE_LIMIT_SIGNUP_IP", "10/1h"),
+			RefreshIP:    parseRateLimit("refresh_ip", "RATE_LIMIT_REFRESH_IP", "60/1m"),
+			RefreshToken: parseRateLimit("refresh_token", "RATE_LIMIT_REFRESH_TOKEN", "10/1m"),
+			DeviceCodeIP: parseRateLimit("device_code_ip", "RATE_LIMIT_DEVICE_CODE_IP", "10/1m"),
+			OAuthTokenIP: parseRateLimit("oauth_token_ip", "RATE_LIMIT_OAUTH_TOKEN_IP", "60/1m"),
+		},
+		LockoutThreshold:       lockoutThreshold,
+		LockoutBaseDelay:       lockoutBaseDelay,
+		LockoutMaxDelay:        lockoutMaxDelay,
+		LockoutFailureWindow:   lockoutFailureWindow,
+		AdminRole:              adminRole,
+		PrivateSignUp:          os.Getenv("PRIVATE_SIGNUP") == "true",
+		EmailProviderRules:     os.Getenv("EMAIL_PROVIDER_RULES") == "true",
+		DisposableDomainFile:   os.Getenv("DISPOSABLE_DOMAINS_FILE"),
+		PasswordMinLength:      envInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:      envInt("PASSWORD_MAX_LENGTH", 72),
+		PasswordMinCharClasses: envInt("PASSWORD_MIN_CHAR_CLASSES", 0),
+		PasswordMinEntropyBits: envInt("PASSWORD_MIN_ENTROPY_BITS", 40),
+		BreachedPasswordsDir:   os.Getenv("BREACHED_PASSWORDS_DIR"),
+		PasswordHistory:        envInt("PASSWORD_HISTORY", 5),
+		PasswordMaxAge:         time.Duration(envInt("PASSWORD_MAX_AGE_DAYS", 0)) * 24 * time.Hour,
+		PasswordRoleMaxAge:     parseRoleMaxAge(os.Getenv("PASSWORD_ROLE_MAX_AGE_DAYS")),
+		PasswordHasher:         passwordHasher,
+		Argon2Memory:           envInt("ARGON2_MEMORY", 64*1024),
+		Argon2Time:             envInt("ARGON2_TIME", 3),
+		Argon2Threads:          envInt("ARGON2_THREADS", 2),
+		BcryptCost:             envInt("BCRYPT_COST", 10),
+		PepperFile:             os.Getenv("PASSWORD_PEPPER_FILE"),
+		SMTPAddr:               os.Getenv("SMTP_ADDR"),
+		SMTPFrom:               smtpFrom,
+		SMTPUsername:           os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:           os.Getenv("SMTP_PASSWORD"),
+		DataDir:                os.Getenv("DATA_DIR"),
+		SnapshotEvery:          envInt("SNAPSHOT_EVERY", 10000),
+		RedisURL:               os.Getenv("REDIS_URL"),
+		RedisKeyPrefix:         redisKeyPrefix,
+		Raft: RaftConfig{
+			NodeID:       os.Getenv("RAFT_NODE_ID"),
+			Address:      os.Getenv("RAFT_ADDRESS"),
+			Dir:          raftDir,
+			Peers:        parsePairs(os.Getenv("RAFT_PEERS")),
+			ApplyTimeout: envDuration("RAFT_APPLY_TIMEOUT", 5*time.Second),
+			TLSCertFile:  os.Getenv("RAFT_TLS_CERT_FILE"),
+			TLSKeyFile:   os.Getenv("RAFT_TLS_KEY_FILE"),
+			TLSCAFile:    os.Getenv("RAFT_TLS_CA_FILE"),
+		},
+		KMSFile:        os.Getenv("PII_KMS_FILE"),
+		KeyringFile:    keyringFile,
+		UserCacheSize:  envInt("USER_CACHE_SIZE", 10000),
+		UserCacheTTL:   envDuration("USER_CACHE_TTL", 30*time.Second),
+		TokenCacheSize: envInt("TOKEN_CACHE_SIZE", 10000),
+		TokenCacheTTL:  envDuration("TOKEN_CACHE_TTL", 5*time.Minute),
+	}
+}
+
+// envInt reads a non-negative integer from the environment variable key,
+// falling back to the default when unset or malformed
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
+
+// envDuration reads a positive duration such as "30s" from the environment
+// variable key, falling back to the default when unset or malformed
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// parseRoleMaxAge parses a comma-separated list of "role:days" pairs into
+// maximum password ages, skipping malformed entries
+func parseRoleMaxAge(value string) map[string]time.Duration {
+	roleMaxAge := make(map[string]time.Duration)
+	for role, days := range parsePairs(value) {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			continue
+		}
+		roleMaxAge[role] = time.Duration(n) * 24 * time.Hour
+	}
+	return roleMaxAge
+}
+
+// parseRateLimit reads a "limit/period" policy such as "5/1m" from the
+// environment variable key. "off" disables the policy; malformed values fall
+// back to the default.
+func parseRateLimit(name, key, fallback string) models.RateLimitPolicy {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "off" {
+		return models.RateLimitPolicy{Name: name}
+	}
+	for _, candidate := range []string{value, fallback} {
+		limit, period, ok := strings.Cut(candidate, "/")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			continue
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			continue
+		}
+		return models.RateLimitPolicy{Name: name, Limit: n, Period: d}
+	}
+	return models.RateLimitPolicy{Name: name}
+}
+
+// loadOIDCProviders reads the providers listed in OIDC_PROVIDERS (e.g.
+// "google,corp"). Each provider NAME is configured through OIDC_<NAME>_ISSUER,
+// OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL
+// and optionally OIDC_<NAME>_SCOPES (space-separated). Providers missing a
+// required setting are skipped.
+func loadOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// parsePairs parses a comma-separated list of "key:value" pairs, skipping malformed entries
+func parsePairs(value string) map[string]string {
+	pairs := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || key == "" || val == "" {
+			continue
+		}
+		pairs[key] = val
+	}
+	return pairs
+}
+
+// parseGroupRoles parses a semicolon-separated list of "groupDN=role" pairs.
+// The last "=" separates the role, since DNs contain "=" themselves.
+func parseGroupRoles(value string) map[string]string {
+	groupRoles := make(map[string]string)
+	for _, entry := range strings.Split(value, ";") {
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			continue
+		}
+		group, role := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		if group != "" && role != "" {
+			groupRoles[group] = role
+		}
 	}
+	return groupRoles
 }
@@ -0,0 +1,73 @@
+package emailaddr
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DomainBlocklist rejects addresses at listed domains and their subdomains,
+// such as disposable email providers
+type DomainBlocklist struct {
+	domains map[string]bool
+}
+
+// NewDomainBlocklist creates a new instance of DomainBlocklist
+func NewDomainBlocklist(domains []string) *DomainBlocklist {
+	blocklist := &DomainBlocklist{
+		domains: make(map[string]bool, len(domains)),
+	}
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+			domain = ascii
+		}
+		if domain != "" {
+			blocklist.domains[domain] = true
+		}
+	}
+	return blocklist
+}
+
+// LoadDomainBlocklist reads a blocklist with one domain per line. Lines
+// starting with # are ignored.
+func LoadDomainBlocklist(path string) (*DomainBlocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			domains = append(domains, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewDomainBlocklist(domains), nil
+}
+
+// Blocked reports whether address is at a listed domain or one of its subdomains
+func (b *DomainBlocklist) Blocked(address string) bool {
+	domain, err := Domain(address)
+	if err != nil {
+		return false
+	}
+	for {
+		if b.domains[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+		domain = parent
+	}
+}
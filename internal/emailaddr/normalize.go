@@ -0,0 +1,121 @@
+package emailaddr
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidAddress is returned for strings that aren't a plain email address
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// Length limits from RFC 5321
+const (
+	maxLocalLength   = 64
+	maxAddressLength = 254
+)
+
+// providerRule describes how a mailbox provider ignores parts of the local part
+type providerRule struct {
+	canonicalDomain string // domain aliases map to this one
+	ignoreDots      bool
+	plusTags        bool
+}
+
+// providerRules lists providers whose addresses have known equivalent forms
+var providerRules = map[string]providerRule{
+	"gmail.com":      {canonicalDomain: "gmail.com", ignoreDots: true, plusTags: true},
+	"googlemail.com": {canonicalDomain: "gmail.com", ignoreDots: true, plusTags: true},
+	"outlook.com":    {plusTags: true},
+	"hotmail.com":    {plusTags: true},
+	"live.com":       {plusTags: true},
+	"icloud.com":     {plusTags: true},
+	"me.com":         {plusTags: true},
+	"fastmail.com":   {plusTags: true},
+	"proton.me":      {plusTags: true},
+	"protonmail.com": {plusTags: true},
+}
+
+// Normalizer validates email addresses and derives the forms they are stored
+// and compared in
+type Normalizer struct {
+	providerRules bool
+}
+
+// NewNormalizer creates a new instance of Normalizer. With providerRules,
+// addresses that a provider delivers to the same mailbox, such as Gmail
+// addresses differing in dots or "+tag" suffixes, compare as equal.
+func NewNormalizer(providerRules bool) *Normalizer {
+	return &Normalizer{
+		providerRules: providerRules,
+	}
+}
+
+// Normalize validates address and returns its canonical form: surrounding
+// space trimmed, the local part as given and the domain lowercased in its
+// ASCII (punycode) form
+func (n *Normalizer) Normalize(address string) (string, error) {
+	local, domain, err := split(address)
+	if err != nil {
+		return "", err
+	}
+	return local + "@" + domain, nil
+}
+
+// Key returns the form used to decide whether two addresses belong to the
+// same account: the canonical form lowercased, with provider rules applied
+// if enabled. Invalid addresses fall back to their trimmed, lowercased text.
+func (n *Normalizer) Key(address string) string {
+	local, domain, err := split(address)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(address))
+	}
+	local = strings.ToLower(local)
+
+	if rule, ok := providerRules[domain]; ok && n.providerRules {
+		if rule.plusTags {
+			local, _, _ = strings.Cut(local, "+")
+		}
+		if rule.ignoreDots {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		if rule.canonicalDomain != "" {
+			domain = rule.canonicalDomain
+		}
+	}
+	return local + "@" + domain
+}
+
+// Domain returns the canonical domain of address
+func Domain(address string) (string, error) {
+	_, domain, err := split(address)
+	return domain, err
+}
+
+// split validates address and returns its local part and ASCII domain
+func split(address string) (string, string, error) {
+	address = strings.TrimSpace(address)
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || at == len(address)-1 {
+		return "", "", ErrInvalidAddress
+	}
+	local, domain := address[:at], address[at+1:]
+
+	// Convert the domain first so internationalized domains pass the syntax check
+	domain, err := idna.Lookup.ToASCII(strings.ToLower(domain))
+	if err != nil || !strings.Contains(domain, ".") {
+		return "", "", ErrInvalidAddress
+	}
+
+	// Only bare addresses are accepted, not "Name <address>" forms
+	parsed, err := mail.ParseAddress(local + "@" + domain)
+	if err != nil || parsed.Address != local+"@"+domain {
+		return "", "", ErrInvalidAddress
+	}
+	if len(local) > maxLocalLength || len(local)+1+len(domain) > maxAddressLength {
+		return "", "", ErrInvalidAddress
+	}
+	return local, domain, nil
+}
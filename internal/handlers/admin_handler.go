@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/sanskarm98/auth-service/internal/auth"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/revocation"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+// AdminHandler handles administrative HTTP requests
+type AdminHandler struct {
+	lockout     *auth.AccountLockout
+	userStore   store.UserStore
+	revocations *revocation.Cache
+}
+
+// maxImportBatch limits how many users a single import request may contain
+const maxImportBatch = 1000
+
+// NewAdminHandler creates a new instance of AdminHandler
+func NewAdminHandler(lockout *auth.AccountLockout, userStore store.UserStore, revocations *revocation.Cache) *AdminHandler {
+	return &AdminHandler{
+		lockout:     lockout,
+		userStore:   userStore,
+		revocations: revocations,
+	}
+}
+
+// UnlockAccount clears the failed sign-ins and any lock for an account
+func (h *AdminHandler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	var req models.UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+		return
+	}
+	if req.Email == "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrRequiredFields)
+		return
+	}
+
+	// Unlock account
+	h.lockout.Unlock(req.Email)
+
+	// Return success
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Account unlocked successfully"})
+}
+
+// RevokeSessions ends every session of a user on every replica
+func (h *AdminHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	var req models.RevokeSessionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+		return
+	}
+	if req.Email == "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrRequiredFields)
+		return
+	}
+
+	// Get user
+	user, err := h.userStore.GetByEmail(r.Context(), req.Email)
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Revoke the user's sessions, marking their refresh tokens as well
+	if err := h.revocations.RevokeUser(r.Context(), user.ID); err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Return success
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Sessions revoked successfully"})
+}
+
+// ImportUsers creates users migrated from another system, keeping their
+// password hashes so they can sign in without a reset
+func (h *AdminHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	var req models.ImportUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Users) > maxImportBatch {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+		return
+	}
+
+	// Import users, reporting failures per record
+	response := models.ImportUsersResponse{Failures: []models.ImportFailure{}}
+	for i, record := range req.Users {
+		if record.Email == "" || record.PasswordHash == "" {
+			response.Failures = append(response.Failures, models.ImportFailure{Index: i, Email: record.Email, Error: models.ErrRequiredFields})
+			continue
+		}
+		_, err := h.userStore.Import(r.Context(), models.User{
+			ID:        record.ID,
+			Email:     record.Email,
+			Password:  record.PasswordHash,
+			Roles:     record.Roles,
+			CreatedAt: record.CreatedAt,
+		})
+		if err != nil {
+			response.Failures = append(response.Failures, models.ImportFailure{Index: i, Email: record.Email, Error: importFailureMessage(i, err)})
+			continue
+		}
+		response.Imported++
+	}
+
+	// Return results
+	utils.SendJSONResponse(w, http.StatusOK, response)
+}
+
+// importFailureMessage returns the message reported for a record the store
+// refused. Invalid records and conflicts with existing users carry a message
+// from models, such as an unsupported hash format or a taken email; other
+// errors are logged and reported without detail.
+func importFailureMessage(index int, err error) string {
+	var storeErr *store.Error
+	switch {
+	case errors.As(err, &storeErr) && (errors.Is(err, store.ErrInvalid) || errors.Is(err, store.ErrConflict)):
+		return storeErr.Message
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return models.ErrServiceUnavailable
+	default:
+		log.Printf("import: record %d: %v", index, err)
+		return models.ErrInternalServerError
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// failingImportStore fails imports of one email with a backend error
+type failingImportStore struct {
+	*store.InMemoryUserStore
+	email string
+}
+
+func (s *failingImportStore) Import(ctx context.Context, user models.User) (models.User, error) {
+	if user.Email == s.email {
+		return models.User{}, errors.New("write /data/users/000042.wal: no space left on device")
+	}
+	return s.InMemoryUserStore.Import(ctx, user)
+}
+
+func TestImportUsers(t *testing.T) {
+	ctx := context.Background()
+	users := &failingImportStore{
+		InMemoryUserStore: store.NewInMemoryUserStore(password.NewHasher(nil, password.NewBcrypt(4)), emailaddr.NewNormalizer(false)),
+		email:             "disk@example.com",
+	}
+	existing, err := users.Create(ctx, "existing@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	hash := existing.Password
+
+	request := models.ImportUsersRequest{Users: []models.ImportUserRecord{
+		{Email: "new@example.com", PasswordHash: hash},
+		{Email: "plain@example.com", PasswordHash: "hunter2"},
+		{Email: existing.Email, PasswordHash: hash},
+		{Email: "not an email", PasswordHash: hash},
+		{Email: "nohash@example.com"},
+		{Email: "disk@example.com", PasswordHash: hash},
+	}}
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	w := httptest.NewRecorder()
+	NewAdminHandler(nil, users, nil).ImportUsers(w, httptest.NewRequest(http.MethodPost, "/api/admin/users/import", strings.NewReader(string(body))))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	var response models.ImportUsersResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if response.Imported != 1 {
+		t.Errorf("imported %d, want 1", response.Imported)
+	}
+
+	// Failures carry messages from models, never the underlying error
+	want := []models.ImportFailure{
+		{Index: 1, Email: "plain@example.com", Error: models.ErrUnsupportedPasswordHash},
+		{Index: 2, Email: existing.Email, Error: models.ErrEmailAlreadyExists},
+		{Index: 3, Email: "not an email", Error: models.ErrInvalidEmail},
+		{Index: 4, Email: "nohash@example.com", Error: models.ErrRequiredFields},
+		{Index: 5, Email: "disk@example.com", Error: models.ErrInternalServerError},
+	}
+	if len(response.Failures) != len(want) {
+		t.Fatalf("failures %+v, want %+v", response.Failures, want)
+	}
+	for i, failure := range response.Failures {
+		if failure != want[i] {
+			t.Errorf("failure %d: got %+v, want %+v", i, failure, want[i])
+		}
+	}
+}
@@ -2,27 +2,71 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/sanskarm98/auth-service/internal/auth"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/mail"
 	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/revocation"
 	"github.com/sanskarm98/auth-service/internal/store"
 	"github.com/sanskarm98/auth-service/pkg/utils"
 )
 
 // AuthHandler handles authentication-related HTTP requests
 type AuthHandler struct {
-	userStore   store.UserStore
-	authService auth.AuthService
-	tokenStore  store.TokenStore
+	userStore     store.UserStore
+	authService   auth.AuthService
+	tokenStore    store.TokenStore
+	revocations   *revocation.Cache
+	authenticator auth.Authenticator
+	lockout       *auth.AccountLockout
+	mailer        mail.Sender
+	privateSignUp bool
+	policy        password.Policy
+	expiry        password.ExpiryPolicy
+	blocklist     *emailaddr.DomainBlocklist
 }
 
-// NewAuthHandler creates a new instance of AuthHandler
-func NewAuthHandler(userStore store.UserStore, authService auth.AuthService, tokenStore store.TokenStore) *AuthHandler {
+// NewAuthHandler creates a new instance of AuthHandler. Sign-in credentials are
+// checked by authenticator, which is usually a chain starting with userStore,
+// and repeated failures are throttled by lockout. With privateSignUp, sign-up
+// responds the same way whether or not the email is registered and tells the
+// address owner by email through mailer instead. New passwords must satisfy
+// policy, and expired passwords must be changed before tokens are issued.
+// Sign-up rejects emails at domains in blocklist, which may be nil. Sessions
+// and users are revoked on every replica through revocations.
+func NewAuthHandler(
+	userStore store.UserStore,
+	authService auth.AuthService,
+	tokenStore store.TokenStore,
+	revocations *revocation.Cache,
+	authenticator auth.Authenticator,
+	lockout *auth.AccountLockout,
+	mailer mail.Sender,
+	privateSignUp bool,
+	policy password.Policy,
+	expiry password.ExpiryPolicy,
+	blocklist *emailaddr.DomainBlocklist,
+) *AuthHandler {
 	return &AuthHandler{
-		userStore:   userStore,
-		authService: authService,
-		tokenStore:  tokenStore,
+		userStore:     userStore,
+		authService:   authService,
+		tokenStore:    tokenStore,
+		revocations:   revocations,
+		authenticator: authenticator,
+		lockout:       lockout,
+		mailer:        mailer,
+		privateSignUp: privateSignUp,
+		policy:        policy,
+		expiry:        expiry,
+		blocklist:     blocklist,
 	}
 }
 
@@ -47,10 +91,30 @@ func (h *AuthHandler) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Validate email
+	if _, err := emailaddr.Domain(req.Email); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidEmail)
+		return
+	}
+	if h.blocklist != nil && h.blocklist.Blocked(req.Email) {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrDisposableEmail)
+		return
+	}
+
+	// Check password policy
+	if err := h.policy.Validate(req.Password, req.Email); err != nil {
+		sendPasswordPolicyError(w, err)
+		return
+	}
+
 	// Create user
-	user, err := h.userStore.Create(req.Email, req.Password)
+	user, err := h.userStore.Create(r.Context(), req.Email, req.Password)
+	if h.privateSignUp {
+		h.respondPrivateSignUp(w, req.Email, err)
+		return
+	}
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusConflict, err.Error())
+		sendStoreError(w, err)
 		return
 	}
 
@@ -58,6 +122,50 @@ func (h *AuthHandler) SignUp(w http.ResponseWriter, r *http.Request) {
 	utils.SendJSONResponse(w, http.StatusCreated, models.NewUserResponse(user))
 }
 
+// respondPrivateSignUp sends the same response for new and already registered
+// emails, and lets the address owner know what happened by email
+func (h *AuthHandler) respondPrivateSignUp(w http.ResponseWriter, email string, createErr error) {
+	var msg mail.Message
+	switch {
+	case createErr == nil:
+		msg = mail.WelcomeMessage(email)
+	case errors.Is(createErr, store.ErrConflict):
+		msg = mail.AccountExistsMessage(email)
+	default:
+		sendStoreError(w, createErr)
+		return
+	}
+
+	// Send in the background so delivery time doesn't reveal which message it was
+	go func() {
+		if err := h.mailer.Send(msg); err != nil {
+			log.Printf("mail: sending %q: %v", msg.Subject, err)
+		}
+	}()
+
+	utils.SendJSONResponse(w, http.StatusAccepted, map[string]string{"message": "Check your email to continue"})
+}
+
+// sendPasswordPolicyError reports the rules a rejected password breaks
+func sendPasswordPolicyError(w http.ResponseWriter, err error) {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusBadRequest, models.PasswordPolicyErrorResponse{
+		Status:     http.StatusBadRequest,
+		Message:    models.ErrWeakPassword,
+		Violations: policyErr.Violations,
+	})
+}
+
+// sendAccountLocked rejects an attempt at a locked account's password
+func sendAccountLocked(w http.ResponseWriter, remaining time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	utils.SendErrorResponse(w, http.StatusTooManyRequests, models.ErrAccountLocked)
+}
+
 // SignIn handles user authentication
 func (h *AuthHandler) SignIn(w http.ResponseWriter, r *http.Request) {
 	// Check method
@@ -73,17 +181,130 @@ func (h *AuthHandler) SignIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authenticate user
-	user, authenticated := h.userStore.Authenticate(req.Email, req.Password)
-	if !authenticated {
-		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidCredentials)
+	// Reserve an attempt, rejecting it while the account is locked
+	if remaining, locked := h.lockout.Reserve(req.Email); locked {
+		sendAccountLocked(w, remaining)
+		return
+	}
+
+	// Authenticate user; the reserved attempt stays counted if it failed
+	user, err := h.authenticator.Authenticate(r.Context(), req.Email, req.Password)
+	if err != nil {
+		if !errors.Is(err, store.ErrInvalidCredentials) {
+			h.lockout.Release(req.Email)
+		}
+		sendStoreError(w, err)
+		return
+	}
+	h.lockout.RecordSuccess(req.Email)
+
+	// An expired password only earns a token for changing it
+	if h.expiry.Expired(user, time.Now()) {
+		changeToken, err := h.authService.GeneratePasswordChangeToken(user)
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusForbidden, models.PasswordChangeRequiredResponse{
+			Error:       "password_change_required",
+			Message:     models.ErrPasswordChangeRequired,
+			AccessToken: changeToken,
+		})
 		return
 	}
 
 	// Generate token pair
-	tokenPair, err := h.authService.GenerateTokenPair(user)
+	tokenPair, err := h.authService.GenerateTokenPair(r.Context(), user)
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+		sendStoreError(w, err)
+		return
+	}
+
+	// Return tokens
+	utils.SendJSONResponse(w, http.StatusOK, tokenPair)
+}
+
+// ChangePassword replaces the current user's password and issues a new token
+// pair. It also accepts the restricted token returned for expired passwords.
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Get claims from context
+	claims, ok := auth.GetClaimsFromContext(r.Context())
+	if !ok {
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidToken)
+		return
+	}
+
+	// Parse request
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrRequiredFields)
+		return
+	}
+
+	// Get user
+	user, err := h.userStore.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Verify the current password, counting failures towards lockout
+	if remaining, locked := h.lockout.Reserve(user.Email); locked {
+		sendAccountLocked(w, remaining)
+		return
+	}
+	verified, err := h.userStore.Authenticate(r.Context(), user.Email, req.CurrentPassword)
+	if err == nil && verified.ID != user.ID {
+		err = store.ErrInvalidCredentials
+	}
+	if !errors.Is(err, store.ErrInvalidCredentials) {
+		h.lockout.Release(user.Email)
+	}
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Check password policy
+	if err := h.policy.Validate(req.NewPassword, user.Email); err != nil {
+		sendPasswordPolicyError(w, err)
+		return
+	}
+
+	// Set the new password, refusing recently used ones
+	if err := h.userStore.SetPassword(r.Context(), user.ID, req.NewPassword, h.policy.History); err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// End the user's other sessions; the revocation also marks their refresh
+	// tokens, which RotateTokenPair then refuses
+	if err := h.revocations.RevokeUser(r.Context(), user.ID); err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// A restricted token has served its purpose
+	if claims.Scope == models.ScopePasswordChange {
+		if err := h.tokenStore.RevokeToken(r.Context(), utils.ExtractTokenFromHeader(r)); err != nil {
+			log.Printf("store: revoking password-change token: %v", err)
+		}
+	}
+
+	// Generate token pair
+	tokenPair, err := h.authService.GenerateTokenPair(r.Context(), user)
+	if err != nil {
+		sendStoreError(w, err)
 		return
 	}
 
@@ -106,27 +327,46 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate refresh token
-	userID, exists := h.tokenStore.GetUserIDByRefreshToken(req.RefreshToken)
-	if !exists {
+	// Validate and consume the refresh token, keeping the access tokens derived
+	// from it; only one of several concurrent refreshes can succeed
+	userID, derivedTokens, err := h.tokenStore.ConsumeRefreshToken(r.Context(), req.RefreshToken)
+	if errors.Is(err, store.ErrNotFound) {
 		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidRefreshToken)
 		return
 	}
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
 
 	// Get user
-	user, exists := h.userStore.GetByID(userID)
-	if !exists {
+	user, err := h.userStore.GetByID(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
 		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrUserNotFound)
 		return
 	}
-
-	// Delete old refresh token
-	h.tokenStore.DeleteRefreshToken(req.RefreshToken)
-
-	// Generate new token pair
-	tokenPair, err := h.authService.GenerateTokenPair(user)
 	if err != nil {
-		utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+		sendStoreError(w, err)
+		return
+	}
+
+	// Generate new token pair in the same session, carrying the derived access
+	// tokens over so that revoking it also revokes tokens issued earlier;
+	// refresh tokens of revoked sessions and users are refused
+	tokenPair, err := h.authService.RotateTokenPair(r.Context(), user, derivedTokens)
+	switch {
+	case errors.Is(err, auth.ErrSessionRevoked):
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrTokenRevoked)
+		return
+	case errors.Is(err, auth.ErrRefreshTokenExpired):
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidRefreshToken)
+		return
+	case errors.Is(err, revocation.ErrNotSynced):
+		log.Printf("auth: checking session revocation: %v", err)
+		utils.SendErrorResponse(w, http.StatusServiceUnavailable, models.ErrServiceUnavailable)
+		return
+	case err != nil:
+		sendStoreError(w, err)
 		return
 	}
 
@@ -150,12 +390,42 @@ func (h *AuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Revoke token
-	h.tokenStore.RevokeToken(tokenString)
+	if err := h.tokenStore.RevokeToken(r.Context(), tokenString); err != nil {
+		sendStoreError(w, err)
+		return
+	}
 
 	// Return success
 	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Token revoked successfully"})
 }
 
+// SignOut ends the session of the access token in the Authorization header,
+// revoking its tokens on every replica. The session's revocation also marks
+// its refresh tokens, so they can't be used to continue it.
+func (h *AuthHandler) SignOut(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Revoke the session, or just the token if it isn't part of one
+	claims, _ := auth.GetClaimsFromContext(r.Context())
+	var err error
+	if claims.SessionID != "" {
+		err = h.revocations.RevokeSession(r.Context(), claims.SessionID)
+	} else {
+		err = h.tokenStore.RevokeToken(r.Context(), utils.ExtractTokenFromHeader(r))
+	}
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Return success
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": "Signed out successfully"})
+}
+
 // VerifyToken simply confirms that a token is valid
 func (h *AuthHandler) VerifyToken(w http.ResponseWriter, r *http.Request) {
 	// Only for demonstration - token verification is done by middleware
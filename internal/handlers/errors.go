@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+// sendStoreError maps an error from a store or authenticator to a response.
+// Store errors carry a message safe to show to clients; anything else is
+// logged and reported without detail.
+func sendStoreError(w http.ResponseWriter, err error) {
+	var storeErr *store.Error
+	message := models.ErrInternalServerError
+	if errors.As(err, &storeErr) {
+		message = storeErr.Message
+	}
+
+	switch {
+	case errors.Is(err, store.ErrInvalidCredentials):
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidCredentials)
+	case errors.Is(err, store.ErrNotFound):
+		utils.SendErrorResponse(w, http.StatusNotFound, message)
+	case errors.Is(err, store.ErrConflict):
+		utils.SendErrorResponse(w, http.StatusConflict, message)
+	case errors.Is(err, store.ErrInvalid):
+		utils.SendErrorResponse(w, http.StatusBadRequest, message)
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		utils.SendErrorResponse(w, http.StatusServiceUnavailable, models.ErrServiceUnavailable)
+	default:
+		log.Printf("store: %v", err)
+		utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+	}
+}
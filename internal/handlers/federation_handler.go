@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/auth"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/oidc"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+// loginStateCookie binds an upstream login to the browser that started it,
+// preventing login CSRF
+const loginStateCookie = "oidc_state"
+
+// FederationHandler handles sign-in through upstream OIDC providers
+type FederationHandler struct {
+	providers     map[string]*oidc.Provider
+	userStore     store.UserStore
+	identityStore store.IdentityStore
+	stateStore    store.LoginStateStore
+	authService   auth.AuthService
+	stateExp      time.Duration
+}
+
+// NewFederationHandler creates a new instance of FederationHandler
+func NewFederationHandler(
+	providers []*oidc.Provider,
+	userStore store.UserStore,
+	identityStore store.IdentityStore,
+	stateStore store.LoginStateStore,
+	authService auth.AuthService,
+	stateExp time.Duration,
+) *FederationHandler {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return &FederationHandler{
+		providers:     byName,
+		userStore:     userStore,
+		identityStore: identityStore,
+		stateStore:    stateStore,
+		authService:   authService,
+		stateExp:      stateExp,
+	}
+}
+
+// Login redirects the user to the provider named by the "provider" query parameter
+func (h *FederationHandler) Login(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodGet {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Start login and redirect
+	authURL, ok := h.startLogin(w, r, "")
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Link starts a login at the provider named by the "provider" query parameter
+// that links the upstream identity to the signed-in user when it completes.
+// This is the only way to link an identity to an account the provider didn't
+// provision. The browser has to follow the returned URL so that it carries
+// the state cookie back to Callback.
+func (h *FederationHandler) Link(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Get claims from context
+	claims, ok := auth.GetClaimsFromContext(r.Context())
+	if !ok {
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidToken)
+		return
+	}
+
+	// Start login
+	authURL, ok := h.startLogin(w, r, claims.UserID)
+	if !ok {
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"authorization_url": authURL})
+}
+
+// startLogin remembers a new login at the requested provider, sets the state
+// cookie and returns the provider's authorization URL. It writes an error
+// response and returns false if the login can't be started.
+func (h *FederationHandler) startLogin(w http.ResponseWriter, r *http.Request, linkUserID string) (string, bool) {
+	// Look up provider
+	provider, exists := h.providers[r.URL.Query().Get("provider")]
+	if !exists {
+		utils.SendErrorResponse(w, http.StatusNotFound, models.ErrUnknownProvider)
+		return "", false
+	}
+
+	// Generate state, nonce and PKCE verifier
+	loginState := models.FederatedLoginState{
+		Provider:   provider.Name(),
+		ExpiresAt:  time.Now().Add(h.stateExp),
+		LinkUserID: linkUserID,
+	}
+	for _, value := range []*string{&loginState.State, &loginState.Nonce, &loginState.CodeVerifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+			return "", false
+		}
+		*value = random
+	}
+
+	// Build authorization URL
+	authURL, err := provider.AuthCodeURL(r.Context(), loginState.State, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusBadGateway, models.ErrFederatedLogin)
+		return "", false
+	}
+
+	// Remember the login
+	h.stateStore.SaveLoginState(loginState)
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    loginState.State,
+		Path:     "/",
+		MaxAge:   int(h.stateExp.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, true
+}
+
+// Callback completes an upstream login and issues a token pair for the linked
+// or newly provisioned local user, or for the user who started the login to
+// link the identity
+func (h *FederationHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodGet {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Validate state against the cookie set by Login
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(loginStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidLoginState)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: "/", MaxAge: -1})
+
+	loginState, exists := h.stateStore.ConsumeLoginState(state)
+	if !exists {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidLoginState)
+		return
+	}
+	provider, exists := h.providers[loginState.Provider]
+	if !exists {
+		utils.SendErrorResponse(w, http.StatusNotFound, models.ErrUnknownProvider)
+		return
+	}
+
+	// The provider reports errors such as a cancelled consent in the query
+	code := query.Get("code")
+	if query.Get("error") != "" || code == "" {
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrFederatedLogin)
+		return
+	}
+
+	// Redeem code and validate id_token
+	identity, err := provider.Exchange(r.Context(), code, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		if errors.Is(err, oidc.ErrEmailNotVerified) {
+			utils.SendErrorResponse(w, http.StatusForbidden, models.ErrEmailNotVerified)
+			return
+		}
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrFederatedLogin)
+		return
+	}
+
+	// Find, link or provision the local user
+	var user models.User
+	if loginState.LinkUserID != "" {
+		user, err = linkFederatedUser(r.Context(), h.userStore, h.identityStore, identity.Provider, identity.Subject, loginState.LinkUserID)
+	} else {
+		user, err = resolveFederatedUser(r.Context(), h.userStore, h.identityStore, identity.Provider, identity.Subject, identity.Email)
+	}
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Generate token pair
+	tokenPair, err := h.authService.GenerateTokenPair(r.Context(), user)
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Return tokens
+	utils.SendJSONResponse(w, http.StatusOK, tokenPair)
+}
+
+// resolveFederatedUser returns the local user linked to an upstream identity.
+// An identity that isn't linked yet is linked to the user the provider
+// provisioned for its email, which is created if needed. It is never linked to
+// an account created any other way: whoever registered that email here may
+// not be who the provider vouches for, so that fails with store.ErrConflict
+// until the account's owner links the identity while signed in.
+func resolveFederatedUser(
+	ctx context.Context,
+	userStore store.UserStore,
+	identityStore store.IdentityStore,
+	provider, subject, email string,
+) (models.User, error) {
+	// Existing link
+	if userID, linked := identityStore.GetUserIDByIdentity(provider, subject); linked {
+		user, err := userStore.GetByID(ctx, userID)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return models.User{}, err
+		}
+	}
+
+	// User this provider provisioned for the email, or a new one. Provisioned
+	// users get a random password and can only sign in through the provider.
+	password, err := oidc.RandomString()
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := userStore.Provision(ctx, email, password, provider)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if _, err := identityStore.LinkIdentity(provider, subject, user.ID); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// linkFederatedUser links an upstream identity to the user who started the
+// login to link it, failing with store.ErrConflict if it is already linked to
+// someone else
+func linkFederatedUser(
+	ctx context.Context,
+	userStore store.UserStore,
+	identityStore store.IdentityStore,
+	provider, subject, userID string,
+) (models.User, error) {
+	user, err := userStore.GetByID(ctx, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if _, err := identityStore.LinkIdentity(provider, subject, user.ID); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/saml"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+func TestResolveFederatedUser(t *testing.T) {
+	ctx := context.Background()
+	userStore := store.NewInMemoryUserStore(password.NewHasher(nil, password.NewBcrypt(4)), emailaddr.NewNormalizer(false))
+	identityStore := store.NewInMemoryIdentityStore()
+
+	local, err := userStore.Create(ctx, "local@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// An account registered here isn't taken over by an identity with its email
+	_, err = resolveFederatedUser(ctx, userStore, identityStore, "example", "attacker", local.Email)
+	if !errors.Is(err, store.ErrConflict) {
+		t.Errorf("resolveFederatedUser(local account): got error %v, want %v", err, store.ErrConflict)
+	}
+	if _, linked := identityStore.GetUserIDByIdentity("example", "attacker"); linked {
+		t.Error("resolveFederatedUser(local account) linked the identity")
+	}
+
+	// Its owner can link the identity while signed in
+	linked, err := linkFederatedUser(ctx, userStore, identityStore, "example", "owner", local.ID)
+	if err != nil || linked.ID != local.ID {
+		t.Fatalf("linkFederatedUser: user %q, %v; want %q", linked.ID, err, local.ID)
+	}
+	user, err := resolveFederatedUser(ctx, userStore, identityStore, "example", "owner", local.Email)
+	if err != nil || user.ID != local.ID {
+		t.Errorf("resolveFederatedUser(linked): user %q, %v; want %q", user.ID, err, local.ID)
+	}
+
+	// A new identity gets a provisioned user, which it is linked to again
+	// after the link is lost, for instance by a restart
+	provisioned, err := resolveFederatedUser(ctx, userStore, identityStore, "example", "new", "new@example.com")
+	if err != nil {
+		t.Fatalf("resolveFederatedUser(new): %v", err)
+	}
+	user, err = resolveFederatedUser(ctx, userStore, store.NewInMemoryIdentityStore(), "example", "new", "new@example.com")
+	if err != nil || user.ID != provisioned.ID {
+		t.Errorf("resolveFederatedUser(provisioned): user %q, %v; want %q", user.ID, err, provisioned.ID)
+	}
+
+	// Other providers can't claim the provisioned user
+	_, err = resolveFederatedUser(ctx, userStore, identityStore, "other", "new", "new@example.com")
+	if !errors.Is(err, store.ErrConflict) {
+		t.Errorf("resolveFederatedUser(other provider): got error %v, want %v", err, store.ErrConflict)
+	}
+
+	// An identity can't be linked to a second user
+	_, err = linkFederatedUser(ctx, userStore, identityStore, "example", "new", local.ID)
+	if !errors.Is(err, store.ErrConflict) {
+		t.Errorf("linkFederatedUser(linked elsewhere): got error %v, want %v", err, store.ErrConflict)
+	}
+}
+
+func TestSAMLResolveUser(t *testing.T) {
+	ctx := context.Background()
+	userStore := store.NewInMemoryUserStore(password.NewHasher(nil, password.NewBcrypt(4)), emailaddr.NewNormalizer(false))
+	h := NewSAMLHandler(nil, userStore, store.NewInMemoryIdentityStore(), nil)
+
+	// Assertions don't take over accounts created here
+	local, err := userStore.Create(ctx, "local@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, err = h.resolveUser(ctx, saml.Identity{Subject: "local", Email: local.Email, Roles: []string{"admin"}})
+	if !errors.Is(err, store.ErrConflict) {
+		t.Errorf("resolveUser(local account): got error %v, want %v", err, store.ErrConflict)
+	}
+	if stored, err := userStore.GetByID(ctx, local.ID); err != nil || len(stored.AllRoles()) != 0 {
+		t.Errorf("local account roles %v, %v; want none", stored.AllRoles(), err)
+	}
+
+	user, err := h.resolveUser(ctx, saml.Identity{Subject: "alice", Email: "alice@example.com", Roles: []string{"auditor"}})
+	if err != nil {
+		t.Fatalf("resolveUser: %v", err)
+	}
+	user.Roles = []string{"billing"}
+	if err := userStore.Update(ctx, user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		roles []string
+		want  string
+	}{
+		{"no role attribute keeps the IdP's roles", nil, "billing,auditor"},
+		{"asserted roles replace the IdP's roles", []string{"admin"}, "billing,admin"},
+		{"no asserted roles only drop the IdP's roles", []string{}, "billing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := h.resolveUser(ctx, saml.Identity{Subject: "alice", Email: "alice@example.com", Roles: test.roles})
+			if err != nil {
+				t.Fatalf("resolveUser: %v", err)
+			}
+			if got := strings.Join(user.AllRoles(), ","); got != test.want {
+				t.Errorf("roles %q, want %q", got, test.want)
+			}
+		})
+	}
+}
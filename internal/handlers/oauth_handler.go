@@ -0,0 +1,512 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/auth"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/revocation"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+// slowDownIncrement is added to a device's polling interval each time it
+// polls too quickly (RFC 8628 section 3.5)
+const slowDownIncrement = 5 * time.Second
+
+// OAuthHandler handles OAuth 2.0 protocol endpoints
+type OAuthHandler struct {
+	userStore       store.UserStore
+	authService     auth.AuthService
+	tokenStore      store.TokenStore
+	revocations     *revocation.Cache
+	deviceStore     store.DeviceCodeStore
+	clientStore     store.ClientStore
+	verificationURI string
+	deviceCodeExp   time.Duration
+	pollInterval    time.Duration
+}
+
+// NewOAuthHandler creates a new instance of OAuthHandler
+func NewOAuthHandler(
+	userStore store.UserStore,
+	authService auth.AuthService,
+	tokenStore store.TokenStore,
+	revocations *revocation.Cache,
+	deviceStore store.DeviceCodeStore,
+	clientStore store.ClientStore,
+	verificationURI string,
+	deviceCodeExp time.Duration,
+	pollInterval time.Duration,
+) *OAuthHandler {
+	return &OAuthHandler{
+		userStore:       userStore,
+		authService:     authService,
+		tokenStore:      tokenStore,
+		revocations:     revocations,
+		deviceStore:     deviceStore,
+		clientStore:     clientStore,
+		verificationURI: verificationURI,
+		deviceCodeExp:   deviceCodeExp,
+		pollInterval:    pollInterval,
+	}
+}
+
+// DeviceAuthorization starts a device authorization grant by issuing a device code and a user code
+func (h *OAuthHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	if err := r.ParseForm(); err != nil {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, models.ErrInvalidRequest)
+		return
+	}
+	clientID := r.PostForm.Get("client_id")
+	if clientID == "" {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidClient, "client_id is required")
+		return
+	}
+
+	// Only registered clients may start an authorization
+	if _, registered := h.clientStore.GetClient(clientID); !registered {
+		utils.SendOAuthErrorResponse(w, http.StatusUnauthorized, models.OAuthErrInvalidClient, "")
+		return
+	}
+
+	// Generate codes
+	deviceCode, err := auth.GenerateDeviceCode()
+	if err != nil {
+		utils.SendOAuthErrorResponse(w, http.StatusInternalServerError, models.OAuthErrServerError, "")
+		return
+	}
+	userCode, err := h.generateUniqueUserCode()
+	if err != nil {
+		utils.SendOAuthErrorResponse(w, http.StatusInternalServerError, models.OAuthErrServerError, "")
+		return
+	}
+
+	// Store pending authorization
+	h.deviceStore.StoreDeviceAuthorization(models.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   clientID,
+		Scope:      r.PostForm.Get("scope"),
+		Status:     models.DeviceAuthorizationPending,
+		Interval:   h.pollInterval,
+		ExpiresAt:  time.Now().Add(h.deviceCodeExp),
+	})
+
+	// Return codes
+	displayCode := auth.FormatUserCode(userCode)
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SendJSONResponse(w, http.StatusOK, models.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                displayCode,
+		VerificationURI:         h.verificationURI,
+		VerificationURIComplete: h.verificationURI + "?user_code=" + url.QueryEscape(displayCode),
+		ExpiresIn:               int(h.deviceCodeExp.Seconds()),
+		Interval:                int(h.pollInterval.Seconds()),
+	})
+}
+
+// ApproveDevice lets a signed-in user approve or deny a pending device authorization
+func (h *OAuthHandler) ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Get claims from context
+	claims, ok := auth.GetClaimsFromContext(r.Context())
+	if !ok {
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidToken)
+		return
+	}
+
+	// Parse request
+	var req models.DeviceApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+		return
+	}
+
+	// Look up authorization
+	pending, exists := h.deviceStore.GetByUserCode(auth.NormalizeUserCode(req.UserCode))
+	if !exists || time.Now().After(pending.ExpiresAt) {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidUserCode)
+		return
+	}
+
+	// Record the decision unless it has already been made
+	status := models.DeviceAuthorizationApproved
+	if req.Deny {
+		status = models.DeviceAuthorizationDenied
+	}
+	alreadyHandled := false
+	_, exists = h.deviceStore.UpdateDeviceAuthorization(pending.DeviceCode, func(a *models.DeviceAuthorization) {
+		if a.Status != models.DeviceAuthorizationPending {
+			alreadyHandled = true
+			return
+		}
+		a.Status = status
+		a.UserID = claims.UserID
+	})
+	if !exists {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidUserCode)
+		return
+	}
+	if alreadyHandled {
+		utils.SendErrorResponse(w, http.StatusConflict, models.ErrDeviceAlreadyHandled)
+		return
+	}
+
+	// Return success
+	message := "Device approved successfully"
+	if req.Deny {
+		message = "Device denied successfully"
+	}
+	utils.SendJSONResponse(w, http.StatusOK, map[string]string{"message": message})
+}
+
+// Token is the OAuth 2.0 token endpoint
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	if err := r.ParseForm(); err != nil {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, models.ErrInvalidRequest)
+		return
+	}
+
+	// Dispatch on grant type
+	switch r.PostForm.Get("grant_type") {
+	case models.GrantTypeDeviceCode:
+		h.deviceCodeGrant(w, r)
+	default:
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrUnsupportedGrantType, "")
+	}
+}
+
+// deviceCodeGrant handles token polling for the device authorization grant
+func (h *OAuthHandler) deviceCodeGrant(w http.ResponseWriter, r *http.Request) {
+	deviceCode := r.PostForm.Get("device_code")
+	clientID := r.PostForm.Get("client_id")
+	if deviceCode == "" || clientID == "" {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, "device_code and client_id are required")
+		return
+	}
+
+	// Record the poll, growing the interval if the client is polling too quickly
+	now := time.Now()
+	slowDown := false
+	pending, exists := h.deviceStore.UpdateDeviceAuthorization(deviceCode, func(a *models.DeviceAuthorization) {
+		if !a.LastPolledAt.IsZero() && now.Sub(a.LastPolledAt) < a.Interval {
+			a.Interval += slowDownIncrement
+			slowDown = true
+		}
+		a.LastPolledAt = now
+	})
+	if !exists || pending.ClientID != clientID {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidGrant, "")
+		return
+	}
+	if now.After(pending.ExpiresAt) {
+		h.deviceStore.DeleteDeviceAuthorization(deviceCode)
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrExpiredToken, "")
+		return
+	}
+	if slowDown {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrSlowDown, "")
+		return
+	}
+
+	switch pending.Status {
+	case models.DeviceAuthorizationPending:
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrAuthorizationPending, "")
+		return
+	case models.DeviceAuthorizationDenied:
+		h.deviceStore.DeleteDeviceAuthorization(deviceCode)
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrAccessDenied, "")
+		return
+	}
+
+	// Redeem the device code exactly once
+	if !h.deviceStore.DeleteDeviceAuthorization(deviceCode) {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidGrant, "")
+		return
+	}
+
+	// Get user
+	user, err := h.userStore.GetByID(r.Context(), pending.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidGrant, models.ErrUserNotFound)
+		return
+	}
+	if err != nil {
+		sendOAuthServerError(w, err)
+		return
+	}
+
+	// Generate token pair
+	tokenPair, err := h.authService.GenerateClientTokenPair(r.Context(), user, pending.ClientID, pending.Scope)
+	if err != nil {
+		sendOAuthServerError(w, err)
+		return
+	}
+
+	// Return tokens
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SendJSONResponse(w, http.StatusOK, tokenPair)
+}
+
+// Introspect reports whether a token is active and describes it (RFC 7662).
+// Callers must be authenticated by ClientAuthMiddleware.
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	if err := r.ParseForm(); err != nil {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, models.ErrInvalidRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, "token is required")
+		return
+	}
+
+	// Try the hinted token type first; the hint is advisory only
+	lookups := []func(context.Context, string) (models.IntrospectionResponse, bool, error){h.introspectAccessToken, h.introspectRefreshToken}
+	if r.PostForm.Get("token_type_hint") == models.TokenTypeHintRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	// Return the first match, or an inactive response for unknown tokens
+	response := models.IntrospectionResponse{Active: false}
+	for _, lookup := range lookups {
+		result, found, err := lookup(r.Context(), token)
+		if err != nil {
+			sendOAuthServerError(w, err)
+			return
+		}
+		if found {
+			response = result
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SendJSONResponse(w, http.StatusOK, response)
+}
+
+// Revoke revokes an access or refresh token (RFC 7009) for an authenticated
+// client. Revoking a refresh token also revokes the access tokens derived
+// from it. Tokens issued to another client are left alone, and unknown or
+// invalid tokens are ignored; either way the response is the same, so it
+// doesn't reveal whether a token existed.
+func (h *OAuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	if err := r.ParseForm(); err != nil {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, models.ErrInvalidRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		utils.SendOAuthErrorResponse(w, http.StatusBadRequest, models.OAuthErrInvalidRequest, "token is required")
+		return
+	}
+	client, _ := auth.GetClientFromContext(r.Context())
+
+	// Try the hinted token type first; the hint is advisory only
+	revocations := []func(context.Context, string, string) (bool, error){h.revokeAccessToken, h.revokeRefreshToken}
+	if r.PostForm.Get("token_type_hint") == models.TokenTypeHintRefreshToken {
+		revocations[0], revocations[1] = revocations[1], revocations[0]
+	}
+	for _, revoke := range revocations {
+		found, err := revoke(r.Context(), token, client.ID)
+		if err != nil {
+			sendOAuthServerError(w, err)
+			return
+		}
+		if found {
+			break
+		}
+	}
+
+	// Return success
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// issuedToOther reports whether a token issued to tokenClientID may not be
+// revoked by clientID. Tokens issued directly to users, without a client,
+// may be revoked by any client.
+func issuedToOther(tokenClientID, clientID string) bool {
+	return tokenClientID != "" && tokenClientID != clientID
+}
+
+// revokeAccessToken adds a valid access token to the revocation list unless
+// it was issued to a client other than clientID. It reports whether token
+// is a valid access token.
+func (h *OAuthHandler) revokeAccessToken(ctx context.Context, token, clientID string) (bool, error) {
+	claims, err := h.authService.ValidateToken(token)
+	if err != nil {
+		return false, nil
+	}
+	if issuedToOther(claims.ClientID, clientID) {
+		return true, nil
+	}
+	return true, h.tokenStore.RevokeToken(ctx, token)
+}
+
+// revokeRefreshToken deletes a refresh token and revokes the access tokens
+// derived from it, unless it was issued to a client other than clientID. It
+// reports whether token is a stored refresh token.
+func (h *OAuthHandler) revokeRefreshToken(ctx context.Context, token, clientID string) (bool, error) {
+	userID, err := h.tokenStore.GetUserIDByRefreshToken(ctx, token)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	derivedTokens, err := h.tokenStore.GetDerivedAccessTokens(ctx, token)
+	if err != nil {
+		return false, err
+	}
+
+	// The refresh token's session names its client. One that may no longer
+	// be used is deleted whoever asks, since that changes nothing for its
+	// client.
+	session, err := h.authService.RefreshSession(userID, derivedTokens)
+	switch {
+	case errors.Is(err, auth.ErrSessionRevoked) || errors.Is(err, auth.ErrRefreshTokenExpired):
+	case err != nil:
+		return false, err
+	case issuedToOther(session.ClientID, clientID):
+		return true, nil
+	}
+
+	for _, accessToken := range derivedTokens {
+		if err := h.tokenStore.RevokeToken(ctx, accessToken); err != nil {
+			return false, err
+		}
+	}
+	if err := h.tokenStore.DeleteRefreshToken(ctx, token); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+	return true, nil
+}
+
+// introspectAccessToken describes an unrevoked, valid access token
+func (h *OAuthHandler) introspectAccessToken(ctx context.Context, token string) (models.IntrospectionResponse, bool, error) {
+	revoked, err := h.tokenStore.IsTokenRevoked(ctx, token)
+	if err != nil || revoked {
+		return models.IntrospectionResponse{}, false, err
+	}
+	claims, err := h.authService.ValidateToken(token)
+	if err != nil {
+		return models.IntrospectionResponse{}, false, nil
+	}
+	if revoked, err := h.revocations.ClaimsRevoked(claims); err != nil || revoked {
+		return models.IntrospectionResponse{}, false, err
+	}
+
+	response := models.IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Email,
+		TokenType: models.TokenTypeHintAccessToken,
+		Sub:       claims.UserID,
+	}
+	if claims.ExpiresAt != nil {
+		response.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response.Iat = claims.IssuedAt.Unix()
+	}
+	return response, true, nil
+}
+
+// introspectRefreshToken describes a refresh token that is still stored and
+// may still be used
+func (h *OAuthHandler) introspectRefreshToken(ctx context.Context, token string) (models.IntrospectionResponse, bool, error) {
+	userID, err := h.tokenStore.GetUserIDByRefreshToken(ctx, token)
+	if errors.Is(err, store.ErrNotFound) {
+		return models.IntrospectionResponse{}, false, nil
+	}
+	if err != nil {
+		return models.IntrospectionResponse{}, false, err
+	}
+	derivedTokens, err := h.tokenStore.GetDerivedAccessTokens(ctx, token)
+	if err != nil {
+		return models.IntrospectionResponse{}, false, err
+	}
+	session, err := h.authService.RefreshSession(userID, derivedTokens)
+	if errors.Is(err, auth.ErrSessionRevoked) || errors.Is(err, auth.ErrRefreshTokenExpired) {
+		return models.IntrospectionResponse{}, false, nil
+	}
+	if err != nil {
+		return models.IntrospectionResponse{}, false, err
+	}
+
+	response := models.IntrospectionResponse{
+		Active:    true,
+		Scope:     session.Scope,
+		ClientID:  session.ClientID,
+		TokenType: models.TokenTypeHintRefreshToken,
+		Sub:       userID,
+	}
+	user, err := h.userStore.GetByID(ctx, userID)
+	if err == nil {
+		response.Username = user.Email
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return models.IntrospectionResponse{}, false, err
+	}
+	return response, true, nil
+}
+
+// sendOAuthServerError logs an unexpected error and reports it in OAuth format
+func sendOAuthServerError(w http.ResponseWriter, err error) {
+	log.Printf("store: %v", err)
+	utils.SendOAuthErrorResponse(w, http.StatusInternalServerError, models.OAuthErrServerError, "")
+}
+
+// generateUniqueUserCode generates a user code that doesn't collide with an outstanding one
+func (h *OAuthHandler) generateUniqueUserCode() (string, error) {
+	for {
+		userCode, err := auth.GenerateUserCode()
+		if err != nil {
+			return "", err
+		}
+		if _, exists := h.deviceStore.GetByUserCode(userCode); !exists {
+			return userCode, nil
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sanskarm98/auth-service/internal/auth"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/revocation"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// oauthFixture serves the OAuth endpoints the way main wires them, for a
+// public client "cli" and API-key clients "billing" and "reporting"
+type oauthFixture struct {
+	routes      *http.ServeMux
+	authService *auth.JWTAuthService
+	tokenStore  *store.InMemoryTokenStore
+	revocations *revocation.Cache
+	user        models.User
+	accessToken string // the user's, for approving devices
+}
+
+// API keys of the fixture's clients
+const (
+	billingKey   = "billing-key"
+	reportingKey = "reporting-key"
+)
+
+// newOAuthFixture returns a fixture whose device codes are valid for
+// deviceCodeExp and may be polled every pollInterval
+func newOAuthFixture(t *testing.T, deviceCodeExp, pollInterval time.Duration) *oauthFixture {
+	ctx := context.Background()
+	userStore := store.NewInMemoryUserStore(password.NewHasher(nil, password.NewBcrypt(4)), emailaddr.NewNormalizer(false))
+	tokenStore := store.NewInMemoryTokenStore(time.Minute)
+	revocations := revocation.NewCache(revocation.NewLocalBus(), time.Minute, time.Hour)
+	t.Cleanup(revocations.Close)
+	authService := auth.NewJWTAuthService("secret", time.Minute, time.Hour, tokenStore, revocations)
+
+	clientStore := store.NewInMemoryClientStore()
+	clientStore.RegisterPublicClient("cli")
+	clientStore.RegisterAPIKey("billing", billingKey)
+	clientStore.RegisterAPIKey("reporting", reportingKey)
+
+	user, err := userStore.Create(ctx, "user@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	pair, err := authService.GenerateTokenPair(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokenPair: %v", err)
+	}
+
+	handler := NewOAuthHandler(userStore, authService, tokenStore, revocations, store.NewInMemoryDeviceCodeStore(), clientStore, "http://localhost/device", deviceCodeExp, pollInterval)
+	authMiddleware := auth.NewAuthMiddleware(authService, tokenStore, revocations)
+	clientAuthMiddleware := auth.NewClientAuthMiddleware(clientStore)
+	routes := http.NewServeMux()
+	routes.HandleFunc("/oauth/device/code", handler.DeviceAuthorization)
+	routes.HandleFunc("/oauth/device/approve", authMiddleware.Authenticate(handler.ApproveDevice))
+	routes.HandleFunc("/oauth/token", handler.Token)
+	routes.HandleFunc("/oauth/introspect", clientAuthMiddleware.Authenticate(handler.Introspect))
+	routes.HandleFunc("/oauth/revoke", clientAuthMiddleware.Authenticate(handler.Revoke))
+
+	return &oauthFixture{
+		routes:      routes,
+		authService: authService,
+		tokenStore:  tokenStore,
+		revocations: revocations,
+		user:        user,
+		accessToken: pair.AccessToken,
+	}
+}
+
+// postForm posts a form to path, with an API key if apiKey isn't empty
+func (f *oauthFixture) postForm(path string, form url.Values, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if apiKey != "" {
+		r.Header.Set(auth.APIKeyHeader, apiKey)
+	}
+	w := httptest.NewRecorder()
+	f.routes.ServeHTTP(w, r)
+	return w
+}
+
+// authorizeDevice starts a device authorization for client "cli"
+func (f *oauthFixture) authorizeDevice(t *testing.T) models.DeviceAuthorizationResponse {
+	t.Helper()
+	w := f.postForm("/oauth/device/code", url.Values{"client_id": {"cli"}, "scope": {"profile"}}, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("device code: got %d: %s", w.Code, w.Body)
+	}
+	var response models.DeviceAuthorizationResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode device code response: %v", err)
+	}
+	return response
+}
+
+// decideDevice has the fixture's user approve or deny a user code
+func (f *oauthFixture) decideDevice(userCode string, deny bool) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.DeviceApprovalRequest{UserCode: userCode, Deny: deny})
+	r := httptest.NewRequest(http.MethodPost, "/oauth/device/approve", strings.NewReader(string(body)))
+	r.Header.Set("Authorization", "Bearer "+f.accessToken)
+	w := httptest.NewRecorder()
+	f.routes.ServeHTTP(w, r)
+	return w
+}
+
+// pollDevice polls the token endpoint for a device code as clientID
+func (f *oauthFixture) pollDevice(deviceCode, clientID string) *httptest.ResponseRecorder {
+	return f.postForm("/oauth/token", url.Values{
+		"grant_type":  {models.GrantTypeDeviceCode},
+		"device_code": {deviceCode},
+		"client_id":   {clientID},
+	}, "")
+}
+
+// oauthError decodes an OAuth error response
+func oauthError(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response models.OAuthErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode error response: %v", err)
+	}
+	return response.Error
+}
+
+func TestDeviceAuthorizationRequiresRegisteredClient(t *testing.T) {
+	f := newOAuthFixture(t, time.Minute, 0)
+	for _, tt := range []struct {
+		name     string
+		clientID string
+		status   int
+	}{
+		{"missing", "", http.StatusBadRequest},
+		{"unregistered", "unknown", http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := f.postForm("/oauth/device/code", url.Values{"client_id": {tt.clientID}}, "")
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d", w.Code, tt.status)
+			}
+			if code := oauthError(t, w); code != models.OAuthErrInvalidClient {
+				t.Errorf("got error %q, want %q", code, models.OAuthErrInvalidClient)
+			}
+		})
+	}
+}
+
+func TestDeviceCodeGrant(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		deviceCodeExp time.Duration
+		pollInterval  time.Duration
+		prepare       func(t *testing.T, f *oauthFixture, device models.DeviceAuthorizationResponse)
+		clientID      string
+		want          string // OAuth error, or "" for a token pair
+	}{
+		{
+			name:     "pending",
+			clientID: "cli",
+			want:     models.OAuthErrAuthorizationPending,
+		},
+		{
+			name:         "polled too quickly",
+			pollInterval: time.Hour,
+			prepare: func(t *testing.T, f *oauthFixture, device models.DeviceAuthorizationResponse) {
+				f.pollDevice(device.DeviceCode, "cli")
+			},
+			clientID: "cli",
+			want:     models.OAuthErrSlowDown,
+		},
+		{
+			name:          "expired",
+			deviceCodeExp: time.Millisecond,
+			prepare: func(t *testing.T, f *oauthFixture, device models.DeviceAuthorizationResponse) {
+				time.Sleep(5 * time.Millisecond)
+			},
+			clientID: "cli",
+			want:     models.OAuthErrExpiredToken,
+		},
+		{
+			name: "denied",
+			prepare: func(t *testing.T, f *oauthFixture, device models.DeviceAuthorizationResponse) {
+				if w := f.decideDevice(device.UserCode, true); w.Code != http.StatusOK {
+					t.Fatalf("deny: got %d: %s", w.Code, w.Body)
+				}
+			},
+			clientID: "cli",
+			want:     models.OAuthErrAccessDenied,
+		},
+		{
+			name: "another client",
+			prepare: func(t *testing.T, f *oauthFixture, device models.DeviceAuthorizationResponse) {
+				f.decideDevice(device.UserCode, false)
+			},
+			clientID: "billing",
+			want:     models.OAuthErrInvalidGrant,
+		},
+		{
+			name: "approved",
+			prepare: func(t *testing.T, f *oauthFixture, device models.DeviceAuthorizationResponse) {
+				if w := f.decideDevice(device.UserCode, false); w.Code != http.StatusOK {
+					t.Fatalf("approve: got %d: %s", w.Code, w.Body)
+				}
+			},
+			clientID: "cli",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			deviceCodeExp := tt.deviceCodeExp
+			if deviceCodeExp == 0 {
+				deviceCodeExp = time.Minute
+			}
+			f := newOAuthFixture(t, deviceCodeExp, tt.pollInterval)
+			device := f.authorizeDevice(t)
+			if tt.prepare != nil {
+				tt.prepare(t, f, device)
+			}
+
+			w := f.pollDevice(device.DeviceCode, tt.clientID)
+			if tt.want != "" {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("got %d, want %d", w.Code, http.StatusBadRequest)
+				}
+				if code := oauthError(t, w); code != tt.want {
+					t.Errorf("got error %q, want %q", code, tt.want)
+				}
+				return
+			}
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body)
+			}
+			var pair models.TokenPair
+			if err := json.NewDecoder(w.Body).Decode(&pair); err != nil {
+				t.Fatalf("decode token pair: %v", err)
+			}
+			claims, err := f.authService.ValidateToken(pair.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.UserID != f.user.ID || claims.ClientID != "cli" || claims.Scope != "profile" {
+				t.Errorf("got token for user %q, client %q and scope %q", claims.UserID, claims.ClientID, claims.Scope)
+			}
+
+			// The device code can be redeemed only once
+			if w := f.pollDevice(device.DeviceCode, "cli"); w.Code != http.StatusBadRequest || oauthError(t, w) != models.OAuthErrInvalidGrant {
+				t.Errorf("second redemption: got %d, want invalid_grant", w.Code)
+			}
+		})
+	}
+}
+
+// introspect asks the introspection endpoint about token as client "billing"
+func (f *oauthFixture) introspect(t *testing.T, token string) models.IntrospectionResponse {
+	t.Helper()
+	w := f.postForm("/oauth/introspect", url.Values{"token": {token}}, billingKey)
+	if w.Code != http.StatusOK {
+		t.Fatalf("introspect: got %d: %s", w.Code, w.Body)
+	}
+	var response models.IntrospectionResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("decode introspection response: %v", err)
+	}
+	return response
+}
+
+// signToken signs claims for the fixture's user with secret
+func (f *oauthFixture) signToken(t *testing.T, secret string, expiresAt time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, models.Claims{
+		UserID: f.user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Subject:   f.user.ID,
+		},
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func TestIntrospectRequiresClient(t *testing.T) {
+	f := newOAuthFixture(t, time.Minute, 0)
+	for name, apiKey := range map[string]string{"missing": "", "unknown": "unknown-key"} {
+		t.Run(name, func(t *testing.T) {
+			w := f.postForm("/oauth/introspect", url.Values{"token": {f.accessToken}}, apiKey)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("got %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name string
+		// token returns the token to introspect
+		token  func(t *testing.T, f *oauthFixture) string
+		active bool
+		// want, if active, holds the fields expected besides sub and username
+		want models.IntrospectionResponse
+	}{
+		{
+			name: "active access token",
+			token: func(t *testing.T, f *oauthFixture) string {
+				pair, _ := f.authService.GenerateClientTokenPair(ctx, f.user, "cli", "profile")
+				return pair.AccessToken
+			},
+			active: true,
+			want:   models.IntrospectionResponse{ClientID: "cli", Scope: "profile", TokenType: models.TokenTypeHintAccessToken},
+		},
+		{
+			name: "active refresh token",
+			token: func(t *testing.T, f *oauthFixture) string {
+				pair, _ := f.authService.GenerateClientTokenPair(ctx, f.user, "cli", "profile")
+				return pair.RefreshToken
+			},
+			active: true,
+			want:   models.IntrospectionResponse{ClientID: "cli", Scope: "profile", TokenType: models.TokenTypeHintRefreshToken},
+		},
+		{
+			name: "revoked access token",
+			token: func(t *testing.T, f *oauthFixture) string {
+				if err := f.tokenStore.RevokeToken(ctx, f.accessToken); err != nil {
+					t.Fatalf("RevokeToken: %v", err)
+				}
+				return f.accessToken
+			},
+		},
+		{
+			name: "access token of a revoked session",
+			token: func(t *testing.T, f *oauthFixture) string {
+				claims, _ := f.authService.ValidateToken(f.accessToken)
+				if err := f.revocations.RevokeSession(ctx, claims.SessionID); err != nil {
+					t.Fatalf("RevokeSession: %v", err)
+				}
+				return f.accessToken
+			},
+		},
+		{
+			name: "refresh token of a revoked session",
+			token: func(t *testing.T, f *oauthFixture) string {
+				pair, _ := f.authService.GenerateTokenPair(ctx, f.user)
+				claims, _ := f.authService.ValidateToken(pair.AccessToken)
+				if err := f.revocations.RevokeSession(ctx, claims.SessionID); err != nil {
+					t.Fatalf("RevokeSession: %v", err)
+				}
+				return pair.RefreshToken
+			},
+		},
+		{
+			name: "deleted refresh token",
+			token: func(t *testing.T, f *oauthFixture) string {
+				pair, _ := f.authService.GenerateTokenPair(ctx, f.user)
+				if err := f.tokenStore.DeleteRefreshToken(ctx, pair.RefreshToken); err != nil {
+					t.Fatalf("DeleteRefreshToken: %v", err)
+				}
+				return pair.RefreshToken
+			},
+		},
+		{
+			name: "expired access token",
+			token: func(t *testing.T, f *oauthFixture) string {
+				return f.signToken(t, "secret", time.Now().Add(-time.Minute))
+			},
+		},
+		{
+			name: "token signed by another issuer",
+			token: func(t *testing.T, f *oauthFixture) string {
+				return f.signToken(t, "another secret", time.Now().Add(time.Minute))
+			},
+		},
+		{
+			name: "unknown opaque token",
+			token: func(t *testing.T, f *oauthFixture) string {
+				return "not-a-token"
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(t, time.Minute, 0)
+			response := f.introspect(t, tt.token(t, f))
+			if !tt.active {
+				if response != (models.IntrospectionResponse{}) {
+					t.Errorf("got %+v, want only active=false", response)
+				}
+				return
+			}
+
+			want := tt.want
+			want.Active, want.Sub, want.Username = true, f.user.ID, f.user.Email
+			want.Exp, want.Iat = response.Exp, response.Iat
+			if response != want {
+				t.Errorf("got %+v, want %+v", response, want)
+			}
+			if want.TokenType == models.TokenTypeHintAccessToken && (response.Exp == 0 || response.Iat == 0) {
+				t.Errorf("access token response lacks exp or iat: %+v", response)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name    string
+		apiKey  string // of the client revoking the token
+		refresh bool   // revoke the refresh token rather than the access token
+		revoked bool
+	}{
+		{name: "own access token", apiKey: billingKey, revoked: true},
+		{name: "own refresh token", apiKey: billingKey, refresh: true, revoked: true},
+		{name: "another client's access token", apiKey: reportingKey},
+		{name: "another client's refresh token", apiKey: reportingKey, refresh: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(t, time.Minute, 0)
+			pair, err := f.authService.GenerateClientTokenPair(ctx, f.user, "billing", "")
+			if err != nil {
+				t.Fatalf("GenerateClientTokenPair: %v", err)
+			}
+			token, hint := pair.AccessToken, models.TokenTypeHintAccessToken
+			if tt.refresh {
+				token, hint = pair.RefreshToken, models.TokenTypeHintRefreshToken
+			}
+
+			w := f.postForm("/oauth/revoke", url.Values{"token": {token}, "token_type_hint": {hint}}, tt.apiKey)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, want %d", w.Code, http.StatusOK)
+			}
+
+			// Revoking a refresh token revokes its access token too
+			accessRevoked, err := f.tokenStore.IsTokenRevoked(ctx, pair.AccessToken)
+			if err != nil {
+				t.Fatalf("IsTokenRevoked: %v", err)
+			}
+			if accessRevoked != tt.revoked {
+				t.Errorf("access token revoked: got %v, want %v", accessRevoked, tt.revoked)
+			}
+			_, err = f.tokenStore.GetUserIDByRefreshToken(ctx, pair.RefreshToken)
+			if refreshRevoked := errors.Is(err, store.ErrNotFound); refreshRevoked != (tt.revoked && tt.refresh) {
+				t.Errorf("refresh token deleted: got %v, want %v", refreshRevoked, tt.revoked && tt.refresh)
+			}
+		})
+	}
+
+	// Unknown tokens get the same response
+	f := newOAuthFixture(t, time.Minute, 0)
+	if w := f.postForm("/oauth/revoke", url.Values{"token": {"not-a-token"}}, billingKey); w.Code != http.StatusOK {
+		t.Errorf("unknown token: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
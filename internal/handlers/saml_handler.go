@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/sanskarm98/auth-service/internal/auth"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/saml"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+// samlProvider is the provider name recorded on identities linked through SAML
+const samlProvider = "saml"
+
+// SAMLHandler handles SAML 2.0 service-provider endpoints
+type SAMLHandler struct {
+	serviceProvider *saml.ServiceProvider
+	userStore       store.UserStore
+	identityStore   store.IdentityStore
+	authService     auth.AuthService
+}
+
+// NewSAMLHandler creates a new instance of SAMLHandler
+func NewSAMLHandler(
+	serviceProvider *saml.ServiceProvider,
+	userStore store.UserStore,
+	identityStore store.IdentityStore,
+	authService auth.AuthService,
+) *SAMLHandler {
+	return &SAMLHandler{
+		serviceProvider: serviceProvider,
+		userStore:       userStore,
+		identityStore:   identityStore,
+		authService:     authService,
+	}
+}
+
+// Metadata returns the SP metadata document
+func (h *SAMLHandler) Metadata(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodGet {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	metadata, err := h.serviceProvider.Metadata()
+	if err != nil {
+		utils.SendErrorResponse(w, http.StatusInternalServerError, models.ErrInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(metadata)
+}
+
+// AssertionConsumerService validates a SAML response posted by the IdP and
+// issues a token pair for the linked or newly provisioned local user
+func (h *SAMLHandler) AssertionConsumerService(w http.ResponseWriter, r *http.Request) {
+	// Check method
+	if r.Method != http.MethodPost {
+		utils.SendErrorResponse(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	if err := r.ParseForm(); err != nil {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+		return
+	}
+	encoded := r.PostForm.Get("SAMLResponse")
+	if encoded == "" {
+		utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrRequiredFields)
+		return
+	}
+
+	// Validate response
+	identity, err := h.serviceProvider.ParseResponse(encoded)
+	if err != nil {
+		if errors.Is(err, saml.ErrMalformedResponse) {
+			utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidSAMLResponse)
+			return
+		}
+		utils.SendErrorResponse(w, http.StatusUnauthorized, models.ErrInvalidSAMLResponse)
+		return
+	}
+
+	// Find, link or provision the local user
+	user, err := h.resolveUser(r.Context(), identity)
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Generate token pair
+	tokenPair, err := h.authService.GenerateTokenPair(r.Context(), user)
+	if err != nil {
+		sendStoreError(w, err)
+		return
+	}
+
+	// Return tokens
+	utils.SendJSONResponse(w, http.StatusOK, tokenPair)
+}
+
+// resolveUser returns the local user for a validated assertion, linking or
+// provisioning it like an OIDC sign-in. When a role attribute is configured,
+// the roles the IdP granted earlier are replaced with the asserted ones;
+// roles granted here or by other sources are kept.
+func (h *SAMLHandler) resolveUser(ctx context.Context, identity saml.Identity) (models.User, error) {
+	user, err := resolveFederatedUser(ctx, h.userStore, h.identityStore, samlProvider, identity.Subject, identity.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+	if identity.Roles == nil {
+		return user, nil
+	}
+	return h.userStore.SetManagedRoles(ctx, user.ID, samlProvider, identity.Roles)
+}
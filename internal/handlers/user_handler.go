@@ -31,9 +31,9 @@ func (h *UserHandler) GetUserInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user
-	user, exists := h.userStore.GetByID(claims.UserID)
-	if !exists {
-		utils.SendErrorResponse(w, http.StatusNotFound, models.ErrUserNotFound)
+	user, err := h.userStore.GetByID(r.Context(), claims.UserID)
+	if err != nil {
+		sendStoreError(w, err)
 		return
 	}
 
@@ -0,0 +1,222 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// Config holds the settings for an LDAP or Active Directory server
+type Config struct {
+	URL            string
+	StartTLS       bool
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string            // e.g. "(&(objectClass=person)(mail=%s))"; %s is the escaped email
+	EmailAttribute string            // e.g. "mail" or "userPrincipalName"
+	GroupAttribute string            // e.g. "memberOf"
+	GroupRoles     map[string]string // group DN -> role
+	Timeout        time.Duration
+}
+
+// source is the provider recorded on users the directory provisions and the
+// source of the roles mapped from their groups
+const source = "ldap"
+
+// Authenticator verifies credentials against a directory using search-and-bind
+// and provisions a matching local user record
+type Authenticator struct {
+	config    Config
+	userStore store.UserStore
+	groupDNs  map[*ldap.DN]string // parsed GroupRoles keys -> role
+}
+
+// NewAuthenticator creates a new instance of Authenticator
+func NewAuthenticator(config Config, userStore store.UserStore) (*Authenticator, error) {
+	if config.UserFilter == "" {
+		config.UserFilter = "(&(objectClass=person)(mail=%s))"
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	// Group DNs are compared structurally, so parse them once up front
+	groupDNs := make(map[*ldap.DN]string, len(config.GroupRoles))
+	for group, role := range config.GroupRoles {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group DN %q: %w", group, err)
+		}
+		groupDNs[dn] = role
+	}
+
+	return &Authenticator{
+		config:    config,
+		userStore: userStore,
+		groupDNs:  groupDNs,
+	}, nil
+}
+
+// Authenticate verifies credentials against the directory and returns the
+// provisioned local user with roles mapped from directory groups
+func (a *Authenticator) Authenticate(ctx context.Context, email, password string) (models.User, error) {
+	// An empty password would make the bind unauthenticated and always succeed
+	if email == "" || password == "" {
+		return models.User{}, store.ErrInvalidCredentials
+	}
+
+	entry, err := a.searchAndBind(email, password)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			return models.User{}, store.ErrInvalidCredentials
+		}
+		return models.User{}, fmt.Errorf("ldap: %w", err)
+	}
+
+	user, err := a.provision(ctx, email, entry)
+	if err != nil {
+		return models.User{}, fmt.Errorf("ldap: provisioning %s: %w", entry.DN, err)
+	}
+	return user, nil
+}
+
+// errInvalidCredentials is returned when the user doesn't exist or the bind fails
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// searchAndBind finds the user's entry with the service account and then
+// binds as that entry to verify the password
+func (a *Authenticator) searchAndBind(email, password string) (*ldap.Entry, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Search as the service account, or anonymously if none is configured
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+
+	request := ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.config.Timeout.Seconds()), false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(email)),
+		[]string{a.config.EmailAttribute, a.config.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, fmt.Errorf("user filter matched more than one entry for %q", email)
+		}
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, errInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	// Verify the password by binding as the user
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+	return entry, nil
+}
+
+// dial connects to the directory, upgrading to TLS if configured
+func (a *Authenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.StartTLS {
+		serverURL, err := url.Parse(a.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: serverURL.Hostname(), MinVersion: tls.VersionTLS12}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// provision creates or updates the local user for a directory entry
+func (a *Authenticator) provision(ctx context.Context, email string, entry *ldap.Entry) (models.User, error) {
+	if directoryEmail := entry.GetEqualFoldAttributeValue(a.config.EmailAttribute); directoryEmail != "" {
+		email = directoryEmail
+	}
+	roles := a.mapRoles(entry.GetEqualFoldAttributeValues(a.config.GroupAttribute))
+
+	// Directory users get a random local password; the directory stays
+	// authoritative. Accounts the directory didn't provision aren't adopted,
+	// since whoever created them may not own the directory entry.
+	password, err := randomPassword()
+	if err != nil {
+		return models.User{}, err
+	}
+	user, err := a.userStore.Provision(ctx, email, password, source)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// Keep the directory's roles in sync with current group membership. Only
+	// those roles are written, so roles granted otherwise and a concurrent
+	// password change or rehash aren't undone.
+	return a.userStore.SetManagedRoles(ctx, user.ID, source, roles)
+}
+
+// mapRoles converts group DNs into the sorted, de-duplicated set of mapped roles
+func (a *Authenticator) mapRoles(groups []string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, group := range groups {
+		groupDN, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		for dn, role := range a.groupDNs {
+			if groupDN.EqualFold(dn) && !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// randomPassword returns an unguessable password for provisioned users
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,344 @@
+package ldapauth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// testEntry is a directory entry served by testDirectory
+type testEntry struct {
+	password string
+	mail     string
+	memberOf []string
+}
+
+// testDirectory is an in-process LDAP server answering the simple binds and
+// equality searches the authenticator makes. Searches match the first
+// (mail=...) assertion in the filter against each entry's mail.
+type testDirectory struct {
+	listener net.Listener
+
+	mutex   sync.Mutex
+	entries map[string]testEntry // DN -> entry
+}
+
+const (
+	serviceDN       = "cn=service,dc=example,dc=com"
+	servicePassword = "service-secret"
+	baseDN          = "ou=people,dc=example,dc=com"
+	adminsDN        = "cn=admins,ou=groups,dc=example,dc=com"
+	auditorsDN      = "cn=auditors,ou=groups,dc=example,dc=com"
+)
+
+func newTestDirectory(t *testing.T) *testDirectory {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	d := &testDirectory{
+		listener: listener,
+		entries: map[string]testEntry{
+			serviceDN: {password: servicePassword},
+			"uid=alice," + baseDN: {
+				password: "alice-secret",
+				mail:     "alice@example.com",
+				memberOf: []string{"CN=Admins,OU=Groups,DC=example,DC=com", auditorsDN, "cn=unmapped,dc=example,dc=com"},
+			},
+			"uid=bob," + baseDN: {password: "bob-secret", mail: "bob@example.com"},
+		},
+	}
+
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.serve(conn)
+			}()
+		}
+	}()
+	return d
+}
+
+func (d *testDirectory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+// serve answers requests on one connection until the client goes away
+func (d *testDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil || len(request.Children) < 2 {
+			return
+		}
+		messageID := request.Children[0].Value.(int64)
+		op := request.Children[1]
+
+		var responses []*ber.Packet
+		d.mutex.Lock()
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			secret := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultSuccess)
+			if entry, exists := d.entries[dn]; !exists || entry.password != secret {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			responses = append(responses, result(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				responses = append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError))
+				break
+			}
+			for dn, entry := range d.entries {
+				if entry.mail != "" && strings.Contains(filter, "(mail="+entry.mail+")") {
+					responses = append(responses, searchEntry(dn, entry))
+				}
+			}
+			responses = append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		}
+		d.mutex.Unlock()
+		if responses == nil {
+			// Unbind, abandon and anything else end the conversation
+			return
+		}
+
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// result encodes an LDAPResult with the given application tag
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return packet
+}
+
+// searchEntry encodes a SearchResultEntry with the entry's mail and groups
+func searchEntry(dn string, entry testEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range map[string][]string{"mail": {entry.mail}, "memberOf": entry.memberOf} {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+// newTestAuthenticator returns an authenticator for the directory, backed by
+// userStore or a fresh in-memory store if it is nil
+func newTestAuthenticator(t *testing.T, d *testDirectory, userStore store.UserStore) *Authenticator {
+	if userStore == nil {
+		userStore = newTestUserStore()
+	}
+	a, err := NewAuthenticator(Config{
+		URL:          d.url(),
+		BindDN:       serviceDN,
+		BindPassword: servicePassword,
+		BaseDN:       baseDN,
+		GroupRoles:   map[string]string{adminsDN: "admin", auditorsDN: "auditor"},
+	}, userStore)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return a
+}
+
+func newTestUserStore() *store.InMemoryUserStore {
+	hasher := password.NewHasher(nil, password.NewBcrypt(4))
+	return store.NewInMemoryUserStore(hasher, emailaddr.NewNormalizer(false))
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newTestAuthenticator(t, newTestDirectory(t), nil)
+	ctx := context.Background()
+
+	user, err := a.Authenticate(ctx, "alice@example.com", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Email != "alice@example.com" {
+		t.Errorf("provisioned email %q, want alice@example.com", user.Email)
+	}
+	// Group DNs match regardless of case; unmapped groups are ignored
+	if got := strings.Join(user.AllRoles(), ","); got != "admin,auditor" {
+		t.Errorf("roles %q, want admin,auditor", got)
+	}
+
+	// Signing in again finds the same local user
+	again, err := a.Authenticate(ctx, "alice@example.com", "alice-secret")
+	if err != nil || again.ID != user.ID {
+		t.Errorf("second Authenticate: got %+v, %v; want user %s", again, err, user.ID)
+	}
+}
+
+func TestAuthenticateRejectsBadCredentials(t *testing.T) {
+	a := newTestAuthenticator(t, newTestDirectory(t), nil)
+	tests := []struct {
+		name, email, password string
+	}{
+		{"wrong password", "alice@example.com", "wrong"},
+		{"unknown user", "carol@example.com", "carol-secret"},
+		{"empty password", "alice@example.com", ""},
+		{"empty email", "", "alice-secret"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := a.Authenticate(context.Background(), test.email, test.password)
+			if !errors.Is(err, store.ErrInvalidCredentials) {
+				t.Errorf("got error %v, want %v", err, store.ErrInvalidCredentials)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSyncsRoles(t *testing.T) {
+	d := newTestDirectory(t)
+	a := newTestAuthenticator(t, d, nil)
+	ctx := context.Background()
+
+	if _, err := a.Authenticate(ctx, "alice@example.com", "alice-secret"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	// Leaving a group removes the role at the next sign-in
+	d.mutex.Lock()
+	entry := d.entries["uid=alice,"+baseDN]
+	entry.memberOf = []string{auditorsDN}
+	d.entries["uid=alice,"+baseDN] = entry
+	d.mutex.Unlock()
+	user, err := a.Authenticate(ctx, "alice@example.com", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got := strings.Join(user.AllRoles(), ","); got != "auditor" {
+		t.Errorf("roles %q, want auditor", got)
+	}
+
+	// Users without mapped groups have no roles
+	user, err = a.Authenticate(ctx, "bob@example.com", "bob-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if len(user.AllRoles()) != 0 {
+		t.Errorf("roles %v, want none", user.AllRoles())
+	}
+}
+
+// racingUserStore changes the user's password right after provisioning
+// finds the user, the way a concurrent password reset would
+type racingUserStore struct {
+	*store.InMemoryUserStore
+	password string
+}
+
+func (s *racingUserStore) Provision(ctx context.Context, email, password, provider string) (models.User, error) {
+	user, err := s.InMemoryUserStore.Provision(ctx, email, password, provider)
+	if err == nil {
+		err = s.InMemoryUserStore.SetPassword(ctx, user.ID, s.password, 0)
+	}
+	return user, err
+}
+
+func TestAuthenticateKeepsConcurrentPasswordChange(t *testing.T) {
+	users := &racingUserStore{InMemoryUserStore: newTestUserStore(), password: "Changed-Horse-Battery-7"}
+	a := newTestAuthenticator(t, newTestDirectory(t), users)
+	ctx := context.Background()
+
+	user, err := a.Authenticate(ctx, "alice@example.com", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got := strings.Join(user.AllRoles(), ","); got != "admin,auditor" {
+		t.Errorf("roles %q, want admin,auditor", got)
+	}
+	if _, err := users.Authenticate(ctx, "alice@example.com", users.password); err != nil {
+		t.Errorf("password changed during provisioning was lost: %v", err)
+	}
+}
+
+func TestAuthenticateKeepsLocalRoles(t *testing.T) {
+	users := newTestUserStore()
+	a := newTestAuthenticator(t, newTestDirectory(t), users)
+	ctx := context.Background()
+
+	user, err := a.Authenticate(ctx, "bob@example.com", "bob-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	user.Roles = []string{"billing"}
+	if err := users.Update(ctx, user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// Syncing group membership only replaces the directory's roles
+	user, err = a.Authenticate(ctx, "bob@example.com", "bob-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got := strings.Join(user.AllRoles(), ","); got != "billing" {
+		t.Errorf("roles %q, want billing", got)
+	}
+}
+
+func TestAuthenticateRefusesLocalAccount(t *testing.T) {
+	users := newTestUserStore()
+	a := newTestAuthenticator(t, newTestDirectory(t), users)
+	ctx := context.Background()
+
+	local, err := users.Create(ctx, "alice@example.com", "Correct-Horse-Battery-9")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// The directory entry doesn't take over an account created here
+	_, err = a.Authenticate(ctx, "alice@example.com", "alice-secret")
+	if !errors.Is(err, store.ErrConflict) {
+		t.Errorf("got error %v, want %v", err, store.ErrConflict)
+	}
+	stored, err := users.GetByID(ctx, local.ID)
+	if err != nil || len(stored.AllRoles()) != 0 {
+		t.Errorf("local account roles %v, %v; want none", stored.AllRoles(), err)
+	}
+}
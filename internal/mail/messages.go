@@ -0,0 +1,21 @@
+package mail
+
+// WelcomeMessage is sent to a newly registered account
+func WelcomeMessage(email string) Message {
+	return Message{
+		To:      email,
+		Subject: "Welcome",
+		Body:    "Your account has been created. You can now sign in with this email address.\n",
+	}
+}
+
+// AccountExistsMessage is sent to the owner of an account when someone tries
+// to sign up again with its email address
+func AccountExistsMessage(email string) Message {
+	return Message{
+		To:      email,
+		Subject: "Sign-up attempt for your account",
+		Body: "Someone tried to create an account with this email address, but you already have one.\n" +
+			"If this was you, sign in instead. Otherwise you can ignore this message.\n",
+	}
+}
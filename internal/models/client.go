@@ -0,0 +1,7 @@
+package models
+
+// Client represents an OAuth 2.0 client registered with the service
+type Client struct {
+	ID         string `json:"client_id"`
+	SecretHash string `json:"-"` // Don't return secret in responses
+}
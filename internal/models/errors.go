@@ -6,18 +6,49 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
+// PasswordPolicyErrorResponse is returned when a password is rejected by the
+// password policy, listing each rule it breaks
+type PasswordPolicyErrorResponse struct {
+	Status     int                 `json:"status"`
+	Message    string              `json:"message"`
+	Violations []PasswordViolation `json:"violations"`
+}
+
 // ErrorMessages holds constant error messages to be used across the application
 const (
-	ErrInvalidRequest      = "Invalid request payload"
-	ErrInvalidCredentials  = "Invalid credentials"
-	ErrEmailAlreadyExists  = "Email already registered"
-	ErrInvalidToken        = "Invalid token"
-	ErrTokenExpired        = "Token has expired"
-	ErrTokenRevoked        = "Token has been revoked"
-	ErrUserNotFound        = "User not found"
-	ErrMethodNotAllowed    = "Method not allowed"
-	ErrTokenRequired       = "Authorization token required"
-	ErrInternalServerError = "Internal server error"
-	ErrInvalidRefreshToken = "Invalid refresh token"
-	ErrRequiredFields      = "Required fields missing"
+	ErrInvalidRequest          = "Invalid request payload"
+	ErrInvalidCredentials      = "Invalid credentials"
+	ErrEmailAlreadyExists      = "Email already registered"
+	ErrInvalidToken            = "Invalid token"
+	ErrTokenExpired            = "Token has expired"
+	ErrTokenRevoked            = "Token has been revoked"
+	ErrUserNotFound            = "User not found"
+	ErrMethodNotAllowed        = "Method not allowed"
+	ErrTokenRequired           = "Authorization token required"
+	ErrInternalServerError     = "Internal server error"
+	ErrInvalidRefreshToken     = "Invalid refresh token"
+	ErrRequiredFields          = "Required fields missing"
+	ErrInvalidUserCode         = "Invalid or expired user code"
+	ErrDeviceAlreadyHandled    = "Device authorization has already been approved or denied"
+	ErrInvalidClient           = "Invalid client credentials"
+	ErrClientAlreadyExists     = "Client already registered"
+	ErrUnknownProvider         = "Unknown identity provider"
+	ErrInvalidLoginState       = "Invalid or expired login state"
+	ErrFederatedLogin          = "Sign-in with identity provider failed"
+	ErrEmailNotVerified        = "Email address not verified by identity provider"
+	ErrIdentityLinked          = "Identity already linked to another user"
+	ErrAccountExists           = "An account with this email already exists"
+	ErrInvalidSAMLResponse     = "Invalid SAML response"
+	ErrTooManyRequests         = "Too many requests, please try again later"
+	ErrAccountLocked           = "Too many failed sign-in attempts, please try again later"
+	ErrForbidden               = "Insufficient permissions"
+	ErrWeakPassword            = "Password does not meet requirements"
+	ErrUnsupportedPasswordHash = "Unsupported password hash format or parameters"
+	ErrUserAlreadyExists       = "User ID already exists"
+	ErrPasswordReused          = "Password was used recently; choose a different one"
+	ErrPasswordChangeRequired  = "Password change required"
+	ErrPasswordChanged         = "Password was changed concurrently"
+	ErrInvalidEmail            = "Invalid email address"
+	ErrDisposableEmail         = "Email addresses from this domain are not accepted"
+	ErrServiceUnavailable      = "Service temporarily unavailable, please try again later"
 )
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// FederatedIdentity links an account at an upstream identity provider to a local user
+type FederatedIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FederatedLoginState holds what is needed to complete an upstream login
+// started by this service
+type FederatedLoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+
+	// Signed-in user the upstream identity is to be linked to, if the login
+	// was started to link one rather than to sign in
+	LinkUserID string
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// LoginAttempts tracks the failed sign-ins for one account
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	ExpiresAt   time.Time // when the record is forgotten
+}
+
+// UnlockAccountRequest represents the request payload for unlocking an account
+type UnlockAccountRequest struct {
+	Email string `json:"email"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// OAuth 2.0 grant types
+const (
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Token type hints (RFC 7009 section 2.1 and RFC 7662 section 2.1)
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// OAuth 2.0 error codes (RFC 6749 section 5.2 and RFC 8628 section 3.5)
+const (
+	OAuthErrInvalidRequest       = "invalid_request"
+	OAuthErrInvalidClient        = "invalid_client"
+	OAuthErrUnauthorizedClient   = "unauthorized_client"
+	OAuthErrInvalidGrant         = "invalid_grant"
+	OAuthErrUnsupportedGrantType = "unsupported_grant_type"
+	OAuthErrAuthorizationPending = "authorization_pending"
+	OAuthErrSlowDown             = "slow_down"
+	OAuthErrAccessDenied         = "access_denied"
+	OAuthErrExpiredToken         = "expired_token"
+	OAuthErrServerError          = "server_error"
+)
+
+// OAuthErrorResponse represents an error response as defined by RFC 6749
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// DeviceAuthorizationStatus tracks where a device authorization is in its lifecycle
+type DeviceAuthorizationStatus string
+
+const (
+	DeviceAuthorizationPending  DeviceAuthorizationStatus = "pending"
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	DeviceAuthorizationDenied   DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceAuthorization represents an outstanding device authorization request
+type DeviceAuthorization struct {
+	DeviceCode   string
+	UserCode     string
+	ClientID     string
+	Scope        string
+	Status       DeviceAuthorizationStatus
+	UserID       string
+	Interval     time.Duration
+	ExpiresAt    time.Time
+	LastPolledAt time.Time
+}
+
+// DeviceAuthorizationResponse represents the response of the device authorization endpoint
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceApprovalRequest represents the request payload for approving or denying a device
+type DeviceApprovalRequest struct {
+	UserCode string `json:"user_code"`
+	Deny     bool   `json:"deny"`
+}
+
+// IntrospectionResponse represents the response of the token introspection endpoint (RFC 7662)
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+}
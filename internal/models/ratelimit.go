@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// RateLimitPolicy describes a token bucket: up to Limit requests, refilled
+// evenly over Period
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, when denied
+}
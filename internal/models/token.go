@@ -10,12 +10,28 @@ type TokenPair struct {
 
 // Claims represents the JWT claims
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID   string   `json:"user_id"`
+	Email    string   `json:"email"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	// SessionID and AuthTime are kept when the session is refreshed
+	SessionID string           `json:"sid,omitempty"`
+	AuthTime  *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ScopePasswordChange is the scope of restricted tokens that may only be
+// used to change an expired password
+const ScopePasswordChange = "password_change"
+
 // RefreshRequest represents the request payload for refreshing tokens
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
+
+// RevokeSessionsRequest represents the request payload for ending all of a
+// user's sessions
+type RevokeSessionsRequest struct {
+	Email string `json:"email"`
+}
@@ -1,13 +1,60 @@
 package models
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
 // User represents the user model
 type User struct {
 	ID        string    `json:"id"`
 	Email     string    `json:"email"`
 	Password  string    `json:"-"` // Don't return password in responses
+	Roles     []string  `json:"roles,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// Hashes of earlier passwords, most recent first, and when the current one was set
+	PasswordHistory   []string  `json:"-"`
+	PasswordChangedAt time.Time `json:"-"`
+
+	// Identity provider that created the account at its first sign-in, or
+	// empty for accounts that signed up or were imported
+	ProvisionedBy string `json:"-"`
+
+	// Roles granted by external sources such as a directory or IdP, by
+	// source. Roles holds the ones granted here. Use AllRoles for both.
+	ManagedRoles map[string][]string `json:"-"`
+}
+
+// AllRoles returns the user's own roles followed by those granted by
+// external sources, without duplicates
+func (u User) AllRoles() []string {
+	if len(u.ManagedRoles) == 0 {
+		return u.Roles
+	}
+	sources := make([]string, 0, len(u.ManagedRoles))
+	for source := range u.ManagedRoles {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	seen := make(map[string]bool)
+	var roles []string
+	add := func(role string) {
+		if !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	for _, role := range u.Roles {
+		add(role)
+	}
+	for _, source := range sources {
+		for _, role := range u.ManagedRoles[source] {
+			add(role)
+		}
+	}
+	return roles
 }
 
 // SignupRequest represents the request payload for user registration
@@ -22,10 +69,25 @@ type SigninRequest struct {
 	Password string `json:"password"`
 }
 
+// ChangePasswordRequest represents the request payload for changing a password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// PasswordChangeRequiredResponse is returned by sign-in when the password has
+// expired. The access token may only be used to change the password.
+type PasswordChangeRequiredResponse struct {
+	Error       string `json:"error"`
+	Message     string `json:"message"`
+	AccessToken string `json:"access_token"`
+}
+
 // UserResponse represents the user data returned in API responses
 type UserResponse struct {
 	ID        string    `json:"id"`
 	Email     string    `json:"email"`
+	Roles     []string  `json:"roles,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -34,6 +96,41 @@ func NewUserResponse(user User) UserResponse {
 	return UserResponse{
 		ID:        user.ID,
 		Email:     user.Email,
+		Roles:     user.AllRoles(),
 		CreatedAt: user.CreatedAt,
 	}
 }
+
+// PasswordViolation describes one password policy rule a password breaks
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ImportUserRecord describes a user migrated from another system, with the
+// password hash in one of the supported formats
+type ImportUserRecord struct {
+	ID           string    `json:"id,omitempty"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash"`
+	Roles        []string  `json:"roles,omitempty"`
+	CreatedAt    time.Time `json:"created_at,omitempty"`
+}
+
+// ImportUsersRequest represents the request payload for a bulk user import
+type ImportUsersRequest struct {
+	Users []ImportUserRecord `json:"users"`
+}
+
+// ImportUsersResponse reports the outcome of a bulk user import
+type ImportUsersResponse struct {
+	Imported int             `json:"imported"`
+	Failures []ImportFailure `json:"failures"`
+}
+
+// ImportFailure describes a record that couldn't be imported
+type ImportFailure struct {
+	Index int    `json:"index"`
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
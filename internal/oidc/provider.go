@@ -0,0 +1,261 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrEmailNotVerified is returned when the provider doesn't vouch for the user's email
+var ErrEmailNotVerified = errors.New("email address not verified by provider")
+
+// signingAlgorithms are the id_token signing algorithms accepted from providers
+var signingAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+// ProviderConfig holds the settings for one upstream OIDC provider
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is the verified result of an upstream login
+type Identity struct {
+	Provider string
+	Subject  string
+	Email    string
+	Name     string
+}
+
+// IDTokenClaims represents the claims of an OIDC id_token
+type IDTokenClaims struct {
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Name            string `json:"name"`
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// discoveryDocument holds the fields used from the provider's
+// /.well-known/openid-configuration document
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// tokenResponse holds the fields used from the provider's token endpoint response
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// Provider is an OIDC relying-party client for a single upstream provider.
+// Endpoints are discovered lazily so the service can start while a provider is unreachable.
+type Provider struct {
+	config     ProviderConfig
+	httpClient *http.Client
+
+	discovery      *discoveryDocument
+	discoveryMutex sync.Mutex
+	keys           *keySet
+}
+
+// NewProvider creates a new instance of Provider. If httpClient is nil a
+// client with a 10 second timeout is used.
+func NewProvider(config ProviderConfig, httpClient *http.Client) *Provider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		config:     config,
+		httpClient: httpClient,
+	}
+}
+
+// Name returns the configured provider name
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the provider's authorization URL for a login attempt.
+// The PKCE challenge is derived from codeVerifier (RFC 7636, S256).
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified identity
+// from the id_token. The id_token nonce must match nonce.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (Identity, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	// Redeem the authorization code
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var token tokenResponse
+	if err := p.doJSON(req, &token); err != nil {
+		return Identity{}, fmt.Errorf("token exchange: %w", err)
+	}
+	if token.IDToken == "" {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+
+	// Validate the id_token
+	claims, err := p.VerifyIDToken(ctx, token.IDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if claims.Nonce != nonce {
+		return Identity{}, errors.New("id_token nonce mismatch")
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return Identity{}, ErrEmailNotVerified
+	}
+
+	return Identity{
+		Provider: p.config.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		Name:     claims.Name,
+	}, nil
+}
+
+// VerifyIDToken validates an id_token's signature against the provider's
+// JWKS, and its issuer, audience and expiry
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken string) (*IDTokenClaims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &IDTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	},
+		jwt.WithValidMethods(signingAlgorithms),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	// With several audiences the token must be meant for us (OIDC Core 3.1.3.7)
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, errors.New("invalid id_token: authorized party mismatch")
+	}
+
+	return claims, nil
+}
+
+// discover fetches and caches the provider's discovery document
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.discoveryMutex.Lock()
+	defer p.discoveryMutex.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc discoveryDocument
+	if err := p.doJSON(req, &doc); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+
+	// The issuer must match exactly (OIDC Discovery section 4.3)
+	if doc.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match configured issuer %q", doc.Issuer, p.config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("discovery: document is missing required endpoints")
+	}
+
+	p.discovery = &doc
+	p.keys = newKeySet(doc.JWKSURI, p.httpClient)
+	return p.discovery, nil
+}
+
+// doJSON performs a request and decodes a successful JSON response into v
+func (p *Provider) doJSON(req *http.Request, v interface{}) error {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Redacted())
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// RandomString returns a URL-safe random string suitable for state, nonce
+// and PKCE code verifier values
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
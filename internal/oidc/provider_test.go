@@ -0,0 +1,311 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is an in-process OIDC provider. It issues an id_token for a
+// code registered with authorize, checking the client's credentials and
+// PKCE verifier, and signs it with its current key.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	issuer string // issuer announced in the discovery document
+
+	mutex      sync.Mutex
+	keys       map[string]*rsa.PrivateKey
+	current    string
+	jwksHits   int
+	codes      map[string]mockGrant
+	tokenClaim func(*IDTokenClaims) // adjusts the claims of the next id_token
+}
+
+// mockGrant is what the provider remembers about an authorization code
+type mockGrant struct {
+	nonce     string
+	challenge string
+}
+
+const (
+	mockClientID     = "client-1"
+	mockClientSecret = "client-secret"
+)
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{
+		t:     t,
+		keys:  make(map[string]*rsa.PrivateKey),
+		codes: make(map[string]mockGrant),
+	}
+	m.rotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		issuer := m.issuer
+		m.mutex.Unlock()
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                issuer,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", m.serveJWKS)
+	mux.HandleFunc("/token", m.serveToken)
+	m.server = httptest.NewServer(mux)
+	m.issuer = m.server.URL
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// rotateKey makes a new signing key current, keeping the old ones published
+func (m *mockProvider) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatalf("generate key: %v", err)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.keys[kid] = key
+	m.current = kid
+}
+
+// authorize stands in for the user approving the login at the provider,
+// returning the code the provider redirects back with
+func (m *mockProvider) authorize(authURL string) string {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("parse authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != mockClientID || query.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = mockGrant{nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	return code
+}
+
+func (m *mockProvider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.jwksHits++
+
+	var keys []jsonWebKey
+	for kid, key := range m.keys {
+		keys = append(keys, jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (m *mockProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != mockClientID || secret != mockClientSecret {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+	r.ParseForm()
+
+	m.mutex.Lock()
+	grant, exists := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mutex.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !exists || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	claims := &IDTokenClaims{
+		Email:         "user@example.com",
+		EmailVerified: true,
+		Name:          "Test User",
+		Nonce:         grant.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.server.URL,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{mockClientID},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	}
+	m.mutex.Lock()
+	if m.tokenClaim != nil {
+		m.tokenClaim(claims)
+	}
+	kid, key := m.current, m.keys[m.current]
+	m.mutex.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		m.t.Errorf("sign id_token: %v", err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "unused"})
+}
+
+// jwksFetches returns how many times the JWKS has been fetched
+func (m *mockProvider) jwksFetches() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.jwksHits
+}
+
+// provider returns a relying party configured for the mock provider
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(ProviderConfig{
+		Name:         "mock",
+		Issuer:       m.server.URL,
+		ClientID:     mockClientID,
+		ClientSecret: mockClientSecret,
+		RedirectURL:  "http://localhost/callback",
+	}, m.server.Client())
+}
+
+// login runs a full login through the mock provider
+func (m *mockProvider) login(p *Provider, state, nonce, verifier string) (Identity, error) {
+	ctx := context.Background()
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return Identity{}, err
+	}
+	return p.Exchange(ctx, m.authorize(authURL), nonce, verifier)
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	identity, err := m.login(m.provider(), "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	want := Identity{Provider: "mock", Subject: "subject-1", Email: "user@example.com", Name: "Test User"}
+	if identity != want {
+		t.Errorf("got identity %+v, want %+v", identity, want)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if _, err := p.Exchange(ctx, m.authorize(authURL), "nonce-1", "another-verifier"); err == nil {
+		t.Error("exchange with the wrong PKCE verifier succeeded")
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	ctx := context.Background()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if _, err := p.Exchange(ctx, m.authorize(authURL), "nonce-2", "verifier-1"); err == nil {
+		t.Error("exchange with a mismatched nonce succeeded")
+	}
+}
+
+func TestExchangeRejectsInvalidClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		adjust func(*IDTokenClaims)
+		want   error
+	}{
+		{"wrong issuer", func(c *IDTokenClaims) { c.Issuer = "https://attacker.example.com" }, nil},
+		{"wrong audience", func(c *IDTokenClaims) { c.Audience = jwt.ClaimStrings{"another-client"} }, nil},
+		{"expired", func(c *IDTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) }, nil},
+		{"no expiry", func(c *IDTokenClaims) { c.ExpiresAt = nil }, nil},
+		{"other authorized party", func(c *IDTokenClaims) {
+			c.Audience = jwt.ClaimStrings{mockClientID, "another-client"}
+			c.AuthorizedParty = "another-client"
+		}, nil},
+		{"unverified email", func(c *IDTokenClaims) { c.EmailVerified = false }, ErrEmailNotVerified},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.mutex.Lock()
+			m.tokenClaim = test.adjust
+			m.mutex.Unlock()
+			_, err := m.login(m.provider(), "state-1", "nonce-1", "verifier-1")
+			if err == nil {
+				t.Fatal("login succeeded")
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("got error %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	m.mutex.Lock()
+	m.issuer = "https://attacker.example.com"
+	m.mutex.Unlock()
+	_, err := m.provider().AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	if err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("got error %v, want an issuer mismatch", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	if _, err := m.login(p, "state-1", "nonce-1", "verifier-1"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	// A token signed with a new key is rejected while a refetch is too recent,
+	// without asking the provider again
+	m.rotateKey("key-2")
+	if _, err := m.login(p, "state-2", "nonce-2", "verifier-2"); err == nil {
+		t.Fatal("login with an unknown key succeeded before the refresh interval")
+	}
+	if hits := m.jwksFetches(); hits != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", hits)
+	}
+
+	// Once the interval has passed, the new key is fetched
+	p.keys.mutex.Lock()
+	p.keys.lastRefresh = time.Now().Add(-minRefreshInterval)
+	p.keys.mutex.Unlock()
+	if _, err := m.login(p, "state-3", "nonce-3", "verifier-3"); err != nil {
+		t.Fatalf("login after key rotation: %v", err)
+	}
+	if hits := m.jwksFetches(); hits != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", hits)
+	}
+}
@@ -0,0 +1,151 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2idPrefix starts every argon2id hash in PHC string format
+const argon2idPrefix = "$argon2id$"
+
+// Limits on the parameters of an argon2id hash that is verified, unless the
+// configured parameters are higher
+const (
+	maxArgon2Memory = 1 << 20 // KiB, so 1 GiB
+	maxArgon2Time   = 16
+	maxArgon2Key    = 64
+)
+
+// Argon2id hashes passwords with argon2id, encoded in PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>
+type Argon2id struct {
+	Memory  uint32 // in KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// NewArgon2id creates a new instance of Argon2id with the given cost
+// parameters and 16-byte salts and 32-byte keys
+func NewArgon2id(memory, time uint32, threads uint8) *Argon2id {
+	// argon2 requires at least one pass and one thread
+	if time == 0 {
+		time = 1
+	}
+	if threads == 0 {
+		threads = 1
+	}
+	return &Argon2id{
+		Memory:  memory,
+		Time:    time,
+		Threads: threads,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+// argon2Params holds the parameters decoded from a stored hash
+type argon2Params struct {
+	version uint32
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Matches reports whether encoded is an argon2id hash
+func (a *Argon2id) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+// Hash hashes password with a random salt
+func (a *Argon2id) Hash(password []byte) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against an argon2id hash using the parameters stored in it
+func (a *Argon2id) Verify(password []byte, encoded string) (bool, error) {
+	params, err := a.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey(password, params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+// NeedsRehash reports whether encoded was made with other parameters than configured
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	params, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.version != argon2.Version ||
+		params.memory != a.Memory ||
+		params.time != a.Time ||
+		params.threads != a.Threads ||
+		uint32(len(params.key)) != a.KeyLen
+}
+
+// Check parses an argon2id hash and checks its parameters
+func (a *Argon2id) Check(encoded string) error {
+	_, err := a.decode(encoded)
+	return err
+}
+
+// decode parses an argon2id hash and checks its parameters against the
+// limits, which are raised to the configured parameters if those are higher
+func (a *Argon2id) decode(encoded string) (argon2Params, error) {
+	params, err := decodeArgon2id(encoded)
+	if err != nil {
+		return params, err
+	}
+	if params.version != argon2.Version || params.time == 0 || params.threads == 0 {
+		return params, ErrUnknownHashFormat
+	}
+	if (params.memory > maxArgon2Memory && params.memory > a.Memory) ||
+		(params.time > maxArgon2Time && params.time > a.Time) ||
+		(len(params.key) > maxArgon2Key && uint32(len(params.key)) > a.KeyLen) {
+		return params, ErrHashTooExpensive
+	}
+	return params, nil
+}
+
+// decodeArgon2id parses an argon2id hash in PHC string format
+func decodeArgon2id(encoded string) (argon2Params, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &params.version); err != nil {
+		return params, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil ||
+		parts[3] != fmt.Sprintf("m=%d,t=%d,p=%d", params.memory, params.time, params.threads) {
+		return params, ErrUnknownHashFormat
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, ErrUnknownHashFormat
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return params, ErrUnknownHashFormat
+	}
+	return params, nil
+}
@@ -0,0 +1,78 @@
+package password
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxBcryptCost is the highest cost of a bcrypt hash that is verified, unless
+// a higher cost is configured. Each step doubles the work; bcrypt itself
+// accepts up to 31, which takes hours.
+const maxBcryptCost = 16
+
+// bcryptHashLen is the length of every bcrypt hash
+const bcryptHashLen = 60
+
+// Bcrypt hashes passwords with bcrypt, encoded in its own modular crypt
+// format: $2a$<cost>$<salt+hash>
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt creates a new instance of Bcrypt
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{
+		Cost: cost,
+	}
+}
+
+// Matches reports whether encoded is a bcrypt hash
+func (b *Bcrypt) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Hash hashes password with a random salt
+func (b *Bcrypt) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify checks password against a bcrypt hash
+func (b *Bcrypt) Verify(password []byte, encoded string) (bool, error) {
+	if err := b.Check(encoded); err != nil {
+		return false, err
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NeedsRehash reports whether encoded was made with a lower cost than configured
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
+
+// Check parses a bcrypt hash and checks its cost
+func (b *Bcrypt) Check(encoded string) error {
+	if len(encoded) != bcryptHashLen {
+		return ErrUnknownHashFormat
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return ErrUnknownHashFormat
+	}
+	if cost > maxBcryptCost && cost > b.Cost {
+		return ErrHashTooExpensive
+	}
+	return nil
+}
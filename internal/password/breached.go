@@ -0,0 +1,75 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachedCorpus reports whether a password is known to have been breached
+type BreachedCorpus interface {
+	Contains(password string) (bool, error)
+}
+
+// prefixLength is the number of hex digits of the SHA-1 hash that name a
+// range file
+const prefixLength = 5
+
+// RangeCorpus is a breached-password corpus kept on disk in the Have I Been
+// Pwned range layout: a directory with one file per 5-hex-digit SHA-1 prefix,
+// named PREFIX.txt, holding one "SUFFIX:COUNT" per line for the hashes with
+// that prefix. Only the file for a password's prefix is read, so memory use
+// doesn't grow with the corpus; the full corpus is about a million files of
+// a few hundred lines each.
+type RangeCorpus struct {
+	dir string
+}
+
+// OpenRangeCorpus creates a new instance of RangeCorpus for the range files
+// in dir
+func OpenRangeCorpus(dir string) (*RangeCorpus, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &RangeCorpus{dir: dir}, nil
+}
+
+// Contains reports whether the SHA-1 hash of password is in the corpus. A
+// missing range file means no breached password has that prefix.
+func (c *RangeCorpus) Contains(password string) (bool, error) {
+	hash := sha1.Sum([]byte(password))
+	hexHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hexHash[:prefixLength], hexHash[prefixLength:]
+
+	path := filepath.Join(c.dir, prefix+".txt")
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(entry, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	return false, nil
+}
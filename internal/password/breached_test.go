@@ -0,0 +1,49 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRangeCorpus(t *testing.T) {
+	// SHA-1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	dir := t.TempDir()
+	rangeFile := "0018A45C4D1DEF81644B54AB7F969B88D65:1\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\n"
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(rangeFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := OpenRangeCorpus(dir)
+	if err != nil {
+		t.Fatalf("OpenRangeCorpus: %v", err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"Password", false},                // no range file for its prefix
+		{"Correct-Horse-Battery-9", false}, // no range file for its prefix
+	}
+	for _, test := range tests {
+		got, err := corpus.Contains(test.password)
+		if err != nil || got != test.want {
+			t.Errorf("Contains(%q) = %v, %v; want %v", test.password, got, err, test.want)
+		}
+	}
+
+	// A password whose prefix has a file but isn't listed in it
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := corpus.Contains("password"); err != nil || got {
+		t.Errorf("Contains(unlisted suffix) = %v, %v; want false", got, err)
+	}
+}
+
+func TestOpenRangeCorpusRequiresDirectory(t *testing.T) {
+	if _, err := OpenRangeCorpus(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("OpenRangeCorpus(missing directory) succeeded")
+	}
+}
@@ -0,0 +1,34 @@
+package password
+
+import (
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// ExpiryPolicy decides when a password must be changed. The shortest maximum
+// age among the default and the user's roles applies; zero means no expiry.
+type ExpiryPolicy struct {
+	MaxAge     time.Duration
+	RoleMaxAge map[string]time.Duration
+}
+
+// Expired reports whether user's password is older than allowed at now
+func (p ExpiryPolicy) Expired(user models.User, now time.Time) bool {
+	maxAge := p.MaxAge
+	for _, role := range user.AllRoles() {
+		if roleMaxAge := p.RoleMaxAge[role]; roleMaxAge > 0 && (maxAge == 0 || roleMaxAge < maxAge) {
+			maxAge = roleMaxAge
+		}
+	}
+	if maxAge == 0 {
+		return false
+	}
+
+	// Imported users may not record when their password was set
+	changedAt := user.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = user.CreatedAt
+	}
+	return now.Sub(changedAt) > maxAge
+}
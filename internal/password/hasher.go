@@ -0,0 +1,129 @@
+package password
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Errors returned when a stored hash can't be verified
+var (
+	ErrUnknownHashFormat    = errors.New("unknown password hash format")
+	ErrUnknownPepperVersion = errors.New("password hash uses an unknown pepper version")
+	ErrHashTooExpensive     = errors.New("password hash parameters exceed the supported cost")
+)
+
+// Algorithm hashes passwords into a self-describing string and verifies them
+type Algorithm interface {
+	// Matches reports whether encoded was produced by this algorithm
+	Matches(encoded string) bool
+	Hash(password []byte) (string, error)
+	Verify(password []byte, encoded string) (bool, error)
+	// Check parses all of encoded without verifying a password. It returns
+	// ErrUnknownHashFormat if encoded is malformed and ErrHashTooExpensive if
+	// its parameters are over the limits Verify accepts.
+	Check(encoded string) error
+	// NeedsRehash reports whether encoded uses weaker parameters than configured
+	NeedsRehash(encoded string) bool
+}
+
+// Hasher hashes new passwords with the current algorithm and verifies stored
+// hashes made by any of the known algorithms. With a pepper, passwords are
+// mixed with the current pepper key before hashing, and the key version is
+// recorded in front of the hash.
+type Hasher struct {
+	pepper     *Pepper
+	current    Algorithm
+	algorithms []Algorithm
+}
+
+// NewHasher creates a new instance of Hasher. New hashes use current; older
+// hashes made by current or any of legacy can still be verified. pepper may
+// be nil to hash passwords unpeppered.
+func NewHasher(pepper *Pepper, current Algorithm, legacy ...Algorithm) *Hasher {
+	return &Hasher{
+		pepper:     pepper,
+		current:    current,
+		algorithms: append([]Algorithm{current}, legacy...),
+	}
+}
+
+// Hash hashes password with the current algorithm and pepper
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.pepper == nil {
+		return h.current.Hash([]byte(password))
+	}
+	peppered, _ := h.pepper.apply(h.pepper.current, password)
+	encoded, err := h.current.Hash(peppered)
+	if err != nil {
+		return "", err
+	}
+	return pepperPrefix + strconv.Itoa(h.pepper.current) + encoded, nil
+}
+
+// Supports reports whether a stored hash can be verified: it is well formed,
+// made by one of the algorithms with parameters within their limits, and
+// peppered, if at all, with a known pepper version. Imported hashes are
+// checked with it, so a malformed or deliberately expensive hash is rejected
+// up front instead of failing or stalling at sign-in.
+func (h *Hasher) Supports(encoded string) bool {
+	version, inner, err := splitPepper(encoded)
+	if err != nil {
+		return false
+	}
+	if version != 0 {
+		if h.pepper == nil {
+			return false
+		}
+		if _, exists := h.pepper.keys[version]; !exists {
+			return false
+		}
+	}
+	algorithm := h.algorithm(inner)
+	return algorithm != nil && algorithm.Check(inner) == nil
+}
+
+// Verify checks password against a stored hash. rehash is true when the
+// password matched but the hash should be replaced with a fresh Hash, because
+// it uses an outdated algorithm, parameters or pepper.
+func (h *Hasher) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	version, inner, err := splitPepper(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	algorithm := h.algorithm(inner)
+	if algorithm == nil {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	input := []byte(password)
+	if version != 0 {
+		if h.pepper == nil {
+			return false, false, ErrUnknownPepperVersion
+		}
+		var exists bool
+		if input, exists = h.pepper.apply(version, password); !exists {
+			return false, false, ErrUnknownPepperVersion
+		}
+	}
+
+	ok, err = algorithm.Verify(input, inner)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	currentVersion := 0
+	if h.pepper != nil {
+		currentVersion = h.pepper.current
+	}
+	rehash = algorithm != h.current || algorithm.NeedsRehash(inner) || version != currentVersion
+	return true, rehash, nil
+}
+
+// algorithm returns the algorithm that produced encoded, or nil
+func (h *Hasher) algorithm(encoded string) Algorithm {
+	for _, algorithm := range h.algorithms {
+		if algorithm.Matches(encoded) {
+			return algorithm
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package password
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	testSalt = []byte("0123456789abcdef")
+	b64      = base64.RawStdEncoding.EncodeToString
+)
+
+// pbkdf2Hash makes a PBKDF2-SHA256 hash of password in PHC string format
+func pbkdf2Hash(password string, iterations int) string {
+	key := pbkdf2.Key([]byte(password), testSalt, iterations, sha256.Size, sha256.New)
+	return fmt.Sprintf("$pbkdf2-sha256$i=%d$%s$%s", iterations, b64(testSalt), b64(key))
+}
+
+// scryptHash makes a scrypt hash of password in PHC string format
+func scryptHash(t *testing.T, password string, logN, r, p int) string {
+	key, err := scrypt.Key([]byte(password), testSalt, 1<<logN, r, p, 32)
+	if err != nil {
+		t.Fatalf("scrypt: %v", err)
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", logN, r, p, b64(testSalt), b64(key))
+}
+
+func newTestHasher(t *testing.T) *Hasher {
+	pepper, err := NewPepper(map[int][]byte{1: []byte("pepper-key-0123456789")})
+	if err != nil {
+		t.Fatalf("NewPepper: %v", err)
+	}
+	return NewHasher(pepper, NewBcrypt(4), NewArgon2id(64, 1, 1), NewPBKDF2(), NewScrypt(), NewSaltedSHA256())
+}
+
+func TestLegacyHashesVerify(t *testing.T) {
+	h := newTestHasher(t)
+	salted := sha256.Sum256(append(append([]byte{}, testSalt...), "secret"...))
+	for name, encoded := range map[string]string{
+		"pbkdf2":        pbkdf2Hash("secret", 1000),
+		"scrypt":        scryptHash(t, "secret", 10, 8, 1),
+		"salted sha256": fmt.Sprintf("$salted-sha256$pos=prefix$%s$%s", b64(testSalt), b64(salted[:])),
+	} {
+		if !h.Supports(encoded) {
+			t.Errorf("%s: Supports = false", name)
+		}
+		ok, rehash, err := h.Verify("secret", encoded)
+		if err != nil || !ok || !rehash {
+			t.Errorf("%s: Verify = %v, %v, %v; want a match that needs rehashing", name, ok, rehash, err)
+		}
+		if ok, _, _ := h.Verify("wrong", encoded); ok {
+			t.Errorf("%s: wrong password matched", name)
+		}
+	}
+}
+
+func TestSupportsRejectsMalformedHashes(t *testing.T) {
+	h := newTestHasher(t)
+	good := pbkdf2Hash("secret", 1000)
+	current, err := h.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !h.Supports(current) {
+		t.Errorf("Supports(%q) = false for a hash the hasher made", current)
+	}
+
+	for _, encoded := range []string{
+		"plaintext",
+		"$pbkdf2-sha256$i=1000$" + b64(testSalt), // missing key
+		"$pbkdf2-sha256$i=1000x$" + b64(testSalt) + "$" + b64(testSalt), // trailing garbage
+		"$pbkdf2-md5$i=1000$" + b64(testSalt) + "$" + b64(testSalt),
+		"$pbkdf2-sha256$i=0$" + b64(testSalt) + "$" + b64(testSalt),
+		"$pbkdf2-sha256$i=1000$not base64!$" + b64(testSalt),
+		"$scrypt$ln=10,r=0,p=1$" + b64(testSalt) + "$" + b64(testSalt),
+		"$scrypt$ln=10,r=8$" + b64(testSalt) + "$" + b64(testSalt),
+		"$salted-sha256$pos=middle$" + b64(testSalt) + "$" + b64(testSalt),
+		"$2a$04$short",
+		"$argon2id$v=19$m=64,t=0,p=1$" + b64(testSalt) + "$" + b64(testSalt),
+		"$pepper$v=2" + good, // unknown pepper version
+	} {
+		if h.Supports(encoded) {
+			t.Errorf("Supports(%q) = true", encoded)
+		}
+	}
+}
+
+func TestTooExpensiveHashesAreRejected(t *testing.T) {
+	h := newTestHasher(t)
+	key := b64(make([]byte, 32))
+	for _, encoded := range []string{
+		fmt.Sprintf("$pbkdf2-sha256$i=%d$%s$%s", maxPBKDF2Iterations+1, b64(testSalt), key),
+		"$pbkdf2-sha256$i=1000$" + b64(testSalt) + "$" + b64(make([]byte, 4096)), // one block per 32 bytes
+		"$scrypt$ln=20,r=8,p=1$" + b64(testSalt) + "$" + key,                     // 1 GiB
+		"$scrypt$ln=16,r=8,p=64$" + b64(testSalt) + "$" + key,
+		"$scrypt$ln=30,r=2147483647,p=1$" + b64(testSalt) + "$" + key,
+		"$scrypt$ln=99,r=8,p=1$" + b64(testSalt) + "$" + key,
+		"$argon2id$v=19$m=4194304,t=1,p=1$" + b64(testSalt) + "$" + key, // 4 GiB
+		"$2a$31$" + "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0",
+	} {
+		if h.Supports(encoded) {
+			t.Errorf("Supports(%q) = true", encoded)
+		}
+		// Verify refuses them too, rather than doing the work
+		if _, _, err := h.Verify("secret", encoded); !errors.Is(err, ErrHashTooExpensive) {
+			t.Errorf("Verify(%q): got error %v, want %v", encoded, err, ErrHashTooExpensive)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package password
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// errVerifyOnly is returned when a legacy algorithm is asked to hash a password
+var errVerifyOnly = errors.New("legacy password algorithm can only verify hashes")
+
+// Limits on the parameters of imported hashes. Verifying a hash costs what its
+// parameters say, so without them a single imported record could take minutes
+// or gigabytes to check at every sign-in. They are well above what the
+// systems these hashes come from use by default.
+const (
+	maxPBKDF2Iterations = 2_000_000
+	maxScryptMemory     = 128 << 20 // bytes, 128 * N * r
+	maxScryptWork       = 256 << 20 // bytes, 128 * N * r * p
+	maxLegacySalt       = 64        // bytes
+	maxLegacyKey        = 64        // bytes; PBKDF2 repeats the iterations per hash-sized block
+)
+
+// PBKDF2 verifies imported PBKDF2 hashes in PHC string format:
+// $pbkdf2-<sha1|sha256|sha512>$i=<iterations>$<salt>$<hash>
+type PBKDF2 struct{}
+
+// NewPBKDF2 creates a new instance of PBKDF2
+func NewPBKDF2() *PBKDF2 {
+	return &PBKDF2{}
+}
+
+// Matches reports whether encoded is a PBKDF2 hash
+func (p *PBKDF2) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$pbkdf2-")
+}
+
+// Hash is not supported; PBKDF2 hashes are only imported
+func (p *PBKDF2) Hash(password []byte) (string, error) {
+	return "", errVerifyOnly
+}
+
+// Verify checks password against a PBKDF2 hash
+func (p *PBKDF2) Verify(password []byte, encoded string) (bool, error) {
+	params, err := decodePBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+	derived := pbkdf2.Key(password, params.salt, params.iterations, len(params.key), params.digest)
+	return subtle.ConstantTimeCompare(derived, params.key) == 1, nil
+}
+
+// Check parses a PBKDF2 hash and checks its parameters
+func (p *PBKDF2) Check(encoded string) error {
+	_, err := decodePBKDF2(encoded)
+	return err
+}
+
+// pbkdf2Params holds the parameters decoded from a PBKDF2 hash
+type pbkdf2Params struct {
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// decodePBKDF2 parses a PBKDF2 hash in PHC string format
+func decodePBKDF2(encoded string) (pbkdf2Params, error) {
+	var params pbkdf2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" {
+		return params, ErrUnknownHashFormat
+	}
+	switch parts[1] {
+	case "pbkdf2-sha1":
+		params.digest = sha1.New
+	case "pbkdf2-sha256":
+		params.digest = sha256.New
+	case "pbkdf2-sha512":
+		params.digest = sha512.New
+	default:
+		return params, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "i=%d", &params.iterations); err != nil ||
+		params.iterations <= 0 || parts[2] != fmt.Sprintf("i=%d", params.iterations) {
+		return params, ErrUnknownHashFormat
+	}
+	if params.iterations > maxPBKDF2Iterations {
+		return params, ErrHashTooExpensive
+	}
+	var err error
+	if params.salt, params.key, err = decodeSaltAndKey(parts[3], parts[4]); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// NeedsRehash always reports true, since PBKDF2 is never the current algorithm
+func (p *PBKDF2) NeedsRehash(encoded string) bool {
+	return true
+}
+
+// Scrypt verifies imported scrypt hashes in PHC string format:
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>
+type Scrypt struct{}
+
+// NewScrypt creates a new instance of Scrypt
+func NewScrypt() *Scrypt {
+	return &Scrypt{}
+}
+
+// Matches reports whether encoded is a scrypt hash
+func (s *Scrypt) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+// Hash is not supported; scrypt hashes are only imported
+func (s *Scrypt) Hash(password []byte) (string, error) {
+	return "", errVerifyOnly
+}
+
+// Verify checks password against a scrypt hash
+func (s *Scrypt) Verify(password []byte, encoded string) (bool, error) {
+	params, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	derived, err := scrypt.Key(password, params.salt, 1<<params.logN, params.r, params.p, len(params.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(derived, params.key) == 1, nil
+}
+
+// Check parses a scrypt hash and checks its parameters
+func (s *Scrypt) Check(encoded string) error {
+	_, err := decodeScrypt(encoded)
+	return err
+}
+
+// scryptParams holds the parameters decoded from a scrypt hash
+type scryptParams struct {
+	logN, r, p int
+	salt       []byte
+	key        []byte
+}
+
+// decodeScrypt parses a scrypt hash in PHC string format
+func decodeScrypt(encoded string) (scryptParams, error) {
+	var params scryptParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "scrypt" {
+		return params, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.logN, &params.r, &params.p); err != nil ||
+		parts[2] != fmt.Sprintf("ln=%d,r=%d,p=%d", params.logN, params.r, params.p) ||
+		params.logN <= 0 || params.r <= 0 || params.p <= 0 {
+		return params, ErrUnknownHashFormat
+	}
+	// Divide instead of multiplying, so huge parameters can't overflow
+	if params.logN > 30 || params.r > maxScryptMemory/(128<<params.logN) ||
+		params.p > maxScryptWork/(128*params.r<<params.logN) {
+		return params, ErrHashTooExpensive
+	}
+	var err error
+	if params.salt, params.key, err = decodeSaltAndKey(parts[3], parts[4]); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+// NeedsRehash always reports true, since scrypt is never the current algorithm
+func (s *Scrypt) NeedsRehash(encoded string) bool {
+	return true
+}
+
+// SaltedSHA256 verifies imported single-round salted SHA-256 hashes:
+// $salted-sha256$pos=<prefix|suffix>$<salt>$<hash>, where pos says whether the
+// salt was hashed before or after the password
+type SaltedSHA256 struct{}
+
+// NewSaltedSHA256 creates a new instance of SaltedSHA256
+func NewSaltedSHA256() *SaltedSHA256 {
+	return &SaltedSHA256{}
+}
+
+// Matches reports whether encoded is a salted SHA-256 hash
+func (s *SaltedSHA256) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$salted-sha256$")
+}
+
+// Hash is not supported; salted SHA-256 hashes are only imported
+func (s *SaltedSHA256) Hash(password []byte) (string, error) {
+	return "", errVerifyOnly
+}
+
+// Verify checks password against a salted SHA-256 hash
+func (s *SaltedSHA256) Verify(password []byte, encoded string) (bool, error) {
+	prefix, salt, key, err := decodeSaltedSHA256(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	var input []byte
+	if prefix {
+		input = append(append(input, salt...), password...)
+	} else {
+		input = append(append(input, password...), salt...)
+	}
+	derived := sha256.Sum256(input)
+	return subtle.ConstantTimeCompare(derived[:], key) == 1, nil
+}
+
+// Check parses a salted SHA-256 hash
+func (s *SaltedSHA256) Check(encoded string) error {
+	_, _, _, err := decodeSaltedSHA256(encoded)
+	return err
+}
+
+// decodeSaltedSHA256 parses a salted SHA-256 hash, reporting whether the salt
+// goes before the password
+func decodeSaltedSHA256(encoded string) (bool, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "salted-sha256" {
+		return false, nil, nil, ErrUnknownHashFormat
+	}
+	var prefix bool
+	switch parts[2] {
+	case "pos=prefix":
+		prefix = true
+	case "pos=suffix":
+	default:
+		return false, nil, nil, ErrUnknownHashFormat
+	}
+	salt, key, err := decodeSaltAndKey(parts[3], parts[4])
+	if err != nil || len(key) != sha256.Size {
+		return false, nil, nil, ErrUnknownHashFormat
+	}
+	return prefix, salt, key, nil
+}
+
+// NeedsRehash always reports true, since salted SHA-256 is never the current algorithm
+func (s *SaltedSHA256) NeedsRehash(encoded string) bool {
+	return true
+}
+
+// decodeSaltAndKey decodes the base64 salt and key fields of a PHC string,
+// accepting padded and unpadded encodings
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+	salt, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encodedSalt, "="))
+	if err != nil {
+		return nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encodedKey, "="))
+	if err != nil || len(key) == 0 {
+		return nil, nil, ErrUnknownHashFormat
+	}
+	if len(salt) > maxLegacySalt || len(key) > maxLegacyKey {
+		return nil, nil, ErrHashTooExpensive
+	}
+	return salt, key, nil
+}
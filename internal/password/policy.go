@@ -0,0 +1,147 @@
+package password
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// bcryptMaxBytes is the length after which bcrypt silently ignores input
+const bcryptMaxBytes = 72
+
+// Rule names reported in policy violations
+const (
+	RuleMinLength     = "min_length"
+	RuleMaxLength     = "max_length"
+	RuleCharClasses   = "char_classes"
+	RuleEntropy       = "entropy"
+	RuleContainsEmail = "contains_email"
+	RuleBreached      = "breached"
+)
+
+// Policy describes the requirements for new passwords. Zero values disable a rule.
+type Policy struct {
+	MinLength      int     // in characters
+	MaxLength      int     // in bytes, at most 72
+	MinCharClasses int     // of lowercase, uppercase, digits and symbols
+	MinEntropyBits float64 // estimated from length and character classes
+	Breached       BreachedCorpus
+	History        int // recent passwords that can't be reused, enforced by the user store
+}
+
+// PolicyError lists every rule a password violates
+type PolicyError struct {
+	Violations []models.PasswordViolation
+}
+
+// Error implements the error interface
+func (e *PolicyError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		rules[i] = violation.Rule
+	}
+	return "password violates policy: " + strings.Join(rules, ", ")
+}
+
+// Validate checks password, chosen for the account with the given email,
+// against the policy. It returns a *PolicyError listing all violations.
+func (p Policy) Validate(password, email string) error {
+	var violations []models.PasswordViolation
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, models.PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := len([]rune(password))
+	if p.MinLength > 0 && length < p.MinLength {
+		add(RuleMinLength, "Password must be at least %d characters long", p.MinLength)
+	}
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > bcryptMaxBytes {
+		maxLength = bcryptMaxBytes
+	}
+	if len(password) > maxLength {
+		add(RuleMaxLength, "Password must be at most %d bytes long", maxLength)
+	}
+
+	classes, poolSize := characterClasses(password)
+	if p.MinCharClasses > 0 && classes < p.MinCharClasses {
+		add(RuleCharClasses, "Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses)
+	}
+	if p.MinEntropyBits > 0 && (poolSize == 0 || float64(length)*math.Log2(float64(poolSize)) < p.MinEntropyBits) {
+		add(RuleEntropy, "Password is too easy to guess; use a longer password or more kinds of characters")
+	}
+
+	if containsEmail(password, email) {
+		add(RuleContainsEmail, "Password must not contain your email address")
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			// Don't block sign-up when the corpus can't be read
+			log.Printf("password: breached corpus: %v", err)
+		} else if breached {
+			add(RuleBreached, "Password has appeared in a data breach; choose a different one")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// characterClasses returns how many ASCII character classes a password uses
+// and the size of the alphabet its characters are drawn from
+func characterClasses(password string) (int, int) {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes, poolSize := 0, 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}} {
+		if class.used {
+			classes++
+			poolSize += class.size
+		}
+	}
+	if other {
+		// Non-ASCII characters widen the alphabet but don't count as a class
+		poolSize += 100
+	}
+	return classes, poolSize
+}
+
+// containsEmail reports whether the password contains the email address or
+// its local part, ignoring case
+func containsEmail(password, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= 3 && strings.Contains(password, local)
+}
@@ -0,0 +1,197 @@
+package pii
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ciphertextPrefix starts every encrypted field: enc:v1:<data key ID>:<base64>
+const ciphertextPrefix = "enc:v1:"
+
+// ErrNoKeyring is returned by OpenKeyring when the keyring file doesn't exist
+var ErrNoKeyring = errors.New("pii: keyring not found")
+
+// keyringFile is the stored form of a Keyring, with its keys wrapped
+type keyringFile struct {
+	DataKeyID string     `json:"data_key_id"`
+	DataKey   WrappedKey `json:"data_key"`
+	IndexKey  WrappedKey `json:"index_key"`
+}
+
+// Keyring holds the data key that encrypts fields and the key that computes
+// blind indexes. Both are stored wrapped by the KMS in a file and unwrapped
+// when the keyring is opened. Rotating the key-encryption key only re-wraps
+// them, so records encrypted with the data key are left as they are.
+type Keyring struct {
+	path string
+	kms  KMS
+
+	dataKeyID string
+	dataKey   []byte
+	data      cipher.AEAD
+	indexKey  []byte
+}
+
+// OpenKeyring loads the keyring stored at path, unwrapping its keys with kms.
+// It fails with ErrNoKeyring if there is none; a keyring is only created by
+// CreateKeyring, so a mistyped path can't start encrypting with new keys.
+// Every replica sharing a store must use the same keyring.
+func OpenKeyring(ctx context.Context, path string, kms KMS) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoKeyring, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pii: %w", err)
+	}
+
+	var stored keyringFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("pii: keyring %s: %w", path, err)
+	}
+	keyring := &Keyring{path: path, kms: kms, dataKeyID: stored.DataKeyID}
+	if keyring.dataKey, err = kms.Unwrap(ctx, stored.DataKey); err != nil {
+		return nil, err
+	}
+	if keyring.indexKey, err = kms.Unwrap(ctx, stored.IndexKey); err != nil {
+		return nil, err
+	}
+	if keyring.data, err = newAEAD(keyring.dataKey); err != nil {
+		return nil, fmt.Errorf("pii: data key: %w", err)
+	}
+	return keyring, nil
+}
+
+// CreateKeyring generates a keyring with new random keys and stores it at
+// path, wrapped with kms. It fails if a keyring is already stored there.
+func CreateKeyring(ctx context.Context, path string, kms KMS) (*Keyring, error) {
+	id := make([]byte, 8)
+	keyring := &Keyring{path: path, kms: kms, dataKey: make([]byte, keySize), indexKey: make([]byte, keySize)}
+	for _, key := range [][]byte{id, keyring.dataKey, keyring.indexKey} {
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("pii: %w", err)
+		}
+	}
+	keyring.dataKeyID = hex.EncodeToString(id)
+
+	var err error
+	if keyring.data, err = newAEAD(keyring.dataKey); err != nil {
+		return nil, fmt.Errorf("pii: data key: %w", err)
+	}
+	if err := keyring.save(ctx, true); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Rewrap wraps the keys again with the KMS's current key-encryption key and
+// stores them. Replicas that already opened the keyring are unaffected.
+func (k *Keyring) Rewrap(ctx context.Context) error {
+	return k.save(ctx, false)
+}
+
+// save wraps the keys and atomically writes the keyring file. If create is
+// set, an existing file is left alone and reported as an error.
+func (k *Keyring) save(ctx context.Context, create bool) error {
+	wrappedData, err := k.kms.Wrap(ctx, k.dataKey)
+	if err != nil {
+		return err
+	}
+	wrappedIndex, err := k.kms.Wrap(ctx, k.indexKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keyringFile{
+		DataKeyID: k.dataKeyID,
+		DataKey:   wrappedData,
+		IndexKey:  wrappedIndex,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("pii: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("pii: save keyring: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("pii: save keyring: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("pii: save keyring: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("pii: save keyring: %w", err)
+	}
+
+	// A link fails if the file exists, so two processes can't both create it
+	if create {
+		err = os.Link(tmp.Name(), k.path)
+	} else {
+		err = os.Rename(tmp.Name(), k.path)
+	}
+	if err != nil {
+		return fmt.Errorf("pii: save keyring: %w", err)
+	}
+	return nil
+}
+
+// Encrypt encrypts a field value. The scope, such as the record's ID and the
+// field's name, must be given again to decrypt it, so a value can't be moved
+// to another record or field.
+func (k *Keyring) Encrypt(plaintext, scope string) (string, error) {
+	ciphertext, err := seal(k.data, []byte(plaintext), additionalData(k.dataKeyID, scope))
+	if err != nil {
+		return "", fmt.Errorf("pii: encrypt: %w", err)
+	}
+	return ciphertextPrefix + k.dataKeyID + ":" + base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a field value encrypted with the same scope
+func (k *Keyring) Decrypt(ciphertext, scope string) (string, error) {
+	rest, found := strings.CutPrefix(ciphertext, ciphertextPrefix)
+	keyID, encoded, ok := strings.Cut(rest, ":")
+	if !found || !ok {
+		return "", fmt.Errorf("pii: decrypt: not an encrypted value")
+	}
+	if keyID != k.dataKeyID {
+		return "", fmt.Errorf("pii: decrypt: unknown data key %q", keyID)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("pii: decrypt: %w", err)
+	}
+	plaintext, err := open(k.data, sealed, additionalData(keyID, scope))
+	if err != nil {
+		return "", fmt.Errorf("pii: decrypt: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// BlindIndex returns a keyed hash of value. Equal values give equal indexes,
+// so a record can be found by a value without storing it in plaintext, but
+// the value can't be recovered or guessed without the index key.
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// additionalData binds a ciphertext to its data key and scope
+func additionalData(keyID, scope string) []byte {
+	return []byte(keyID + ":" + scope)
+}
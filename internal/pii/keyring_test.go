@@ -0,0 +1,131 @@
+package pii
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// newTestKMS returns a FileKMS with a key-encryption key for each version,
+// filled with that version's number
+func newTestKMS(t *testing.T, versions ...int) *FileKMS {
+	keys := make(map[int][]byte, len(versions))
+	for _, version := range versions {
+		keys[version] = bytes.Repeat([]byte{byte(version)}, keySize)
+	}
+	kms, err := NewFileKMS(keys)
+	if err != nil {
+		t.Fatalf("NewFileKMS: %v", err)
+	}
+	return kms
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	kms := newTestKMS(t, 1)
+
+	// A keyring must be created before it can be opened, and only once
+	if _, err := OpenKeyring(ctx, path, kms); !errors.Is(err, ErrNoKeyring) {
+		t.Fatalf("OpenKeyring before CreateKeyring: got %v, want %v", err, ErrNoKeyring)
+	}
+	created, err := CreateKeyring(ctx, path, kms)
+	if err != nil {
+		t.Fatalf("CreateKeyring: %v", err)
+	}
+	if _, err := CreateKeyring(ctx, path, kms); err == nil {
+		t.Error("CreateKeyring replaced an existing keyring")
+	}
+
+	// A value encrypted with the created keyring decrypts with the opened one
+	ciphertext, err := created.Encrypt("alice@example.com", "user:1:email")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if bytes.Contains([]byte(ciphertext), []byte("alice")) {
+		t.Errorf("ciphertext %q contains the plaintext", ciphertext)
+	}
+	opened, err := OpenKeyring(ctx, path, kms)
+	if err != nil {
+		t.Fatalf("OpenKeyring: %v", err)
+	}
+	if plaintext, err := opened.Decrypt(ciphertext, "user:1:email"); err != nil || plaintext != "alice@example.com" {
+		t.Errorf("Decrypt: got %q, %v; want alice@example.com", plaintext, err)
+	}
+
+	// The scope binds the value to its record and field
+	if _, err := opened.Decrypt(ciphertext, "user:2:email"); err == nil {
+		t.Error("Decrypt with another scope succeeded")
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	keyring, err := CreateKeyring(ctx, path, newTestKMS(t, 1))
+	if err != nil {
+		t.Fatalf("CreateKeyring: %v", err)
+	}
+	ciphertext, err := keyring.Encrypt("alice@example.com", "user:1:email")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	index := keyring.BlindIndex("alice@example.com")
+
+	// Re-wrapped with version 2, the keyring no longer needs version 1, and
+	// values encrypted before still decrypt
+	rotated, err := OpenKeyring(ctx, path, newTestKMS(t, 1, 2))
+	if err != nil {
+		t.Fatalf("OpenKeyring: %v", err)
+	}
+	if err := rotated.Rewrap(ctx); err != nil {
+		t.Fatalf("Rewrap: %v", err)
+	}
+	reopened, err := OpenKeyring(ctx, path, newTestKMS(t, 2))
+	if err != nil {
+		t.Fatalf("OpenKeyring with only the new key: %v", err)
+	}
+	if plaintext, err := reopened.Decrypt(ciphertext, "user:1:email"); err != nil || plaintext != "alice@example.com" {
+		t.Errorf("Decrypt: got %q, %v; want alice@example.com", plaintext, err)
+	}
+	if got := reopened.BlindIndex("alice@example.com"); got != index {
+		t.Errorf("BlindIndex after rewrap: got %q, want %q", got, index)
+	}
+
+	// Without the key-encryption key it was wrapped with, it can't be opened
+	if _, err := OpenKeyring(ctx, path, newTestKMS(t, 1)); err == nil {
+		t.Error("OpenKeyring with a removed key-encryption key succeeded")
+	}
+}
+
+func TestKeyringBlindIndex(t *testing.T) {
+	ctx := context.Background()
+	kms := newTestKMS(t, 1)
+	keyring, err := CreateKeyring(ctx, filepath.Join(t.TempDir(), "keyring.json"), kms)
+	if err != nil {
+		t.Fatalf("CreateKeyring: %v", err)
+	}
+
+	// Equal values match, and neither reveals the value
+	index := keyring.BlindIndex("alice@example.com")
+	if index == "" || bytes.Contains([]byte(index), []byte("alice")) {
+		t.Errorf("BlindIndex: got %q, want a hash of the value", index)
+	}
+	if again := keyring.BlindIndex("alice@example.com"); again != index {
+		t.Errorf("BlindIndex of the same value: got %q and %q", index, again)
+	}
+	if other := keyring.BlindIndex("bob@example.com"); other == index {
+		t.Error("BlindIndex of different values matched")
+	}
+
+	// Another keyring's index key gives different indexes
+	other, err := CreateKeyring(ctx, filepath.Join(t.TempDir(), "keyring.json"), kms)
+	if err != nil {
+		t.Fatalf("CreateKeyring: %v", err)
+	}
+	if other.BlindIndex("alice@example.com") == index {
+		t.Error("BlindIndex matched across keyrings")
+	}
+}
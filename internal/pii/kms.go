@@ -0,0 +1,164 @@
+// Package pii encrypts personal data in stored user records. Fields are
+// encrypted with a data key held in a Keyring, which keeps it wrapped by a
+// key-encryption key from a KMS, and emails are given a keyed blind index so
+// records can still be matched by email without decrypting them.
+package pii
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// keySize is the size in bytes of every key: AES-256 and HMAC-SHA256
+const keySize = 32
+
+// WrappedKey is a data key encrypted by a KMS, with the ID of the
+// key-encryption key that encrypted it
+type WrappedKey struct {
+	KeyID      string `json:"kek_id"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// KMS wraps and unwraps data keys with key-encryption keys it holds. Wrap
+// uses the current key-encryption key; Unwrap accepts any it still has, so
+// data keys wrapped before a rotation stay usable until they're re-wrapped.
+type KMS interface {
+	Wrap(ctx context.Context, dataKey []byte) (WrappedKey, error)
+	Unwrap(ctx context.Context, wrapped WrappedKey) ([]byte, error)
+}
+
+// FileKMS implements KMS with versioned key-encryption keys read from a local
+// file. It is meant for development and tests; in production the keys should
+// live in a managed KMS.
+type FileKMS struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewFileKMS creates a new instance of FileKMS from version -> 32-byte key.
+// The highest version is current.
+func NewFileKMS(keys map[int][]byte) (*FileKMS, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("pii: no key-encryption keys")
+	}
+	kms := &FileKMS{keys: make(map[string]cipher.AEAD, len(keys))}
+	latest := 0
+	for version, key := range keys {
+		if version <= 0 {
+			return nil, fmt.Errorf("pii: key-encryption key version %d must be positive", version)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("pii: key-encryption key version %d: %w", version, err)
+		}
+		kms.keys[strconv.Itoa(version)] = aead
+		if version > latest {
+			latest = version
+		}
+	}
+	kms.current = strconv.Itoa(latest)
+	return kms, nil
+}
+
+// LoadFileKMS reads key-encryption keys from a file with one
+// "version:base64key" per line, like a pepper file. Lines starting with # are
+// ignored. To rotate, add a line with a higher version and re-wrap the
+// keyring; the old line can be removed once nothing is wrapped by it.
+func LoadFileKMS(path string) (*FileKMS, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[int][]byte)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		versionText, encodedKey, ok := strings.Cut(entry, ":")
+		version, err := strconv.Atoi(strings.TrimSpace(versionText))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s:%d: expected version:base64key", path, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid base64 key", path, line)
+		}
+		if _, exists := keys[version]; exists {
+			return nil, fmt.Errorf("%s:%d: duplicate key version %d", path, line, version)
+		}
+		keys[version] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewFileKMS(keys)
+}
+
+// CurrentKeyID returns the version of the key-encryption key Wrap uses
+func (k *FileKMS) CurrentKeyID() string {
+	return k.current
+}
+
+// Wrap encrypts a data key with the current key-encryption key
+func (k *FileKMS) Wrap(ctx context.Context, dataKey []byte) (WrappedKey, error) {
+	ciphertext, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	return WrappedKey{KeyID: k.current, Ciphertext: ciphertext}, nil
+}
+
+// Unwrap decrypts a data key wrapped by any key-encryption key in the file
+func (k *FileKMS) Unwrap(ctx context.Context, wrapped WrappedKey) ([]byte, error) {
+	aead, exists := k.keys[wrapped.KeyID]
+	if !exists {
+		return nil, fmt.Errorf("pii: unknown key-encryption key %q", wrapped.KeyID)
+	}
+	dataKey, err := open(aead, wrapped.Ciphertext, []byte(wrapped.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("pii: unwrap with key-encryption key %q: %w", wrapped.KeyID, err)
+	}
+	return dataKey, nil
+}
+
+// newAEAD returns AES-256-GCM keyed with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce, which it prepends
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts what seal produced
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
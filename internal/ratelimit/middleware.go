@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/pkg/utils"
+)
+
+// maxBodyBytes bounds how much of a request body is buffered to extract keys
+const maxBodyBytes = 1 << 20
+
+// KeyFunc extracts the value a request is limited by. It returns false when
+// the request carries no such value, in which case the rule doesn't apply.
+type KeyFunc func(r *http.Request, body []byte) (string, bool)
+
+// Rule limits requests sharing the same key according to a policy
+type Rule struct {
+	Policy models.RateLimitPolicy
+	Key    KeyFunc
+}
+
+// Middleware enforces rate-limit rules in front of handlers
+type Middleware struct {
+	store      store.RateLimitStore
+	trustProxy bool
+}
+
+// NewMiddleware creates a new instance of Middleware
+func NewMiddleware(rateLimitStore store.RateLimitStore, trustProxy bool) *Middleware {
+	return &Middleware{
+		store:      rateLimitStore,
+		trustProxy: trustProxy,
+	}
+}
+
+// Limit is a middleware that rejects requests exceeding any of the rules with
+// 429 Too Many Requests. Every response carries the RateLimit headers of the
+// most constrained rule.
+func (m *Middleware) Limit(rules []Rule, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Buffer the body so keys can be read from it and the handler still sees it
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+		if err != nil {
+			utils.SendErrorResponse(w, http.StatusBadRequest, models.ErrInvalidRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var tightest *models.RateLimitResult
+		for _, rule := range rules {
+			if rule.Policy.Limit <= 0 {
+				continue
+			}
+			key, ok := rule.Key(r, body)
+			if !ok {
+				continue
+			}
+
+			result, err := m.store.Take(r.Context(), rule.Policy.Name+":"+key, rule.Policy)
+			if err != nil {
+				// Fail open so an unavailable counter store doesn't take sign-in down
+				log.Printf("ratelimit: %s: %v", rule.Policy.Name, err)
+				continue
+			}
+			if tightest == nil || tighter(result, *tightest) {
+				tightest = &result
+			}
+		}
+
+		if tightest != nil {
+			setHeaders(w, *tightest)
+			if !tightest.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+				utils.SendErrorResponse(w, http.StatusTooManyRequests, models.ErrTooManyRequests)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// ByIP keys requests by client IP address
+func (m *Middleware) ByIP() KeyFunc {
+	return func(r *http.Request, _ []byte) (string, bool) {
+		if m.trustProxy {
+			// The last entry is the one appended by our own proxy
+			if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+				addresses := strings.Split(forwarded, ",")
+				if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+					return ip, true
+				}
+			}
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr, r.RemoteAddr != ""
+		}
+		return host, true
+	}
+}
+
+// ByEmail keys requests by the normalized email field of a JSON body, so
+// attempts against one account are limited however many addresses they come
+// from and however the email is spelled
+func ByEmail(emails *emailaddr.Normalizer) KeyFunc {
+	return func(_ *http.Request, body []byte) (string, bool) {
+		email := jsonField(body, "email")
+		if email == "" {
+			return "", false
+		}
+		return emails.Key(email), true
+	}
+}
+
+// ByRefreshToken keys requests by the refresh_token field of a JSON body.
+// Tokens are hashed so the counter store never holds usable credentials.
+func ByRefreshToken() KeyFunc {
+	return func(_ *http.Request, body []byte) (string, bool) {
+		token := jsonField(body, "refresh_token")
+		if token == "" {
+			return "", false
+		}
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:]), true
+	}
+}
+
+// jsonField returns a top-level string field of a JSON object, or "" if absent
+func jsonField(body []byte, name string) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	var value string
+	if err := json.Unmarshal(fields[name], &value); err != nil {
+		return ""
+	}
+	return value
+}
+
+// tighter reports whether a constrains the client more than b: a denial
+// outranks an allowance, then the longer wait or the fewer remaining requests
+func tighter(a, b models.RateLimitResult) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// setHeaders sets the RateLimit-* headers for a result
+func setHeaders(w http.ResponseWriter, result models.RateLimitResult) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
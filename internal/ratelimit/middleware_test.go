@@ -0,0 +1,249 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+func TestByIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"remote address", false, "203.0.113.7:52100", "", "203.0.113.7"},
+		{"IPv6 remote address", false, "[2001:db8::1]:52100", "", "2001:db8::1"},
+		{"remote address without port", false, "203.0.113.7", "", "203.0.113.7"},
+		{"forwarded header ignored without trusted proxy", false, "10.0.0.2:52100", "198.51.100.1", "10.0.0.2"},
+		{"last forwarded entry with trusted proxy", true, "10.0.0.2:52100", "192.0.2.66, 198.51.100.1", "198.51.100.1"},
+		{"remote address with trusted proxy and no header", true, "10.0.0.2:52100", "", "10.0.0.2"},
+		{"remote address with trusted proxy and empty entry", true, "10.0.0.2:52100", "198.51.100.1, ", "10.0.0.2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/auth/signin", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+
+			m := NewMiddleware(store.NewInMemoryRateLimitStore(), test.trustProxy)
+			if got, ok := m.ByIP()(r, nil); !ok || got != test.want {
+				t.Errorf("got %q, %v; want %q", got, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestByEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   string
+		wantOK bool
+	}{
+		{"email", `{"email": "alice@example.com", "password": "x"}`, "alice@example.com", true},
+		{"differently spelled email", `{"email": " Alice@Example.COM "}`, "alice@example.com", true},
+		{"provider rules", `{"email": "a.lice+tag@gmail.com"}`, "alice@gmail.com", true},
+		{"no email", `{"password": "x"}`, "", false},
+		{"email not a string", `{"email": 42}`, "", false},
+		{"not JSON", `email=alice@example.com`, "", false},
+	}
+	key := ByEmail(emailaddr.NewNormalizer(true))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, ok := key(nil, []byte(test.body)); ok != test.wantOK || got != test.want {
+				t.Errorf("got %q, %v; want %q, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestByRefreshToken(t *testing.T) {
+	key := ByRefreshToken()
+
+	first, ok := key(nil, []byte(`{"refresh_token": "token-1"}`))
+	if !ok || first == "" || strings.Contains(first, "token-1") {
+		t.Errorf("got %q, %v; want a hash of the token", first, ok)
+	}
+	if again, _ := key(nil, []byte(`{"refresh_token": "token-1"}`)); again != first {
+		t.Errorf("same token keyed as %q and %q", first, again)
+	}
+	if other, _ := key(nil, []byte(`{"refresh_token": "token-2"}`)); other == first {
+		t.Error("different tokens got the same key")
+	}
+	if got, ok := key(nil, []byte(`{}`)); ok {
+		t.Errorf("no token: got %q, want no key", got)
+	}
+}
+
+// scriptedStore returns a fixed result or error per policy name and records
+// the keys it was asked for
+type scriptedStore struct {
+	results map[string]models.RateLimitResult
+	err     error
+	keys    []string
+}
+
+func (s *scriptedStore) Take(ctx context.Context, key string, policy models.RateLimitPolicy) (models.RateLimitResult, error) {
+	s.keys = append(s.keys, key)
+	if s.err != nil {
+		return models.RateLimitResult{}, s.err
+	}
+	return s.results[policy.Name], nil
+}
+
+// fixedKey keys every request by the same value
+func fixedKey(value string) KeyFunc {
+	return func(*http.Request, []byte) (string, bool) { return value, true }
+}
+
+// noKey doesn't apply to any request
+func noKey(*http.Request, []byte) (string, bool) { return "", false }
+
+func TestLimit(t *testing.T) {
+	allowed := models.RateLimitResult{Allowed: true, Limit: 20, Remaining: 12, Reset: 30 * time.Second}
+	scarce := models.RateLimitResult{Allowed: true, Limit: 5, Remaining: 1, Reset: 48 * time.Second}
+	denied := models.RateLimitResult{Limit: 5, Reset: 59500 * time.Millisecond, RetryAfter: 11200 * time.Millisecond}
+	longer := models.RateLimitResult{Limit: 60, Reset: time.Minute, RetryAfter: 30 * time.Second}
+
+	tests := []struct {
+		name        string
+		rules       []Rule
+		err         error
+		wantStatus  int
+		wantHeaders map[string]string // "" means absent
+		wantKeys    []string
+	}{
+		{
+			name: "allowed",
+			rules: []Rule{
+				{Policy: models.RateLimitPolicy{Name: "ip", Limit: 20}, Key: fixedKey("203.0.113.7")},
+				{Policy: models.RateLimitPolicy{Name: "email", Limit: 5}, Key: fixedKey("alice@example.com")},
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"RateLimit-Limit": "5", "RateLimit-Remaining": "1", "RateLimit-Reset": "48", "Retry-After": ""},
+			wantKeys:    []string{"ip:203.0.113.7", "email:alice@example.com"},
+		},
+		{
+			name: "denied by one rule",
+			rules: []Rule{
+				{Policy: models.RateLimitPolicy{Name: "ip", Limit: 20}, Key: fixedKey("203.0.113.7")},
+				{Policy: models.RateLimitPolicy{Name: "denied", Limit: 5}, Key: fixedKey("alice@example.com")},
+			},
+			wantStatus:  http.StatusTooManyRequests,
+			wantHeaders: map[string]string{"RateLimit-Limit": "5", "RateLimit-Remaining": "0", "RateLimit-Reset": "60", "Retry-After": "12"},
+			wantKeys:    []string{"ip:203.0.113.7", "denied:alice@example.com"},
+		},
+		{
+			name: "longest wait when denied by several rules",
+			rules: []Rule{
+				{Policy: models.RateLimitPolicy{Name: "denied", Limit: 5}, Key: fixedKey("alice@example.com")},
+				{Policy: models.RateLimitPolicy{Name: "longer", Limit: 60}, Key: fixedKey("203.0.113.7")},
+			},
+			wantStatus:  http.StatusTooManyRequests,
+			wantHeaders: map[string]string{"RateLimit-Limit": "60", "Retry-After": "30"},
+		},
+		{
+			name: "disabled rules and rules without a key are skipped",
+			rules: []Rule{
+				{Policy: models.RateLimitPolicy{Name: "denied", Limit: 0}, Key: fixedKey("alice@example.com")},
+				{Policy: models.RateLimitPolicy{Name: "denied", Limit: 5}, Key: noKey},
+				{Policy: models.RateLimitPolicy{Name: "ip", Limit: 20}, Key: fixedKey("203.0.113.7")},
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"RateLimit-Limit": "20", "RateLimit-Remaining": "12"},
+			wantKeys:    []string{"ip:203.0.113.7"},
+		},
+		{
+			name: "no applicable rule",
+			rules: []Rule{
+				{Policy: models.RateLimitPolicy{Name: "denied", Limit: 5}, Key: noKey},
+			},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"RateLimit-Limit": "", "Retry-After": ""},
+		},
+		{
+			name: "unavailable store fails open",
+			rules: []Rule{
+				{Policy: models.RateLimitPolicy{Name: "denied", Limit: 5}, Key: fixedKey("alice@example.com")},
+			},
+			err:         errors.New("connection refused"),
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"RateLimit-Limit": "", "Retry-After": ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &scriptedStore{
+				results: map[string]models.RateLimitResult{"ip": allowed, "email": scarce, "denied": denied, "longer": longer},
+				err:     test.err,
+			}
+			const body = `{"email": "alice@example.com"}`
+			var received string
+			next := func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				received = string(b)
+			}
+
+			w := httptest.NewRecorder()
+			NewMiddleware(s, false).Limit(test.rules, next)(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+			if w.Code != test.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, test.wantStatus)
+			}
+			for name, want := range test.wantHeaders {
+				if got := w.Header().Get(name); got != want {
+					t.Errorf("%s %q, want %q", name, got, want)
+				}
+			}
+			if test.wantKeys != nil && strings.Join(s.keys, " ") != strings.Join(test.wantKeys, " ") {
+				t.Errorf("keys %v, want %v", s.keys, test.wantKeys)
+			}
+			// The handler still sees the body the keys were read from
+			if allowed := test.wantStatus == http.StatusOK; allowed != (received == body) {
+				t.Errorf("handler received %q, want it called with the body: %v", received, allowed)
+			}
+		})
+	}
+}
+
+func TestLimitRefills(t *testing.T) {
+	policy := models.RateLimitPolicy{Name: "ip", Limit: 2, Period: 200 * time.Millisecond}
+	m := NewMiddleware(store.NewInMemoryRateLimitStore(), false)
+	handler := m.Limit([]Rule{{Policy: policy, Key: m.ByIP()}}, func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		return w
+	}
+
+	// A burst up to the limit goes through, and the next request waits for a token
+	for i := 0; i < policy.Limit; i++ {
+		if w := send(); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+	w := send()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("request beyond limit: status %d, Retry-After %q; want %d, 1", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+
+	time.Sleep(policy.Period / time.Duration(policy.Limit))
+	if w := send(); w.Code != http.StatusOK {
+		t.Errorf("request after refill: status %d, want %d", w.Code, http.StatusOK)
+	}
+}
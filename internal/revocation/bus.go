@@ -0,0 +1,132 @@
+// Package revocation keeps every replica's view of revoked tokens, sessions
+// and users current. Revocations are published on a Bus, and each replica
+// answers revocation checks from a local Cache fed by it.
+package revocation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Kind says what an Event revokes
+type Kind string
+
+const (
+	// KindToken revokes a single access token
+	KindToken Kind = "token"
+	// KindSession revokes every token issued in a session, including those
+	// issued later by refreshing it
+	KindSession Kind = "session"
+	// KindUser revokes every token of a user's sessions started before Epoch
+	KindUser Kind = "user"
+)
+
+// Event is one revocation. It only needs to be remembered until Expires,
+// after which no token it could apply to is valid anyway.
+type Event struct {
+	Kind    Kind      `json:"kind"`
+	Subject string    `json:"subject"` // token hash, session ID or user ID
+	Epoch   time.Time `json:"epoch,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// key identifies the revoked subject, so repeated revocations of it can be
+// merged
+func (e Event) key() string {
+	return string(e.Kind) + ":" + e.Subject
+}
+
+// merge combines two revocations of the same subject
+func (e Event) merge(other Event) Event {
+	if other.Epoch.After(e.Epoch) {
+		e.Epoch = other.Epoch
+	}
+	if other.Expires.After(e.Expires) {
+		e.Expires = other.Expires
+	}
+	return e
+}
+
+// HashToken returns the subject used for a revoked token, so that tokens
+// themselves aren't broadcast
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Subscriber receives the revocations published on a Bus
+type Subscriber interface {
+	// Revoked is called with each published revocation
+	Revoked(event Event)
+	// Resync is called once subscribed, and again whenever revocations may
+	// have been missed, such as after reconnecting; the subscriber should
+	// reload them with Snapshot
+	Resync()
+}
+
+// Bus publishes revocations to every replica, and keeps the ones in effect
+// for replicas that join or reconnect
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	Snapshot(ctx context.Context) ([]Event, error)
+	Subscribe(subscriber Subscriber) (unsubscribe func())
+}
+
+// LocalBus implements Bus within a single process
+type LocalBus struct {
+	events      eventSet
+	subscribers map[int]Subscriber
+	nextID      int
+	mutex       sync.Mutex
+}
+
+// NewLocalBus creates a new instance of LocalBus
+func NewLocalBus() *LocalBus {
+	return &LocalBus{
+		events:      newEventSet(),
+		subscribers: make(map[int]Subscriber),
+	}
+}
+
+// Publish delivers a revocation to every subscriber before returning
+func (b *LocalBus) Publish(ctx context.Context, event Event) error {
+	b.mutex.Lock()
+	event = b.events.add(event, time.Now())
+
+	subscribers := make([]Subscriber, 0, len(b.subscribers))
+	for _, subscriber := range b.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	b.mutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.Revoked(event)
+	}
+	return nil
+}
+
+// Snapshot returns the revocations that haven't expired
+func (b *LocalBus) Snapshot(ctx context.Context) ([]Event, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.events.unexpired(time.Now()), nil
+}
+
+// Subscribe registers a subscriber and has it load the current revocations
+func (b *LocalBus) Subscribe(subscriber Subscriber) func() {
+	b.mutex.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = subscriber
+	b.mutex.Unlock()
+
+	subscriber.Resync()
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, id)
+	}
+}
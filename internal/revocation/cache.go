@@ -0,0 +1,191 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// ErrNotSynced is returned by checks made before a Cache has loaded the
+// revocations in effect
+var ErrNotSynced = errors.New("revocation: not synced")
+
+// resyncTimeout bounds each attempt to load the revocations in effect, and
+// resyncRetryDelay is the wait between failed attempts
+const (
+	resyncTimeout    = 5 * time.Second
+	resyncRetryDelay = time.Second
+)
+
+// Cache answers revocation checks from memory. It subscribes to a Bus for
+// new revocations and reloads all of them whenever the bus reports some may
+// have been missed. Revocations are only ever added, so a reload is merged
+// with what the cache already holds.
+type Cache struct {
+	bus        Bus
+	tokenTTL   time.Duration // how long a revoked token could still be valid
+	sessionTTL time.Duration // how long a session could still be refreshed
+
+	events      eventSet
+	synced      bool
+	eventsMutex sync.RWMutex
+
+	retrying    bool // a background resync is running
+	resyncMutex sync.Mutex
+	unsubscribe func()
+}
+
+// NewCache creates a new instance of Cache fed by bus. Revoked tokens are
+// remembered for tokenTTL, the access token lifetime, and revoked sessions
+// and users for sessionTTL, the refresh token lifetime.
+func NewCache(bus Bus, tokenTTL, sessionTTL time.Duration) *Cache {
+	c := &Cache{
+		bus:        bus,
+		tokenTTL:   tokenTTL,
+		sessionTTL: sessionTTL,
+		events:     newEventSet(),
+	}
+	c.unsubscribe = bus.Subscribe(c)
+	return c
+}
+
+// Close stops receiving revocations
+func (c *Cache) Close() {
+	c.unsubscribe()
+}
+
+// Revoked implements Subscriber
+func (c *Cache) Revoked(event Event) {
+	c.eventsMutex.Lock()
+	defer c.eventsMutex.Unlock()
+	c.events.add(event, time.Now())
+}
+
+// Resync implements Subscriber. The first attempt runs before returning;
+// if it fails, attempts continue in the background until one succeeds.
+func (c *Cache) Resync() {
+	if c.resync() == nil {
+		return
+	}
+
+	c.resyncMutex.Lock()
+	defer c.resyncMutex.Unlock()
+	if c.retrying {
+		return
+	}
+	c.retrying = true
+	go func() {
+		for {
+			time.Sleep(resyncRetryDelay)
+			if c.resync() == nil {
+				break
+			}
+		}
+		c.resyncMutex.Lock()
+		c.retrying = false
+		c.resyncMutex.Unlock()
+	}()
+}
+
+// resync loads the revocations in effect from the bus
+func (c *Cache) resync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+	defer cancel()
+	events, err := c.bus.Snapshot(ctx)
+	if err != nil {
+		log.Printf("revocation: resync: %v", err)
+		return err
+	}
+
+	c.eventsMutex.Lock()
+	defer c.eventsMutex.Unlock()
+	now := time.Now()
+	for _, event := range events {
+		c.events.add(event, now)
+	}
+	c.synced = true
+	return nil
+}
+
+// lookup returns the revocation of a subject, if any
+func (c *Cache) lookup(kind Kind, subject string) (Event, bool, error) {
+	c.eventsMutex.RLock()
+	defer c.eventsMutex.RUnlock()
+	if !c.synced {
+		return Event{}, false, ErrNotSynced
+	}
+	event, exists := c.events.lookup(kind, subject)
+	return event, exists, nil
+}
+
+// TokenRevoked checks if a single token has been revoked
+func (c *Cache) TokenRevoked(token string) (bool, error) {
+	_, revoked, err := c.lookup(KindToken, HashToken(token))
+	return revoked, err
+}
+
+// ClaimsRevoked checks if a token's session has been revoked, or its user's
+// sessions started before the token's was
+func (c *Cache) ClaimsRevoked(claims *models.Claims) (bool, error) {
+	if claims.SessionID != "" {
+		_, revoked, err := c.lookup(KindSession, claims.SessionID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	event, revoked, err := c.lookup(KindUser, claims.UserID)
+	if err != nil || !revoked {
+		return false, err
+	}
+	started := claims.IssuedAt
+	if claims.AuthTime != nil {
+		started = claims.AuthTime
+	}
+	return started == nil || started.Before(event.Epoch), nil
+}
+
+// RevokeToken revokes a single token on every replica
+func (c *Cache) RevokeToken(ctx context.Context, token string) error {
+	return c.publish(ctx, Event{
+		Kind:    KindToken,
+		Subject: HashToken(token),
+		Expires: time.Now().Add(c.tokenTTL),
+	})
+}
+
+// RevokeSession revokes every token of a session on every replica
+func (c *Cache) RevokeSession(ctx context.Context, sessionID string) error {
+	return c.publish(ctx, Event{
+		Kind:    KindSession,
+		Subject: sessionID,
+		Expires: time.Now().Add(c.sessionTTL),
+	})
+}
+
+// RevokeUser revokes every token of a user's sessions started so far on
+// every replica. Token times have a precision of one second, so sessions
+// started within the current second are kept.
+func (c *Cache) RevokeUser(ctx context.Context, userID string) error {
+	now := time.Now()
+	return c.publish(ctx, Event{
+		Kind:    KindUser,
+		Subject: userID,
+		Epoch:   now.Truncate(time.Second),
+		Expires: now.Add(c.sessionTTL),
+	})
+}
+
+// publish sends a revocation on the bus, and applies it here right away so
+// this replica doesn't depend on the bus delivering it back
+func (c *Cache) publish(ctx context.Context, event Event) error {
+	if err := c.bus.Publish(ctx, event); err != nil {
+		return err
+	}
+	c.Revoked(event)
+	return nil
+}
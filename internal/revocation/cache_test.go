@@ -0,0 +1,120 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+func TestEventSetForgetsExpiredEvents(t *testing.T) {
+	s := newEventSet()
+	now := time.Now()
+	s.add(Event{Kind: KindToken, Subject: "short", Expires: now.Add(time.Millisecond)}, now)
+	s.add(Event{Kind: KindToken, Subject: "extended", Expires: now.Add(time.Millisecond)}, now)
+	s.add(Event{Kind: KindToken, Subject: "extended", Expires: now.Add(time.Hour)}, now)
+
+	// The next event drops the expired one but not the extended one
+	later := now.Add(time.Second)
+	s.add(Event{Kind: KindToken, Subject: "other", Expires: later.Add(time.Hour)}, later)
+	if _, exists := s.lookup(KindToken, "short"); exists {
+		t.Error("expired event was kept")
+	}
+	if _, exists := s.lookup(KindToken, "extended"); !exists {
+		t.Error("extended event was dropped")
+	}
+	if len(s.expiries) != 2 {
+		t.Errorf("%d events queued for expiry, want 2", len(s.expiries))
+	}
+}
+
+// newClaims returns the claims of a token in a session started at authTime
+func newClaims(userID, sessionID string, authTime time.Time) *models.Claims {
+	return &models.Claims{UserID: userID, SessionID: sessionID, AuthTime: jwt.NewNumericDate(authTime)}
+}
+
+func TestStoreBusDeliversRevocations(t *testing.T) {
+	ctx := context.Background()
+	bus := NewStoreBus(store.NewInMemoryRevocationStore())
+	publisher := NewCache(bus, time.Minute, time.Hour)
+	defer publisher.Close()
+	subscriber := NewCache(bus, time.Minute, time.Hour)
+	defer subscriber.Close()
+
+	started := time.Now().Add(-time.Minute)
+	if err := publisher.RevokeSession(ctx, "session-1"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if revoked, err := subscriber.ClaimsRevoked(newClaims("user-1", "session-1", started)); err != nil || !revoked {
+		t.Errorf("ClaimsRevoked: got %v, %v; want true", revoked, err)
+	}
+
+	// An older epoch of a user's revocation published late doesn't replace
+	// a newer one
+	newer := Event{Kind: KindUser, Subject: "user-2", Epoch: started.Add(30 * time.Second), Expires: time.Now().Add(time.Hour)}
+	older := Event{Kind: KindUser, Subject: "user-2", Epoch: started.Add(-time.Minute), Expires: time.Now().Add(time.Hour)}
+	for _, event := range []Event{newer, older} {
+		if err := bus.Publish(ctx, event); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	joined := NewCache(bus, time.Minute, time.Hour)
+	defer joined.Close()
+	if revoked, err := joined.ClaimsRevoked(newClaims("user-2", "session-2", started)); err != nil || !revoked {
+		t.Errorf("ClaimsRevoked after resync: got %v, %v; want true", revoked, err)
+	}
+}
+
+func TestStoreBusKeepsRevocationsAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	hasher := password.NewHasher(nil, password.NewBcrypt(4))
+	emails := emailaddr.NewNormalizer(false)
+	open := func() *store.FileStore {
+		f, err := store.OpenFileStore(dir, hasher, emails, 0, time.Minute, nil)
+		if err != nil {
+			t.Fatalf("OpenFileStore: %v", err)
+		}
+		return f
+	}
+
+	f := open()
+	cache := NewCache(NewStoreBus(f.Revocations()), time.Minute, time.Hour)
+	started := time.Now().Add(-time.Minute)
+	if err := cache.RevokeSession(ctx, "session-1"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if err := cache.RevokeUser(ctx, "user-2"); err != nil {
+		t.Fatalf("RevokeUser: %v", err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	// Revoked after the snapshot, so replayed from the log
+	if err := cache.RevokeUser(ctx, "user-3"); err != nil {
+		t.Fatalf("RevokeUser: %v", err)
+	}
+	cache.Close()
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f = open()
+	defer f.Close()
+	cache = NewCache(NewStoreBus(f.Revocations()), time.Minute, time.Hour)
+	defer cache.Close()
+	for _, claims := range []*models.Claims{
+		newClaims("user-1", "session-1", started),
+		newClaims("user-2", "session-2", started),
+		newClaims("user-3", "session-3", started),
+	} {
+		if revoked, err := cache.ClaimsRevoked(claims); err != nil || !revoked {
+			t.Errorf("ClaimsRevoked(%s): got %v, %v; want true", claims.SessionID, revoked, err)
+		}
+	}
+}
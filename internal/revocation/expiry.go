@@ -0,0 +1,91 @@
+package revocation
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryEntry is an event key and the time the event was set to expire
+type expiryEntry struct {
+	key     string
+	expires time.Time
+}
+
+// expiryQueue is a min-heap of event keys by expiry, as in the store
+// package, so that expired events are dropped without scanning every event
+// while checks wait. An entry may be stale if the event was merged with a
+// later one since; remove must check the event's current expiry.
+type expiryQueue []expiryEntry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].expires.Before(q[j].expires) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiryEntry)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// add schedules key to be checked at expires
+func (q *expiryQueue) add(key string, expires time.Time) {
+	heap.Push(q, expiryEntry{key: key, expires: expires})
+}
+
+// expire pops every entry due by now and calls remove with its key
+func (q *expiryQueue) expire(now time.Time, remove func(key string)) {
+	for q.Len() > 0 && now.After((*q)[0].expires) {
+		remove(heap.Pop(q).(expiryEntry).key)
+	}
+}
+
+// eventSet is a set of revocations by key that forgets them once expired
+type eventSet struct {
+	events   map[string]Event // key -> revocation
+	expiries expiryQueue
+}
+
+func newEventSet() eventSet {
+	return eventSet{events: make(map[string]Event)}
+}
+
+// add records a revocation, merging it with an earlier one of the same
+// subject, and forgets those that have expired by now. It returns the
+// revocation as recorded.
+func (s *eventSet) add(event Event, now time.Time) Event {
+	s.expiries.expire(now, func(key string) {
+		if existing, exists := s.events[key]; exists && now.After(existing.Expires) {
+			delete(s.events, key)
+		}
+	})
+	key := event.key()
+	existing, exists := s.events[key]
+	if exists {
+		event = existing.merge(event)
+	}
+	s.events[key] = event
+
+	// A merge that didn't extend the expiry is queued already
+	if !exists || !existing.Expires.Equal(event.Expires) {
+		s.expiries.add(key, event.Expires)
+	}
+	return event
+}
+
+// lookup returns the revocation of a subject, if any
+func (s *eventSet) lookup(kind Kind, subject string) (Event, bool) {
+	event, exists := s.events[Event{Kind: kind, Subject: subject}.key()]
+	return event, exists
+}
+
+// unexpired returns the revocations that haven't expired by now
+func (s *eventSet) unexpired(now time.Time) []Event {
+	events := make([]Event, 0, len(s.events))
+	for _, event := range s.events {
+		if !now.After(event.Expires) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
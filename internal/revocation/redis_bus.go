@@ -0,0 +1,125 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRetryDelay is how long a RedisBus subscription waits after a failed
+// receive before trying again
+const redisRetryDelay = time.Second
+
+// RedisBus implements Bus with Redis pub/sub. Revocations are also kept in
+// a sorted set scored by expiry, from which replicas resync when they
+// subscribe or reconnect.
+type RedisBus struct {
+	client  redis.UniversalClient
+	channel string
+	key     string
+}
+
+// NewRedisBus creates a new instance of RedisBus. Keys and the channel are
+// namespaced with prefix, e.g. "auth:".
+func NewRedisBus(client redis.UniversalClient, prefix string) *RedisBus {
+	return &RedisBus{
+		client:  client,
+		channel: prefix + "revocations",
+		key:     prefix + "revocations:log",
+	}
+}
+
+// Publish records a revocation and sends it to every subscriber, dropping
+// expired records as it goes
+func (b *RedisBus) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("redis: encode revocation: %w", err)
+	}
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	_, err = b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, b.key, redis.Z{Score: float64(event.Expires.UnixMilli()), Member: string(payload)})
+		pipe.ZRemRangeByScore(ctx, b.key, "-inf", "("+now)
+		pipe.Publish(ctx, b.channel, payload)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis: publish revocation: %w", err)
+	}
+	return nil
+}
+
+// Snapshot returns the recorded revocations that haven't expired
+func (b *RedisBus) Snapshot(ctx context.Context) ([]Event, error) {
+	payloads, err := b.client.ZRangeByScore(ctx, b.key, &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: load revocations: %w", err)
+	}
+
+	events := make([]Event, 0, len(payloads))
+	for _, payload := range payloads {
+		var event Event
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("revocation: skipping malformed record: %v", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Subscribe starts delivering revocations to subscriber in the background.
+// The subscriber resyncs each time the subscription is (re)established.
+func (b *RedisBus) Subscribe(subscriber Subscriber) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			received, err := pubsub.Receive(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// The next receive reconnects and subscribes again
+				log.Printf("revocation: receive: %v", err)
+				select {
+				case <-time.After(redisRetryDelay):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			switch message := received.(type) {
+			case *redis.Subscription:
+				if message.Kind == "subscribe" {
+					subscriber.Resync()
+				}
+			case *redis.Message:
+				var event Event
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					log.Printf("revocation: skipping malformed message: %v", err)
+					continue
+				}
+				subscriber.Revoked(event)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		pubsub.Close()
+		<-done
+	}
+}
@@ -0,0 +1,84 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// StoreBus implements Bus on a store.RevocationStore, so revocations are
+// kept wherever the store keeps its data: a FileStore's survive restarts,
+// and a RaftStore's reach every node of the cluster.
+type StoreBus struct {
+	revocations store.RevocationStore
+}
+
+// NewStoreBus creates a new instance of StoreBus
+func NewStoreBus(revocations store.RevocationStore) *StoreBus {
+	return &StoreBus{revocations: revocations}
+}
+
+// storeKey is the key a revocation is kept under. The store replaces
+// records rather than merging them, so each epoch of a user's revocation is
+// kept separately; otherwise an older epoch applied late would replace a
+// newer one.
+func storeKey(event Event) string {
+	if event.Kind == KindUser {
+		return event.key() + ":" + strconv.FormatInt(event.Epoch.Unix(), 10)
+	}
+	return event.key()
+}
+
+// Publish records a revocation in the store, which tells every subscriber
+func (b *StoreBus) Publish(ctx context.Context, event Event) error {
+	record, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("revocation: encode: %w", err)
+	}
+	return b.revocations.PutRevocation(ctx, storeKey(event), record, event.Expires)
+}
+
+// Snapshot returns the recorded revocations that haven't expired
+func (b *StoreBus) Snapshot(ctx context.Context) ([]Event, error) {
+	records, err := b.revocations.Revocations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(records))
+	for _, record := range records {
+		if event, ok := decodeRecord(record); ok {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Subscribe delivers each revocation the store records to subscriber, and
+// has it resync whenever the store's records are replaced. The store calls
+// back while applying changes, so the resync runs in the background: it
+// reads from the store, which may wait for those changes to finish.
+func (b *StoreBus) Subscribe(subscriber Subscriber) func() {
+	unwatch := b.revocations.WatchRevocations(func(record []byte) {
+		if event, ok := decodeRecord(record); ok {
+			subscriber.Revoked(event)
+		}
+	}, func() {
+		go subscriber.Resync()
+	})
+	subscriber.Resync()
+	return unwatch
+}
+
+// decodeRecord decodes a revocation kept by the store
+func decodeRecord(record []byte) (Event, bool) {
+	var event Event
+	if err := json.Unmarshal(record, &event); err != nil {
+		log.Printf("revocation: skipping malformed record: %v", err)
+		return Event{}, false
+	}
+	return event, true
+}
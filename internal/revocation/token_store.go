@@ -0,0 +1,37 @@
+package revocation
+
+import (
+	"context"
+
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// TokenStore wraps a store.TokenStore so that revocations are also published
+// on the cache's bus, and revocation checks are answered by the cache instead
+// of the store. Tokens revoked in the store before it was wrapped aren't
+// known to the cache.
+type TokenStore struct {
+	store.TokenStore
+	cache *Cache
+}
+
+// NewTokenStore creates a new instance of TokenStore
+func NewTokenStore(tokens store.TokenStore, cache *Cache) *TokenStore {
+	return &TokenStore{
+		TokenStore: tokens,
+		cache:      cache,
+	}
+}
+
+// IsTokenRevoked checks if a token has been revoked
+func (s *TokenStore) IsTokenRevoked(ctx context.Context, token string) (bool, error) {
+	return s.cache.TokenRevoked(token)
+}
+
+// RevokeToken revokes a token in the store and on every replica
+func (s *TokenStore) RevokeToken(ctx context.Context, token string) error {
+	if err := s.TokenStore.RevokeToken(ctx, token); err != nil {
+		return err
+	}
+	return s.cache.RevokeToken(ctx, token)
+}
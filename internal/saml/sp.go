@@ -0,0 +1,321 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// Errors returned when a SAML response is rejected
+var (
+	ErrMalformedResponse = errors.New("malformed SAML response")
+	ErrInvalidSignature  = errors.New("SAML response is not signed by a trusted IdP certificate")
+	ErrUnsuccessful      = errors.New("IdP reported an unsuccessful status")
+	ErrInvalidAssertion  = errors.New("SAML assertion failed validation")
+	ErrReplayedAssertion = errors.New("SAML assertion has already been used")
+)
+
+// Config holds the settings for this service provider and its trusted IdP
+type Config struct {
+	EntityID        string
+	ACSURL          string
+	IdPEntityID     string
+	IdPCertificates []*x509.Certificate
+	EmailAttribute  string // attribute holding the email; the NameID is used when empty
+	RoleAttribute   string // attribute whose values become roles; optional
+	ClockSkew       time.Duration
+}
+
+// Identity is the verified result of a SAML login
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Roles   []string // nil when no role attribute is configured
+}
+
+// ServiceProvider validates SAML 2.0 responses delivered to the assertion
+// consumer service using the HTTP-POST binding
+type ServiceProvider struct {
+	config      Config
+	replayStore store.ReplayStore
+}
+
+// NewServiceProvider creates a new instance of ServiceProvider. The IdP's
+// entity ID is required, since any assertion signed by a trusted certificate
+// would otherwise be accepted whatever its issuer.
+func NewServiceProvider(config Config, replayStore store.ReplayStore) (*ServiceProvider, error) {
+	if config.EntityID == "" || config.ACSURL == "" {
+		return nil, errors.New("SAML entity ID and ACS URL are required")
+	}
+	if config.IdPEntityID == "" {
+		return nil, errors.New("SAML IdP entity ID is required")
+	}
+	if len(config.IdPCertificates) == 0 {
+		return nil, errors.New("SAML IdP certificate is required")
+	}
+	if config.ClockSkew == 0 {
+		config.ClockSkew = 2 * time.Minute
+	}
+	return &ServiceProvider{
+		config:      config,
+		replayStore: replayStore,
+	}, nil
+}
+
+// Metadata returns the SP metadata document to register with the IdP
+func (sp *ServiceProvider) Metadata() ([]byte, error) {
+	metadata := entityDescriptor{
+		EntityID: sp.config.EntityID,
+		SPSSODescriptor: spSSODescriptor{
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: nsProtocol,
+			NameIDFormats:              []string{nameIDFormatEmail},
+			AssertionConsumerServices: []assertionConsumerService{{
+				Binding:   bindingHTTPPost,
+				Location:  sp.config.ACSURL,
+				Index:     0,
+				IsDefault: true,
+			}},
+		},
+	}
+
+	data, err := xml.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ParseResponse verifies a base64-encoded SAMLResponse and returns the identity it asserts
+func (sp *ServiceProvider) ParseResponse(encoded string) (Identity, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Identity{}, ErrMalformedResponse
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil || doc.Root() == nil {
+		return Identity{}, ErrMalformedResponse
+	}
+
+	// Verify the signature, continuing only with the signed content
+	responseEl, assertionEl, err := sp.verify(doc.Root())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var resp response
+	if err := etreeutils.NSUnmarshalElement(etreeutils.NewDefaultNSContext(), responseEl, &resp); err != nil {
+		return Identity{}, ErrMalformedResponse
+	}
+	var a assertion
+	if err := etreeutils.NSUnmarshalElement(etreeutils.NewDefaultNSContext(), assertionEl, &a); err != nil {
+		return Identity{}, ErrMalformedResponse
+	}
+
+	// Check the response envelope
+	if resp.Status.StatusCode.Value != statusSuccess {
+		return Identity{}, ErrUnsuccessful
+	}
+	if resp.Destination != "" && resp.Destination != sp.config.ACSURL {
+		return Identity{}, fmt.Errorf("%w: destination %q", ErrInvalidAssertion, resp.Destination)
+	}
+
+	// Check the assertion and remember it until it expires
+	expiresAt, err := sp.validateAssertion(&a, time.Now())
+	if err != nil {
+		return Identity{}, err
+	}
+	if !sp.replayStore.MarkUsed("saml:"+a.ID, expiresAt.Add(sp.config.ClockSkew)) {
+		return Identity{}, ErrReplayedAssertion
+	}
+
+	return sp.mapIdentity(&a)
+}
+
+// verify validates either the Response signature or the Assertion signature
+// and returns the verified response and assertion elements. Elements outside
+// the verified signature are never used, which prevents signature wrapping.
+func (sp *ServiceProvider) verify(root *etree.Element) (*etree.Element, *etree.Element, error) {
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: sp.config.IdPCertificates,
+	})
+
+	rootContext := etreeutils.NewDefaultNSContext()
+	if hasSignature(rootContext, root) {
+		response, err := validationContext.Validate(root)
+		if err != nil {
+			return nil, nil, ErrInvalidSignature
+		}
+		assertionEl, err := singleAssertion(response)
+		if err != nil {
+			return nil, nil, err
+		}
+		return response, assertionEl, nil
+	}
+
+	// Unsigned response; the assertion itself must be signed
+	assertionEl, err := singleAssertion(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	assertionEl, err = validationContext.Validate(assertionEl)
+	if err != nil {
+		return nil, nil, ErrInvalidSignature
+	}
+	return root, assertionEl, nil
+}
+
+// validateAssertion checks the issuer, subject confirmation, conditions and
+// audience of an assertion and returns the time it stops being valid
+func (sp *ServiceProvider) validateAssertion(a *assertion, now time.Time) (time.Time, error) {
+	skew := sp.config.ClockSkew
+	if a.ID == "" {
+		return time.Time{}, fmt.Errorf("%w: missing ID", ErrInvalidAssertion)
+	}
+	if a.Issuer != sp.config.IdPEntityID {
+		return time.Time{}, fmt.Errorf("%w: issuer %q", ErrInvalidAssertion, a.Issuer)
+	}
+
+	// A bearer confirmation must be addressed to our ACS and still be valid
+	var expiresAt time.Time
+	for _, confirmation := range a.Subject.SubjectConfirmations {
+		data := confirmation.Data
+		if confirmation.Method != confirmationBearer || data.Recipient != sp.config.ACSURL {
+			continue
+		}
+		if data.NotOnOrAfter.IsZero() || !now.Before(data.NotOnOrAfter.Add(skew)) {
+			continue
+		}
+		if !data.NotBefore.IsZero() && now.Add(skew).Before(data.NotBefore) {
+			continue
+		}
+		expiresAt = data.NotOnOrAfter
+		break
+	}
+	if expiresAt.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: no valid bearer subject confirmation", ErrInvalidAssertion)
+	}
+
+	// Time conditions and audience restrictions. An assertion must be
+	// restricted to this SP, or one issued to another SP of the same IdP
+	// could be replayed here.
+	c := a.Conditions
+	if c == nil || len(c.AudienceRestrictions) == 0 {
+		return time.Time{}, fmt.Errorf("%w: no audience restriction", ErrInvalidAssertion)
+	}
+	if !c.NotBefore.IsZero() && now.Add(skew).Before(c.NotBefore) {
+		return time.Time{}, fmt.Errorf("%w: not yet valid", ErrInvalidAssertion)
+	}
+	if !c.NotOnOrAfter.IsZero() {
+		if !now.Before(c.NotOnOrAfter.Add(skew)) {
+			return time.Time{}, fmt.Errorf("%w: expired", ErrInvalidAssertion)
+		}
+		if c.NotOnOrAfter.Before(expiresAt) {
+			expiresAt = c.NotOnOrAfter
+		}
+	}
+	for _, restriction := range c.AudienceRestrictions {
+		if !contains(restriction.Audiences, sp.config.EntityID) {
+			return time.Time{}, fmt.Errorf("%w: audience", ErrInvalidAssertion)
+		}
+	}
+
+	return expiresAt, nil
+}
+
+// mapIdentity maps the assertion subject and attributes onto an Identity
+func (sp *ServiceProvider) mapIdentity(a *assertion) (Identity, error) {
+	attributes := make(map[string][]string)
+	for _, attribute := range a.Attributes {
+		attributes[attribute.Name] = append(attributes[attribute.Name], attribute.Values...)
+	}
+
+	identity := Identity{
+		Issuer:  a.Issuer,
+		Subject: a.Subject.NameID.Value,
+	}
+	if sp.config.EmailAttribute != "" {
+		if values := attributes[sp.config.EmailAttribute]; len(values) > 0 {
+			identity.Email = values[0]
+		}
+	} else if a.Subject.NameID.Format == nameIDFormatEmail {
+		identity.Email = a.Subject.NameID.Value
+	}
+	if sp.config.RoleAttribute != "" {
+		identity.Roles = append([]string{}, attributes[sp.config.RoleAttribute]...)
+	}
+
+	if identity.Subject == "" || identity.Email == "" {
+		return Identity{}, fmt.Errorf("%w: missing subject or email", ErrInvalidAssertion)
+	}
+	return identity, nil
+}
+
+// hasSignature reports whether el has an enveloped ds:Signature child
+func hasSignature(ctx etreeutils.NSContext, el *etree.Element) bool {
+	signature, err := etreeutils.NSFindOneChildCtx(ctx, el, dsig.Namespace, dsig.SignatureTag)
+	return err == nil && signature != nil
+}
+
+// singleAssertion returns the only Assertion child of a response, detached
+// with its namespace declarations. Encrypted or multiple assertions are rejected.
+func singleAssertion(responseEl *etree.Element) (*etree.Element, error) {
+	var found []*etree.Element
+	err := etreeutils.NSFindChildrenIterateCtx(etreeutils.NewDefaultNSContext(), responseEl, nsAssertion, "Assertion",
+		func(ctx etreeutils.NSContext, el *etree.Element) error {
+			detached, err := etreeutils.NSDetatch(ctx, el)
+			if err != nil {
+				return err
+			}
+			found = append(found, detached)
+			return nil
+		})
+	if err != nil || len(found) != 1 {
+		return nil, ErrMalformedResponse
+	}
+	return found[0], nil
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCertificates parses one or more PEM-encoded X.509 certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certificates, nil
+}
@@ -0,0 +1,265 @@
+package saml
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+const (
+	testEntityID    = "https://sp.example.com/saml/metadata"
+	testACSURL      = "https://sp.example.com/saml/acs"
+	testIdPEntityID = "https://idp.example.com"
+)
+
+var fixtures = template.Must(template.ParseFiles("testdata/assertion.xml", "testdata/response.xml"))
+
+// fixture holds the values filled into the testdata templates
+type fixture struct {
+	ID           string
+	IssuedAt     string
+	Issuer       string
+	NotBefore    string
+	NotOnOrAfter string
+	Recipient    string
+	Audiences    []string
+}
+
+// newFixture returns the values of an assertion this SP should accept
+func newFixture(id string) fixture {
+	now := time.Now().UTC()
+	return fixture{
+		ID:           id,
+		IssuedAt:     now.Format(time.RFC3339),
+		Issuer:       testIdPEntityID,
+		NotBefore:    now.Add(-time.Minute).Format(time.RFC3339),
+		NotOnOrAfter: now.Add(5 * time.Minute).Format(time.RFC3339),
+		Recipient:    testACSURL,
+		Audiences:    []string{testEntityID},
+	}
+}
+
+// testIdP signs fixture responses with a locally generated certificate
+type testIdP struct {
+	t           *testing.T
+	certificate *x509.Certificate
+	signer      *dsig.SigningContext
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	// Sign with exclusive canonicalization like real IdPs, so an assertion
+	// signed on its own still verifies once it is inside a response
+	keyPair := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	signer := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(keyPair))
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	return &testIdP{
+		t:           t,
+		certificate: certificate,
+		signer:      signer,
+	}
+}
+
+// render fills a testdata template and parses the result
+func (idp *testIdP) render(name string, f fixture) *etree.Element {
+	var buf bytes.Buffer
+	if err := fixtures.ExecuteTemplate(&buf, name, f); err != nil {
+		idp.t.Fatalf("render %s: %v", name, err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(buf.Bytes()); err != nil {
+		idp.t.Fatalf("parse %s: %v", name, err)
+	}
+	return doc.Root()
+}
+
+// sign returns el with an enveloped signature
+func (idp *testIdP) sign(el *etree.Element) *etree.Element {
+	signed, err := idp.signer.SignEnveloped(el)
+	if err != nil {
+		idp.t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+// response returns a base64-encoded response for the fixture with a signed
+// assertion, after letting tamper change the signed assertion
+func (idp *testIdP) response(f fixture, tamper func(assertion *etree.Element)) string {
+	assertion := idp.sign(idp.render("assertion.xml", f))
+	if tamper != nil {
+		tamper(assertion)
+	}
+	response := idp.render("response.xml", f)
+	response.AddChild(assertion)
+	return idp.encode(response)
+}
+
+// signedResponse returns a base64-encoded response for the fixture signed as
+// a whole, with an unsigned assertion
+func (idp *testIdP) signedResponse(f fixture) string {
+	response := idp.render("response.xml", f)
+	response.AddChild(idp.render("assertion.xml", f))
+	return idp.encode(idp.sign(response))
+}
+
+func (idp *testIdP) encode(el *etree.Element) string {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		idp.t.Fatalf("serialize: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func newTestServiceProvider(t *testing.T, certificates ...*x509.Certificate) *ServiceProvider {
+	sp, err := NewServiceProvider(Config{
+		EntityID:        testEntityID,
+		ACSURL:          testACSURL,
+		IdPEntityID:     testIdPEntityID,
+		IdPCertificates: certificates,
+		RoleAttribute:   "groups",
+	}, store.NewInMemoryReplayStore())
+	if err != nil {
+		t.Fatalf("NewServiceProvider: %v", err)
+	}
+	return sp
+}
+
+func TestParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp.certificate)
+
+	for name, encoded := range map[string]string{
+		"signed assertion": idp.response(newFixture("assertion-1"), nil),
+		"signed response":  idp.signedResponse(newFixture("assertion-2")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			identity, err := sp.ParseResponse(encoded)
+			if err != nil {
+				t.Fatalf("ParseResponse: %v", err)
+			}
+			if identity.Issuer != testIdPEntityID || identity.Subject != "alice@example.com" || identity.Email != "alice@example.com" {
+				t.Errorf("got identity %+v", identity)
+			}
+			if got := strings.Join(identity.Roles, ","); got != "admin,auditor" {
+				t.Errorf("roles %q, want admin,auditor", got)
+			}
+		})
+	}
+}
+
+func TestParseResponseRejectsReplay(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp.certificate)
+	encoded := idp.response(newFixture("assertion-1"), nil)
+
+	if _, err := sp.ParseResponse(encoded); err != nil {
+		t.Fatalf("ParseResponse: %v", err)
+	}
+	if _, err := sp.ParseResponse(encoded); !errors.Is(err, ErrReplayedAssertion) {
+		t.Errorf("replayed response: got error %v, want %v", err, ErrReplayedAssertion)
+	}
+}
+
+func TestParseResponseRejectsInvalidAssertions(t *testing.T) {
+	idp := newTestIdP(t)
+	tests := []struct {
+		name   string
+		adjust func(f *fixture)
+	}{
+		{"other issuer", func(f *fixture) { f.Issuer = "https://attacker.example.com" }},
+		{"no audience restriction", func(f *fixture) { f.Audiences = nil }},
+		{"other audience", func(f *fixture) { f.Audiences = []string{"https://other-sp.example.com"} }},
+		{"one of several restrictions fails", func(f *fixture) {
+			f.Audiences = []string{testEntityID, "https://other-sp.example.com"}
+		}},
+		{"other recipient", func(f *fixture) { f.Recipient = "https://other-sp.example.com/saml/acs" }},
+		{"expired", func(f *fixture) {
+			f.NotBefore = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			f.NotOnOrAfter = time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339)
+		}},
+		{"not yet valid", func(f *fixture) { f.NotBefore = time.Now().Add(10 * time.Minute).UTC().Format(time.RFC3339) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sp := newTestServiceProvider(t, idp.certificate)
+			f := newFixture("assertion-1")
+			test.adjust(&f)
+			if _, err := sp.ParseResponse(idp.response(f, nil)); !errors.Is(err, ErrInvalidAssertion) {
+				t.Errorf("got error %v, want %v", err, ErrInvalidAssertion)
+			}
+		})
+	}
+}
+
+func TestParseResponseRejectsInvalidSignatures(t *testing.T) {
+	idp := newTestIdP(t)
+	sp := newTestServiceProvider(t, idp.certificate)
+
+	// Signed by a certificate the SP doesn't trust
+	other := newTestIdP(t)
+	if _, err := sp.ParseResponse(other.response(newFixture("assertion-1"), nil)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("untrusted certificate: got error %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// Changed after signing
+	tampered := idp.response(newFixture("assertion-2"), func(assertion *etree.Element) {
+		nameID := assertion.FindElement("./Subject/NameID")
+		nameID.SetText("mallory@example.com")
+	})
+	if _, err := sp.ParseResponse(tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered assertion: got error %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// Not signed at all
+	response := idp.render("response.xml", newFixture("assertion-3"))
+	response.AddChild(idp.render("assertion.xml", newFixture("assertion-3")))
+	if _, err := sp.ParseResponse(idp.encode(response)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("unsigned response: got error %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestNewServiceProviderRequiresIdPEntityID(t *testing.T) {
+	idp := newTestIdP(t)
+	_, err := NewServiceProvider(Config{
+		EntityID:        testEntityID,
+		ACSURL:          testACSURL,
+		IdPCertificates: []*x509.Certificate{idp.certificate},
+	}, store.NewInMemoryReplayStore())
+	if err == nil {
+		t.Error("NewServiceProvider without an IdP entity ID succeeded")
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/cache"
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// CachedUserStore wraps a UserStore, caching users looked up by ID. Entries
+// are dropped when the user is changed through this store; changes made
+// elsewhere, such as on another replica, show after at most the TTL.
+type CachedUserStore struct {
+	UserStore
+	users *cache.LRU[string, models.User]
+
+	// Lookups in flight by user ID, so that one racing with a change to the
+	// user doesn't cache the user as it was before
+	fills     map[string]*pendingFill
+	fillMutex sync.Mutex
+}
+
+// pendingFill tracks the lookups of one user that may fill the cache
+type pendingFill struct {
+	lookups    int    // lookups in flight
+	generation uint64 // changes to the user since the first of them started
+	password   string // hash a sign-in found meanwhile, if any
+}
+
+// NewCachedUserStore creates a new instance of CachedUserStore holding up to
+// capacity users for at most ttl
+func NewCachedUserStore(users UserStore, capacity int, ttl time.Duration) *CachedUserStore {
+	return &CachedUserStore{
+		UserStore: users,
+		users:     cache.NewLRU[string, models.User](capacity, ttl),
+		fills:     make(map[string]*pendingFill),
+	}
+}
+
+// Stats returns the cache's hit and miss counts
+func (s *CachedUserStore) Stats() cache.Stats {
+	return s.users.Stats()
+}
+
+// GetByID retrieves a user by ID, from the cache if possible
+func (s *CachedUserStore) GetByID(ctx context.Context, id string) (models.User, error) {
+	if user, ok := s.users.Get(id); ok {
+		return user, nil
+	}
+
+	s.fillMutex.Lock()
+	fill, exists := s.fills[id]
+	if !exists {
+		fill = &pendingFill{}
+		s.fills[id] = fill
+	}
+	fill.lookups++
+	generation := fill.generation
+	s.fillMutex.Unlock()
+
+	user, err := s.UserStore.GetByID(ctx, id)
+
+	s.fillMutex.Lock()
+	upgraded := fill.password != "" && fill.password != user.Password
+	if err == nil && fill.generation == generation && !upgraded {
+		s.users.Add(id, user)
+	}
+	if fill.lookups--; fill.lookups == 0 {
+		delete(s.fills, id)
+	}
+	s.fillMutex.Unlock()
+	return user, err
+}
+
+// Update replaces an existing user's stored record
+func (s *CachedUserStore) Update(ctx context.Context, user models.User) error {
+	defer s.invalidate(user.ID)
+	return s.UserStore.Update(ctx, user)
+}
+
+// Import adds a user whose password was hashed elsewhere
+func (s *CachedUserStore) Import(ctx context.Context, user models.User) (models.User, error) {
+	imported, err := s.UserStore.Import(ctx, user)
+	s.invalidate(imported.ID)
+	return imported, err
+}
+
+// SetPassword replaces a user's password
+func (s *CachedUserStore) SetPassword(ctx context.Context, userID, password string, historySize int) error {
+	defer s.invalidate(userID)
+	return s.UserStore.SetPassword(ctx, userID, password, historySize)
+}
+
+// SetManagedRoles replaces the roles a user is granted by source
+func (s *CachedUserStore) SetManagedRoles(ctx context.Context, userID, source string, roles []string) (models.User, error) {
+	defer s.invalidate(userID)
+	return s.UserStore.SetManagedRoles(ctx, userID, source, roles)
+}
+
+// Authenticate verifies user credentials, which may upgrade the user's
+// password hash. The user is only dropped from the cache if the cached copy
+// has an older hash, and a lookup in flight only skips filling the cache if
+// it read one.
+func (s *CachedUserStore) Authenticate(ctx context.Context, email, password string) (models.User, error) {
+	user, err := s.UserStore.Authenticate(ctx, email, password)
+	if err != nil {
+		return user, err
+	}
+
+	s.fillMutex.Lock()
+	defer s.fillMutex.Unlock()
+	if cached, ok := s.users.Peek(user.ID); ok && cached.Password != user.Password {
+		s.users.Remove(user.ID)
+	}
+	if fill, exists := s.fills[user.ID]; exists {
+		fill.password = user.Password
+	}
+	return user, nil
+}
+
+// invalidate drops a user from the cache after a change, including one that
+// failed part way, and keeps lookups in flight from caching it as it was
+func (s *CachedUserStore) invalidate(id string) {
+	s.fillMutex.Lock()
+	defer s.fillMutex.Unlock()
+	if fill, exists := s.fills[id]; exists {
+		fill.generation++
+	}
+	s.users.Remove(id)
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+)
+
+func TestCachedUserStoreKeepsUsersAcrossSignIns(t *testing.T) {
+	ctx := context.Background()
+	const secret = "Correct-Horse-Battery-9"
+
+	// The store hashes at cost 5, so a user imported with a cost 4 hash is
+	// upgraded on their first sign-in
+	legacy, err := password.NewHasher(nil, password.NewBcrypt(4)).Hash(secret)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	users := NewInMemoryUserStore(password.NewHasher(nil, password.NewBcrypt(5)), emailaddr.NewNormalizer(true))
+	s := NewCachedUserStore(users, 10, time.Hour)
+	user, err := s.Import(ctx, models.User{Email: "user@example.com", Password: legacy})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	other, err := s.Create(ctx, "other@example.com", secret)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, id := range []string{user.ID, other.ID} {
+		if _, err := s.GetByID(ctx, id); err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+	}
+
+	// Upgrading one user's hash drops only that user
+	upgraded, err := s.Authenticate(ctx, user.Email, secret)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if upgraded.Password == legacy {
+		t.Fatal("hash wasn't upgraded")
+	}
+	if _, cached := s.users.Peek(user.ID); cached {
+		t.Error("user with an upgraded hash is still cached")
+	}
+	if _, cached := s.users.Peek(other.ID); !cached {
+		t.Error("other user was dropped")
+	}
+
+	// Signing in again changes nothing, so the user stays cached
+	if got, err := s.GetByID(ctx, user.ID); err != nil || got.Password != upgraded.Password {
+		t.Fatalf("GetByID: got %+v, %v; want the upgraded hash", got, err)
+	}
+	if _, err := s.Authenticate(ctx, user.Email, secret); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if _, cached := s.users.Peek(user.ID); !cached {
+		t.Error("sign-in without an upgrade dropped the user")
+	}
+}
+
+// blockingUserStore holds each GetByID until released
+type blockingUserStore struct {
+	UserStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingUserStore) GetByID(ctx context.Context, id string) (models.User, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.UserStore.GetByID(ctx, id)
+}
+
+func TestCachedUserStoreInvalidatesPerUser(t *testing.T) {
+	ctx := context.Background()
+	for name, wantCached := range map[string]bool{"same user": false, "other user": true} {
+		t.Run(name, func(t *testing.T) {
+			users := &blockingUserStore{UserStore: newTestUserStore(), started: make(chan struct{}), release: make(chan struct{})}
+			s := NewCachedUserStore(users, 10, time.Hour)
+			user, err := s.Create(ctx, "user@example.com", "Correct-Horse-Battery-9")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			other, err := s.Create(ctx, "other@example.com", "Correct-Horse-Battery-9")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			// Change a user while a lookup of the first is in flight
+			done := make(chan error)
+			go func() {
+				_, err := s.GetByID(ctx, user.ID)
+				done <- err
+			}()
+			<-users.started
+			changed := other.ID
+			if !wantCached {
+				changed = user.ID
+			}
+			if _, err := s.SetManagedRoles(ctx, changed, "directory", []string{"admin"}); err != nil {
+				t.Fatalf("SetManagedRoles: %v", err)
+			}
+			close(users.release)
+			if err := <-done; err != nil {
+				t.Fatalf("GetByID: %v", err)
+			}
+
+			if _, cached := s.users.Peek(user.ID); cached != wantCached {
+				t.Errorf("user cached = %v, want %v", cached, wantCached)
+			}
+		})
+	}
+}
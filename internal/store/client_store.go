@@ -0,0 +1,123 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ClientStore defines the interface for OAuth client operations
+type ClientStore interface {
+	RegisterClient(clientID, secret string) (models.Client, error)
+	RegisterPublicClient(clientID string)
+	RegisterAPIKey(clientID, apiKey string)
+	GetClient(clientID string) (models.Client, bool)
+	AuthenticateClient(clientID, secret string) (models.Client, bool)
+	AuthenticateAPIKey(apiKey string) (models.Client, bool)
+}
+
+// InMemoryClientStore implements ClientStore with in-memory storage
+type InMemoryClientStore struct {
+	clients      map[string]models.Client // clientID -> client
+	apiKeys      map[string]string        // SHA-256 of API key -> clientID
+	clientsMutex sync.RWMutex
+}
+
+// NewInMemoryClientStore creates a new instance of InMemoryClientStore
+func NewInMemoryClientStore() *InMemoryClientStore {
+	return &InMemoryClientStore{
+		clients: make(map[string]models.Client),
+		apiKeys: make(map[string]string),
+	}
+}
+
+// RegisterClient adds a new confidential client to the store
+func (s *InMemoryClientStore) RegisterClient(clientID, secret string) (models.Client, error) {
+	// Hash secret
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return models.Client{}, errors.New(models.ErrInternalServerError)
+	}
+
+	client := models.Client{
+		ID:         clientID,
+		SecretHash: string(hashedSecret),
+	}
+
+	// Store client
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, exists := s.clients[clientID]; exists {
+		return models.Client{}, errors.New(models.ErrClientAlreadyExists)
+	}
+	s.clients[clientID] = client
+
+	return client, nil
+}
+
+// RegisterPublicClient adds a client that has no secret, such as a CLI
+// using the device authorization grant
+func (s *InMemoryClientStore) RegisterPublicClient(clientID string) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, exists := s.clients[clientID]; !exists {
+		s.clients[clientID] = models.Client{ID: clientID}
+	}
+}
+
+// RegisterAPIKey associates an API key with a client identity. API keys are
+// high-entropy secrets, so only a SHA-256 digest is kept.
+func (s *InMemoryClientStore) RegisterAPIKey(clientID, apiKey string) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if _, exists := s.clients[clientID]; !exists {
+		s.clients[clientID] = models.Client{ID: clientID}
+	}
+	s.apiKeys[hashAPIKey(apiKey)] = clientID
+}
+
+// GetClient retrieves a client by ID
+func (s *InMemoryClientStore) GetClient(clientID string) (models.Client, bool) {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	client, exists := s.clients[clientID]
+	return client, exists
+}
+
+// AuthenticateClient verifies client credentials and returns the client if valid
+func (s *InMemoryClientStore) AuthenticateClient(clientID, secret string) (models.Client, bool) {
+	client, found := s.GetClient(clientID)
+	if !found || client.SecretHash == "" {
+		return models.Client{}, false
+	}
+
+	// Validate secret
+	err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret))
+	if err != nil {
+		return models.Client{}, false
+	}
+
+	return client, true
+}
+
+// AuthenticateAPIKey returns the client that owns apiKey
+func (s *InMemoryClientStore) AuthenticateAPIKey(apiKey string) (models.Client, bool) {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	clientID, exists := s.apiKeys[hashAPIKey(apiKey)]
+	if !exists {
+		return models.Client{}, false
+	}
+	client, exists := s.clients[clientID]
+	return client, exists
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 digest of an API key
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
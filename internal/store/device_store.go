@@ -0,0 +1,111 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// DeviceCodeStore defines the interface for device authorization operations
+type DeviceCodeStore interface {
+	StoreDeviceAuthorization(auth models.DeviceAuthorization)
+	GetByDeviceCode(deviceCode string) (models.DeviceAuthorization, bool)
+	GetByUserCode(userCode string) (models.DeviceAuthorization, bool)
+	UpdateDeviceAuthorization(deviceCode string, update func(*models.DeviceAuthorization)) (models.DeviceAuthorization, bool)
+	DeleteDeviceAuthorization(deviceCode string) bool
+}
+
+// deviceCodeGrace is how long an expired device authorization is kept, so a
+// device polling at its interval is told the code expired rather than that
+// it is unknown
+const deviceCodeGrace = time.Minute
+
+// InMemoryDeviceCodeStore implements DeviceCodeStore with in-memory storage
+type InMemoryDeviceCodeStore struct {
+	authorizations map[string]models.DeviceAuthorization // deviceCode -> authorization
+	userCodes      map[string]string                     // userCode -> deviceCode
+	expiries       expiryQueue
+	mutex          sync.RWMutex
+}
+
+// NewInMemoryDeviceCodeStore creates a new instance of InMemoryDeviceCodeStore
+func NewInMemoryDeviceCodeStore() *InMemoryDeviceCodeStore {
+	return &InMemoryDeviceCodeStore{
+		authorizations: make(map[string]models.DeviceAuthorization),
+		userCodes:      make(map[string]string),
+	}
+}
+
+// StoreDeviceAuthorization stores a new device authorization
+func (s *InMemoryDeviceCodeStore) StoreDeviceAuthorization(auth models.DeviceAuthorization) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Forget expired authorizations so the maps don't grow without bound
+	now := time.Now()
+	s.expiries.expire(now, func(deviceCode string) {
+		if expired, exists := s.authorizations[deviceCode]; exists && now.After(expired.ExpiresAt.Add(deviceCodeGrace)) {
+			s.delete(expired)
+		}
+	})
+
+	s.authorizations[auth.DeviceCode] = auth
+	s.userCodes[auth.UserCode] = auth.DeviceCode
+	s.expiries.add(auth.DeviceCode, auth.ExpiresAt.Add(deviceCodeGrace))
+}
+
+// GetByDeviceCode retrieves a device authorization by its device code
+func (s *InMemoryDeviceCodeStore) GetByDeviceCode(deviceCode string) (models.DeviceAuthorization, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	auth, exists := s.authorizations[deviceCode]
+	return auth, exists
+}
+
+// GetByUserCode retrieves a device authorization by its user code
+func (s *InMemoryDeviceCodeStore) GetByUserCode(userCode string) (models.DeviceAuthorization, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	deviceCode, exists := s.userCodes[userCode]
+	if !exists {
+		return models.DeviceAuthorization{}, false
+	}
+	auth, exists := s.authorizations[deviceCode]
+	return auth, exists
+}
+
+// UpdateDeviceAuthorization atomically applies update to an existing device
+// authorization and returns the result. It returns false if the authorization
+// no longer exists.
+func (s *InMemoryDeviceCodeStore) UpdateDeviceAuthorization(deviceCode string, update func(*models.DeviceAuthorization)) (models.DeviceAuthorization, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	auth, exists := s.authorizations[deviceCode]
+	if !exists {
+		return models.DeviceAuthorization{}, false
+	}
+	update(&auth)
+	s.authorizations[deviceCode] = auth
+	return auth, true
+}
+
+// DeleteDeviceAuthorization removes a device authorization from the store.
+// It returns false if the authorization had already been removed, which lets
+// callers redeem a device code exactly once.
+func (s *InMemoryDeviceCodeStore) DeleteDeviceAuthorization(deviceCode string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	auth, exists := s.authorizations[deviceCode]
+	if !exists {
+		return false
+	}
+	s.delete(auth)
+	return true
+}
+
+// delete removes a device authorization; callers must hold the write lock
+func (s *InMemoryDeviceCodeStore) delete(auth models.DeviceAuthorization) {
+	delete(s.authorizations, auth.DeviceCode)
+	delete(s.userCodes, auth.UserCode)
+}
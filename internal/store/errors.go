@@ -0,0 +1,38 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// Sentinel errors returned by the stores. Errors with a more specific message
+// wrap one of them, so callers can classify failures with errors.Is. Any
+// other error comes from the storage backend itself, such as a timeout.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrInvalid            = errors.New("invalid")
+	ErrInvalidCredentials = errors.New(models.ErrInvalidCredentials)
+)
+
+// Error is a store error whose message is suitable for API responses
+type Error struct {
+	Kind    error // one of the sentinel errors
+	Message string
+}
+
+// newError creates a new Error of the given kind
+func newError(kind error, message string) error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the sentinel error, for errors.Is
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiryEntry is a key and the time it was set to expire
+type expiryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// expiryQueue is a min-heap of keys by expiry. Stores push a key whenever its
+// expiry is set and pop the keys that have passed, so dropping expired
+// records costs O(log n) each instead of a scan of the whole map under the
+// store's lock. An entry may be stale if the key was extended or removed
+// since; remove must check the record's current expiry.
+type expiryQueue []expiryEntry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiryEntry)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// add schedules key to be checked at expiresAt
+func (q *expiryQueue) add(key string, expiresAt time.Time) {
+	heap.Push(q, expiryEntry{key: key, expiresAt: expiresAt})
+}
+
+// expire pops every entry due by now and calls remove with its key
+func (q *expiryQueue) expire(now time.Time, remove func(key string)) {
+	for q.Len() > 0 && now.After((*q)[0].expiresAt) {
+		remove(heap.Pop(q).(expiryEntry).key)
+	}
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+)
+
+func TestLoginAttemptsExpire(t *testing.T) {
+	s := NewInMemoryLoginAttemptStore()
+	expireIn := func(d time.Duration) func(*models.LoginAttempts) {
+		return func(a *models.LoginAttempts) {
+			a.Failures++
+			a.ExpiresAt = time.Now().Add(d)
+		}
+	}
+
+	s.UpdateLoginAttempts("short", expireIn(time.Millisecond))
+	s.UpdateLoginAttempts("extended", expireIn(time.Millisecond))
+	s.UpdateLoginAttempts("extended", expireIn(time.Hour))
+	time.Sleep(5 * time.Millisecond)
+
+	// The next update drops the expired record but not the extended one
+	s.UpdateLoginAttempts("other", expireIn(time.Hour))
+	if _, exists := s.attempts["short"]; exists {
+		t.Error("expired record was kept")
+	}
+	if attempts, ok := s.GetLoginAttempts("extended"); !ok || attempts.Failures != 2 {
+		t.Errorf("extended record: got %+v, %v; want 2 failures", attempts, ok)
+	}
+
+	// An expired record starts over
+	if attempts := s.UpdateLoginAttempts("short", expireIn(time.Hour)); attempts.Failures != 1 {
+		t.Errorf("restarted record has %d failures, want 1", attempts.Failures)
+	}
+}
+
+func TestReplayStoreExpires(t *testing.T) {
+	s := NewInMemoryReplayStore()
+	if !s.MarkUsed("id-1", time.Now().Add(time.Millisecond)) {
+		t.Fatal("first use rejected")
+	}
+	if s.MarkUsed("id-1", time.Now().Add(time.Hour)) {
+		t.Error("second use accepted before expiry")
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Once expired, the identifier is forgotten and may be used again
+	if !s.MarkUsed("id-2", time.Now().Add(time.Hour)) {
+		t.Fatal("new identifier rejected")
+	}
+	if _, exists := s.used["id-1"]; exists {
+		t.Error("expired identifier was kept")
+	}
+	if len(s.expiries) != 1 {
+		t.Errorf("%d identifiers queued for expiry, want 1", len(s.expiries))
+	}
+}
+
+func TestDeviceAuthorizationsExpire(t *testing.T) {
+	s := NewInMemoryDeviceCodeStore()
+	s.StoreDeviceAuthorization(models.DeviceAuthorization{DeviceCode: "old", UserCode: "OLD", ExpiresAt: time.Now().Add(-deviceCodeGrace - time.Millisecond)})
+	s.StoreDeviceAuthorization(models.DeviceAuthorization{DeviceCode: "recent", UserCode: "RECENT", ExpiresAt: time.Now().Add(-time.Millisecond)})
+
+	// The next authorization drops the one past its grace period, but a
+	// recently expired one is kept so its device learns that it expired
+	s.StoreDeviceAuthorization(models.DeviceAuthorization{DeviceCode: "new", UserCode: "NEW", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, exists := s.GetByDeviceCode("old"); exists {
+		t.Error("expired authorization was kept")
+	}
+	if _, exists := s.userCodes["OLD"]; exists {
+		t.Error("expired user code was kept")
+	}
+	if _, exists := s.GetByUserCode("RECENT"); !exists {
+		t.Error("recently expired authorization was dropped")
+	}
+	if len(s.expiries) != 2 {
+		t.Errorf("%d authorizations queued for expiry, want 2", len(s.expiries))
+	}
+}
+
+func TestRevocationsExpire(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryTokenStore(time.Millisecond)
+	if err := s.RevokeToken(ctx, "token-1"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if revoked, _ := s.IsTokenRevoked(ctx, "token-1"); !revoked {
+		t.Error("token not revoked")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if revoked, _ := s.IsTokenRevoked(ctx, "token-1"); revoked {
+		t.Error("revocation still in force after its TTL")
+	}
+
+	// The next revocation in the shard forgets it
+	shard := s.shard("token-1")
+	if err := s.revokeUntil("token-1", time.Time{}); err != nil {
+		t.Fatalf("revokeUntil: %v", err)
+	}
+	if expiresAt, exists := shard.revokedTokens["token-1"]; !exists || !expiresAt.IsZero() {
+		t.Errorf("permanent revocation: got %v, %v", expiresAt, exists)
+	}
+	// A shorter revocation doesn't replace a permanent one
+	if err := s.RevokeToken(ctx, "token-1"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if expiresAt := shard.revokedTokens["token-1"]; !expiresAt.IsZero() {
+		t.Errorf("permanent revocation shortened to %v", expiresAt)
+	}
+}
+
+func TestFileStoreDropsExpiredRevocations(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	hasher := password.NewHasher(nil, password.NewBcrypt(4))
+	emails := emailaddr.NewNormalizer(false)
+	f, err := OpenFileStore(dir, hasher, emails, 0, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	if err := f.tokenStore.revokeUntil("expired", time.Now().Add(time.Millisecond)); err != nil {
+		t.Fatalf("revokeUntil: %v", err)
+	}
+	if err := f.Tokens().RevokeToken(ctx, "current"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := f.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The snapshot keeps only the revocation still in force, with its expiry
+	f, err = OpenFileStore(dir, hasher, emails, 0, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer f.Close()
+	if _, exists := f.tokenStore.shard("expired").revokedTokens["expired"]; exists {
+		t.Error("expired revocation survived compaction")
+	}
+	expiresAt, exists := f.tokenStore.shard("current").revokedTokens["current"]
+	if !exists || time.Until(expiresAt) <= 0 || time.Until(expiresAt) > time.Hour {
+		t.Errorf("current revocation: got %v, %v; want expiry within the hour", expiresAt, exists)
+	}
+}
+
+func TestRevocationRecordsExpire(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryRevocationStore()
+	if err := s.PutRevocation(ctx, "short", []byte(`"short"`), time.Now().Add(time.Millisecond)); err != nil {
+		t.Fatalf("PutRevocation: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if records, _ := s.Revocations(ctx); len(records) != 0 {
+		t.Errorf("Revocations: got %s after expiry", records)
+	}
+
+	// The next put forgets it
+	if err := s.PutRevocation(ctx, "other", []byte(`"other"`), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PutRevocation: %v", err)
+	}
+	if _, exists := s.records["short"]; exists {
+		t.Error("expired record was kept")
+	}
+	if len(s.expiries) != 1 {
+		t.Errorf("%d records queued for expiry, want 1", len(s.expiries))
+	}
+}
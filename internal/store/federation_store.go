@@ -0,0 +1,121 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// IdentityStore defines the interface for federated identity links
+type IdentityStore interface {
+	LinkIdentity(provider, subject, userID string) (models.FederatedIdentity, error)
+	GetUserIDByIdentity(provider, subject string) (string, bool)
+}
+
+// LoginStateStore defines the interface for in-flight federated logins
+type LoginStateStore interface {
+	SaveLoginState(state models.FederatedLoginState)
+	ConsumeLoginState(state string) (models.FederatedLoginState, bool)
+}
+
+// InMemoryIdentityStore implements IdentityStore with in-memory storage. Links
+// don't survive a restart: identities whose user the provider provisioned are
+// linked again at their next sign-in, but ones linked to an existing account
+// have to be linked again by signing in to it.
+type InMemoryIdentityStore struct {
+	identities      map[string]models.FederatedIdentity // provider + subject -> identity
+	identitiesMutex sync.RWMutex
+}
+
+// NewInMemoryIdentityStore creates a new instance of InMemoryIdentityStore
+func NewInMemoryIdentityStore() *InMemoryIdentityStore {
+	return &InMemoryIdentityStore{
+		identities: make(map[string]models.FederatedIdentity),
+	}
+}
+
+// LinkIdentity links an upstream identity to a local user. Linking the same
+// identity to the same user again is a no-op.
+func (s *InMemoryIdentityStore) LinkIdentity(provider, subject, userID string) (models.FederatedIdentity, error) {
+	s.identitiesMutex.Lock()
+	defer s.identitiesMutex.Unlock()
+
+	key := identityKey(provider, subject)
+	if existing, exists := s.identities[key]; exists {
+		if existing.UserID != userID {
+			return models.FederatedIdentity{}, newError(ErrConflict, models.ErrIdentityLinked)
+		}
+		return existing, nil
+	}
+
+	identity := models.FederatedIdentity{
+		Provider:  provider,
+		Subject:   subject,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+	}
+	s.identities[key] = identity
+	return identity, nil
+}
+
+// GetUserIDByIdentity retrieves the local user linked to an upstream identity
+func (s *InMemoryIdentityStore) GetUserIDByIdentity(provider, subject string) (string, bool) {
+	s.identitiesMutex.RLock()
+	defer s.identitiesMutex.RUnlock()
+	identity, exists := s.identities[identityKey(provider, subject)]
+	return identity.UserID, exists
+}
+
+// identityKey builds the map key for an upstream identity. Subjects are only
+// unique per provider, so both are part of the key.
+func identityKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
+
+// InMemoryLoginStateStore implements LoginStateStore with in-memory storage
+type InMemoryLoginStateStore struct {
+	states      map[string]models.FederatedLoginState
+	expiries    expiryQueue
+	statesMutex sync.Mutex
+}
+
+// NewInMemoryLoginStateStore creates a new instance of InMemoryLoginStateStore
+func NewInMemoryLoginStateStore() *InMemoryLoginStateStore {
+	return &InMemoryLoginStateStore{
+		states: make(map[string]models.FederatedLoginState),
+	}
+}
+
+// SaveLoginState stores the state of a login that has been sent to a provider
+func (s *InMemoryLoginStateStore) SaveLoginState(state models.FederatedLoginState) {
+	s.statesMutex.Lock()
+	defer s.statesMutex.Unlock()
+
+	// Drop abandoned logins so the map doesn't grow without bound
+	now := time.Now()
+	s.expiries.expire(now, func(key string) {
+		if existing, exists := s.states[key]; exists && now.After(existing.ExpiresAt) {
+			delete(s.states, key)
+		}
+	})
+	s.states[state.State] = state
+	s.expiries.add(state.State, state.ExpiresAt)
+}
+
+// ConsumeLoginState removes and returns an unexpired login state, so each
+// state value can complete at most one login
+func (s *InMemoryLoginStateStore) ConsumeLoginState(state string) (models.FederatedLoginState, bool) {
+	s.statesMutex.Lock()
+	defer s.statesMutex.Unlock()
+
+	loginState, exists := s.states[state]
+	if !exists {
+		return models.FederatedLoginState{}, false
+	}
+	delete(s.states, state)
+	if time.Now().After(loginState.ExpiresAt) {
+		return models.FederatedLoginState{}, false
+	}
+	return loginState, true
+}
@@ -0,0 +1,564 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+)
+
+// Write-ahead log operations
+const (
+	opPutUser               = "put_user"
+	opStoreRefreshToken     = "store_refresh_token"
+	opDeleteRefreshToken    = "delete_refresh_token"
+	opAddDerivedAccessToken = "add_derived_access_token"
+	opRevokeToken           = "revoke_token"
+	opPutRevocation         = "put_revocation"
+)
+
+// walRecord is one change in the write-ahead log
+type walRecord struct {
+	Op          string          `json:"op"`
+	User        *userRecord     `json:"user,omitempty"`
+	Token       string          `json:"token,omitempty"`
+	UserID      string          `json:"user_id,omitempty"`
+	AccessToken string          `json:"access_token,omitempty"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"` // when a revocation may be forgotten
+	Key         string          `json:"key,omitempty"`
+	Revocation  json.RawMessage `json:"revocation,omitempty"`
+}
+
+// Records are framed as a little-endian uint32 payload length and the
+// payload's CRC-32C, followed by the JSON payload
+const (
+	walHeaderSize    = 8
+	maxWALRecordSize = 16 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord marks a record that was cut short or doesn't match its checksum
+var errTornRecord = errors.New("torn or corrupt record")
+
+// ErrStoreClosed is returned for changes made after a FileStore is closed
+var ErrStoreClosed = errors.New("file store: closed")
+
+// FileStore implements Store with the in-memory stores, persisting every
+// change to a write-ahead log in a directory. Each change is fsynced to the
+// log before it is applied. The log is split into numbered segments; after
+// snapshotEvery records the state is written to a snapshot and the segments
+// before it are removed, along with revocations that have expired. Opening
+// the store loads the newest snapshot and replays the segments written after
+// it.
+//
+// A change is fsynced while the lock of the map it changes is held, and
+// appends to the log take turns, so changes are made at the rate the disk
+// syncs and readers of the changed map or token shard wait for the sync. In
+// exchange nothing is visible before it is durable: batching syncs across
+// callers would mean applying a change, and letting others read it, before
+// its record reaches the disk.
+type FileStore struct {
+	dir           string
+	codec         recordCodec
+	userStore     *InMemoryUserStore
+	tokenStore    *InMemoryTokenStore
+	revocations   *InMemoryRevocationStore
+	snapshotEvery int
+
+	walMutex   sync.Mutex
+	wal        *os.File
+	walSize    int64  // bytes of complete records in the open segment
+	generation uint64 // number of the open segment
+	records    int    // records in the open segment
+	compacting bool
+	compaction sync.WaitGroup
+	failed     error // set when the log can no longer be appended to safely
+	closed     bool
+}
+
+// OpenFileStore opens or creates a FileStore in dir. Passwords are hashed with
+// hasher and emails compared by their normalized key, as in
+// InMemoryUserStore. Token revocations are kept for revocationTTL, as in
+// InMemoryTokenStore. If cipher is set, users' personal fields are encrypted
+// in the log and snapshots, and users are matched by the blind index of their
+// email. A torn record at the end of the log, left by a
+// crash during a write, is discarded.
+func OpenFileStore(dir string, hasher *password.Hasher, emails *emailaddr.Normalizer, snapshotEvery int, revocationTTL time.Duration, cipher FieldCipher) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("file store: %w", err)
+	}
+	f := &FileStore{
+		dir:           dir,
+		codec:         recordCodec{cipher: cipher, emails: emails},
+		userStore:     NewInMemoryUserStore(hasher, emails),
+		tokenStore:    NewInMemoryTokenStore(revocationTTL),
+		revocations:   NewInMemoryRevocationStore(),
+		snapshotEvery: snapshotEvery,
+	}
+
+	if cipher != nil {
+		f.userStore.blindIndex = cipher.BlindIndex
+	}
+
+	// Remove what an interrupted snapshot left behind
+	if leftovers, err := filepath.Glob(filepath.Join(dir, "*.tmp")); err == nil {
+		for _, leftover := range leftovers {
+			os.Remove(leftover)
+		}
+	}
+	snapshots, segments, err := f.listFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the newest snapshot; it covers every segment before its number
+	var base uint64
+	if len(snapshots) > 0 {
+		base = snapshots[len(snapshots)-1]
+		if err := f.loadSnapshot(base); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replay the segments written since, tolerating a torn tail in the last
+	f.generation = base
+	var replay []uint64
+	for _, generation := range segments {
+		if generation >= base {
+			replay = append(replay, generation)
+		}
+	}
+	for i, generation := range replay {
+		records, size, err := f.replaySegment(generation, i == len(replay)-1)
+		if err != nil {
+			return nil, err
+		}
+		f.generation, f.records, f.walSize = generation, records, size
+	}
+
+	// Keep appending to the last segment
+	f.wal, err = os.OpenFile(f.segmentPath(f.generation), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("file store: %w", err)
+	}
+	if err := f.syncDir(); err != nil {
+		f.wal.Close()
+		return nil, err
+	}
+	f.removeBefore(base)
+
+	f.userStore.journal = func(user models.User) error {
+		record, err := f.codec.encode(user)
+		if err != nil {
+			return err
+		}
+		return f.append(walRecord{Op: opPutUser, User: record})
+	}
+	f.tokenStore.journal = f.append
+	f.revocations.journal = f.append
+	return f, nil
+}
+
+// Users returns the user store
+func (f *FileStore) Users() UserStore {
+	return f.userStore
+}
+
+// Tokens returns the token store
+func (f *FileStore) Tokens() TokenStore {
+	return f.tokenStore
+}
+
+// Revocations returns the store of session and user revocations
+func (f *FileStore) Revocations() RevocationStore {
+	return f.revocations
+}
+
+// Close waits for a running compaction and closes the log. Later changes
+// fail with ErrStoreClosed.
+func (f *FileStore) Close() error {
+	f.walMutex.Lock()
+	if f.closed {
+		f.walMutex.Unlock()
+		return nil
+	}
+	f.closed = true
+	f.walMutex.Unlock()
+
+	f.compaction.Wait()
+	return f.wal.Close()
+}
+
+// segmentPath is the path of a log segment
+func (f *FileStore) segmentPath(generation uint64) string {
+	return filepath.Join(f.dir, fmt.Sprintf("wal-%016x.log", generation))
+}
+
+// snapshotPath is the path of the snapshot taken when a segment was started
+func (f *FileStore) snapshotPath(generation uint64) string {
+	return filepath.Join(f.dir, fmt.Sprintf("snapshot-%016x.json", generation))
+}
+
+// listFiles returns the numbers of the snapshots and log segments in the
+// directory, in ascending order
+func (f *FileStore) listFiles() ([]uint64, []uint64, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("file store: %w", err)
+	}
+	var snapshots, segments []uint64
+	for _, entry := range entries {
+		var generation uint64
+		name := entry.Name()
+		switch {
+		case matchName(name, "snapshot-%016x.json", &generation):
+			snapshots = append(snapshots, generation)
+		case matchName(name, "wal-%016x.log", &generation):
+			segments = append(segments, generation)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return snapshots, segments, nil
+}
+
+// matchName parses a file name of the given format, requiring an exact match
+func matchName(name, format string, generation *uint64) bool {
+	if _, err := fmt.Sscanf(name, format, generation); err != nil {
+		return false
+	}
+	return fmt.Sprintf(format, *generation) == name
+}
+
+// loadSnapshot restores the state saved in a snapshot
+func (f *FileStore) loadSnapshot(generation uint64) error {
+	data, err := os.ReadFile(f.snapshotPath(generation))
+	if err != nil {
+		return fmt.Errorf("file store: %w", err)
+	}
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("file store: snapshot %d: %w", generation, err)
+	}
+	if err := restoreState(f.codec, f.userStore, f.tokenStore, f.revocations, state); err != nil {
+		return fmt.Errorf("file store: snapshot %d: %w", generation, err)
+	}
+	return nil
+}
+
+// replaySegment applies the records of a log segment and returns how many it
+// holds and their size in bytes. In the last segment, a torn record and
+// anything after it are cut off; elsewhere they are an error.
+func (f *FileStore) replaySegment(generation uint64, last bool) (int, int64, error) {
+	path := f.segmentPath(generation)
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("file store: %w", err)
+	}
+	defer file.Close()
+
+	var records int
+	var offset int64
+	for {
+		rec, size, err := readRecord(file)
+		if err == io.EOF {
+			return records, offset, nil
+		}
+		if errors.Is(err, errTornRecord) && last {
+			log.Printf("file store: discarding torn record at %s:%d", path, offset)
+			if err := os.Truncate(path, offset); err != nil {
+				return 0, 0, fmt.Errorf("file store: %w", err)
+			}
+			return records, offset, nil
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("file store: %s at offset %d: %w", path, offset, err)
+		}
+		if err := f.apply(rec); err != nil {
+			return 0, 0, fmt.Errorf("file store: %s at offset %d: %w", path, offset, err)
+		}
+		records++
+		offset += size
+	}
+}
+
+// readRecord reads one framed record and returns it with its size in bytes.
+// It returns io.EOF at a clean end of the log.
+func readRecord(r io.Reader) (walRecord, int64, error) {
+	var header [walHeaderSize]byte
+	if n, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF && n == 0 {
+			return walRecord{}, 0, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return walRecord{}, 0, errTornRecord
+		}
+		return walRecord{}, 0, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxWALRecordSize {
+		return walRecord{}, 0, errTornRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return walRecord{}, 0, errTornRecord
+		}
+		return walRecord{}, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return walRecord{}, 0, errTornRecord
+	}
+
+	var rec walRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return walRecord{}, 0, err
+	}
+	return rec, int64(walHeaderSize + length), nil
+}
+
+// apply replays a logged change onto the in-memory stores
+func (f *FileStore) apply(rec walRecord) error {
+	tokens := f.tokenStore
+	switch rec.Op {
+	case opPutUser:
+		if rec.User != nil {
+			user, err := f.codec.decode(rec.User)
+			if err != nil {
+				return err
+			}
+			f.userStore.restore(user)
+		}
+	case opStoreRefreshToken:
+		tokens.shard(rec.Token).refreshTokens[rec.Token] = rec.UserID
+	case opDeleteRefreshToken:
+		shard := tokens.shard(rec.Token)
+		delete(shard.refreshTokens, rec.Token)
+		delete(shard.derivedTokens, rec.Token)
+	case opAddDerivedAccessToken:
+		shard := tokens.shard(rec.Token)
+		shard.derivedTokens[rec.Token] = append(shard.derivedTokens[rec.Token], rec.AccessToken)
+	case opRevokeToken:
+		var expiresAt time.Time
+		if rec.ExpiresAt != nil {
+			expiresAt = *rec.ExpiresAt
+		}
+		tokens.shard(rec.Token).revoke(rec.Token, expiresAt)
+	case opPutRevocation:
+		if rec.ExpiresAt != nil {
+			f.revocations.put(rec.Key, rec.Revocation, *rec.ExpiresAt, time.Now())
+		}
+	}
+	return nil
+}
+
+// append writes a record to the log and fsyncs it. It is called by the
+// in-memory stores with their lock held, so records are logged in the order
+// the changes are applied; see FileStore for what holding it across the
+// fsync costs.
+func (f *FileStore) append(rec walRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("file store: %w", err)
+	}
+	frame := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload, crcTable))
+	copy(frame[walHeaderSize:], payload)
+
+	f.walMutex.Lock()
+	defer f.walMutex.Unlock()
+	if f.closed {
+		return ErrStoreClosed
+	}
+	if f.failed != nil {
+		return f.failed
+	}
+
+	if _, err := f.wal.Write(frame); err != nil {
+		f.rollback()
+		return fmt.Errorf("file store: write log: %w", err)
+	}
+	if err := f.wal.Sync(); err != nil {
+		f.rollback()
+		return fmt.Errorf("file store: sync log: %w", err)
+	}
+	f.walSize += int64(len(frame))
+	f.records++
+
+	// Compact in the background; it needs the stores' locks, which our caller holds
+	if f.snapshotEvery > 0 && f.records >= f.snapshotEvery && !f.compacting {
+		f.compacting = true
+		f.compaction.Add(1)
+		go func() {
+			defer f.compaction.Done()
+			if err := f.compact(); err != nil && !errors.Is(err, ErrStoreClosed) {
+				log.Printf("%v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// rollback cuts a partly written record off the log, so later records aren't
+// hidden behind it at replay. If that fails the log is unusable until the
+// store is reopened; callers must hold walMutex.
+func (f *FileStore) rollback() {
+	if err := f.wal.Truncate(f.walSize); err != nil {
+		f.failed = fmt.Errorf("file store: log unusable after failed write: %w", err)
+	}
+}
+
+// Compact writes a snapshot of the current state and removes the log
+// segments it replaces. It runs automatically every snapshotEvery records.
+func (f *FileStore) Compact() error {
+	f.walMutex.Lock()
+	if f.compacting {
+		f.walMutex.Unlock()
+		return nil
+	}
+	f.compacting = true
+	f.walMutex.Unlock()
+	return f.compact()
+}
+
+// compact takes a snapshot; the caller must have set f.compacting
+func (f *FileStore) compact() error {
+	defer func() {
+		f.walMutex.Lock()
+		f.compacting = false
+		f.walMutex.Unlock()
+	}()
+
+	// Copy the state and start a new segment without letting any change in
+	// between, so the snapshot covers exactly the segments before the new one
+	users, tokens, revocations := f.userStore, f.tokenStore, f.revocations
+	users.usersMutex.RLock()
+	tokens.rLockAll()
+	revocations.revocationsMutex.RLock()
+	state, err := captureState(f.codec, users, tokens, revocations)
+	var generation uint64
+	if err == nil {
+		f.walMutex.Lock()
+		generation, err = f.rotate()
+		f.walMutex.Unlock()
+	}
+	revocations.revocationsMutex.RUnlock()
+	tokens.rUnlockAll()
+	users.usersMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Until the snapshot is in place, the older snapshot and segments still
+	// hold everything
+	if err := f.writeSnapshot(generation, state); err != nil {
+		return err
+	}
+	f.removeBefore(generation)
+	return nil
+}
+
+// rotate closes the open segment and starts the next one, returning its
+// number; callers must hold walMutex
+func (f *FileStore) rotate() (uint64, error) {
+	if f.closed {
+		return 0, ErrStoreClosed
+	}
+	generation := f.generation + 1
+	wal, err := os.OpenFile(f.segmentPath(generation), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("file store: %w", err)
+	}
+	if err := f.syncDir(); err != nil {
+		wal.Close()
+		os.Remove(f.segmentPath(generation))
+		return 0, err
+	}
+	f.wal.Close()
+	f.wal, f.walSize, f.generation, f.records, f.failed = wal, 0, generation, 0, nil
+	return generation, nil
+}
+
+// writeSnapshot atomically writes the snapshot for a segment
+func (f *FileStore) writeSnapshot(generation uint64, state snapshotState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("file store: %w", err)
+	}
+
+	path := f.snapshotPath(generation)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("file store: write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("file store: write snapshot: %w", err)
+	}
+	return f.syncDir()
+}
+
+// writeFileSync writes data to a new file and fsyncs it
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeBefore deletes the snapshots and segments replaced by the snapshot
+// for generation
+func (f *FileStore) removeBefore(generation uint64) {
+	snapshots, segments, err := f.listFiles()
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	for _, older := range snapshots {
+		if older < generation {
+			os.Remove(f.snapshotPath(older))
+		}
+	}
+	for _, older := range segments {
+		if older < generation {
+			os.Remove(f.segmentPath(older))
+		}
+	}
+}
+
+// syncDir fsyncs the directory so created and renamed files survive a crash
+func (f *FileStore) syncDir() error {
+	dir, err := os.Open(f.dir)
+	if err != nil {
+		return fmt.Errorf("file store: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("file store: sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// LoginAttemptStore defines the interface for tracking failed sign-ins per account
+type LoginAttemptStore interface {
+	GetLoginAttempts(key string) (models.LoginAttempts, bool)
+	UpdateLoginAttempts(key string, update func(*models.LoginAttempts)) models.LoginAttempts
+	ResetLoginAttempts(key string) bool
+}
+
+// InMemoryLoginAttemptStore implements LoginAttemptStore with in-memory storage
+type InMemoryLoginAttemptStore struct {
+	attempts      map[string]models.LoginAttempts
+	expiries      expiryQueue
+	attemptsMutex sync.Mutex
+}
+
+// NewInMemoryLoginAttemptStore creates a new instance of InMemoryLoginAttemptStore
+func NewInMemoryLoginAttemptStore() *InMemoryLoginAttemptStore {
+	return &InMemoryLoginAttemptStore{
+		attempts: make(map[string]models.LoginAttempts),
+	}
+}
+
+// GetLoginAttempts retrieves the failed sign-ins recorded for key
+func (s *InMemoryLoginAttemptStore) GetLoginAttempts(key string) (models.LoginAttempts, bool) {
+	s.attemptsMutex.Lock()
+	defer s.attemptsMutex.Unlock()
+
+	attempts, exists := s.attempts[key]
+	if !exists || time.Now().After(attempts.ExpiresAt) {
+		return models.LoginAttempts{}, false
+	}
+	return attempts, true
+}
+
+// UpdateLoginAttempts atomically applies update to the record for key,
+// starting from an empty record if none exists or it has expired
+func (s *InMemoryLoginAttemptStore) UpdateLoginAttempts(key string, update func(*models.LoginAttempts)) models.LoginAttempts {
+	s.attemptsMutex.Lock()
+	defer s.attemptsMutex.Unlock()
+
+	// Forget expired records so the map doesn't grow without bound
+	now := time.Now()
+	s.expiries.expire(now, func(k string) {
+		if attempts, exists := s.attempts[k]; exists && now.After(attempts.ExpiresAt) {
+			delete(s.attempts, k)
+		}
+	})
+
+	attempts, exists := s.attempts[key]
+	if exists && now.After(attempts.ExpiresAt) {
+		attempts, exists = models.LoginAttempts{}, false
+	}
+	previous := attempts.ExpiresAt
+	update(&attempts)
+	s.attempts[key] = attempts
+	if !exists || !attempts.ExpiresAt.Equal(previous) {
+		s.expiries.add(key, attempts.ExpiresAt)
+	}
+	return attempts
+}
+
+// ResetLoginAttempts removes the record for key. It returns false if there was none.
+func (s *InMemoryLoginAttemptStore) ResetLoginAttempts(key string) bool {
+	s.attemptsMutex.Lock()
+	defer s.attemptsMutex.Unlock()
+
+	_, exists := s.attempts[key]
+	delete(s.attempts, key)
+	return exists
+}
@@ -0,0 +1,1287 @@
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+)
+
+// Raft log operations, in addition to the write-ahead log ones
+const (
+	opCreateUser          = "create_user"
+	opUpdateUser          = "update_user"
+	opSetPassword         = "set_password"
+	opRehashPassword      = "rehash_password"
+	opSetManagedRoles     = "set_managed_roles"
+	opConsumeRefreshToken = "consume_refresh_token"
+)
+
+// raftCommand is one change replicated through the Raft log. Everything that
+// isn't deterministic, such as IDs, timestamps and password hashes, is
+// computed before the change is logged, so every node applies it the same way.
+type raftCommand struct {
+	Op          string          `json:"op"`
+	User        *userRecord     `json:"user,omitempty"`
+	Expected    string          `json:"expected,omitempty"` // password hash the user must still have
+	Token       string          `json:"token,omitempty"`
+	UserID      string          `json:"user_id,omitempty"`
+	AccessToken string          `json:"access_token,omitempty"`
+	Roles       []string        `json:"roles,omitempty"`
+	Source      string          `json:"source,omitempty"`     // what granted Roles
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"` // when a revocation may be forgotten
+	Key         string          `json:"key,omitempty"`
+	Revocation  json.RawMessage `json:"revocation,omitempty"`
+}
+
+// raftResult is the outcome of applying a command
+type raftResult struct {
+	UserID  string     `json:"user_id,omitempty"`
+	Derived []string   `json:"derived,omitempty"`
+	Error   *wireError `json:"error,omitempty"`
+}
+
+// wireError carries a store error between nodes, keeping its kind
+type wireError struct {
+	Kind    string `json:"kind,omitempty"`
+	Message string `json:"message"`
+}
+
+// errorKinds are the sentinel errors a wireError can carry
+var errorKinds = []error{ErrNotFound, ErrConflict, ErrInvalid, ErrInvalidCredentials}
+
+// toWireError converts an error for sending to another node
+func toWireError(err error) *wireError {
+	if err == nil {
+		return nil
+	}
+	wire := &wireError{Message: err.Error()}
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind) {
+			wire.Kind = kind.Error()
+			break
+		}
+	}
+	return wire
+}
+
+// err converts a received error back, restoring its kind
+func (w *wireError) err() error {
+	if w == nil {
+		return nil
+	}
+	for _, kind := range errorKinds {
+		if w.Kind != kind.Error() {
+			continue
+		}
+		if w.Message == kind.Error() {
+			return kind
+		}
+		return newError(kind, w.Message)
+	}
+	return errors.New(w.Message)
+}
+
+// raftFSM applies committed commands to the in-memory stores. It also tracks
+// the index of the last command applied, so a node can wait until it has
+// caught up with a change made through the leader.
+type raftFSM struct {
+	codec       recordCodec
+	users       *InMemoryUserStore
+	tokens      *InMemoryTokenStore
+	revocations *InMemoryRevocationStore
+
+	mutex    sync.Mutex
+	applied  uint64
+	advanced chan struct{} // closed and replaced whenever applied moves
+}
+
+// Apply implements raft.FSM
+func (f *raftFSM) Apply(entry *raft.Log) interface{} {
+	var cmd raftCommand
+	result := raftResult{}
+	if err := json.Unmarshal(entry.Data, &cmd); err != nil {
+		result.Error = toWireError(fmt.Errorf("raft store: decode command: %w", err))
+	} else {
+		result = f.apply(cmd)
+	}
+	f.setApplied(entry.Index)
+	return result
+}
+
+// apply makes the change described by a command
+func (f *raftFSM) apply(cmd raftCommand) raftResult {
+	ctx := context.Background()
+	users, tokens := f.users, f.tokens
+
+	var err error
+	switch cmd.Op {
+	case opCreateUser, opUpdateUser, opSetPassword, opRehashPassword:
+		if cmd.User == nil {
+			err = fmt.Errorf("raft store: %s without a user", cmd.Op)
+			break
+		}
+		var user models.User
+		if user, err = f.codec.decode(cmd.User); err != nil {
+			break
+		}
+		switch cmd.Op {
+		case opCreateUser:
+			users.usersMutex.Lock()
+			err = users.insert(user, users.emailKey(user.Email))
+			users.usersMutex.Unlock()
+		case opUpdateUser:
+			err = users.Update(ctx, user)
+		default:
+			users.usersMutex.Lock()
+			err = users.swapPassword(cmd.Expected, user, cmd.Op == opSetPassword)
+			users.usersMutex.Unlock()
+		}
+	case opSetManagedRoles:
+		_, err = users.SetManagedRoles(ctx, cmd.UserID, cmd.Source, cmd.Roles)
+	case opStoreRefreshToken:
+		err = tokens.StoreRefreshToken(ctx, cmd.Token, cmd.UserID)
+	case opDeleteRefreshToken:
+		err = tokens.DeleteRefreshToken(ctx, cmd.Token)
+	case opConsumeRefreshToken:
+		userID, derived, err := tokens.ConsumeRefreshToken(ctx, cmd.Token)
+		return raftResult{UserID: userID, Derived: derived, Error: toWireError(err)}
+	case opAddDerivedAccessToken:
+		err = tokens.AddDerivedAccessToken(ctx, cmd.Token, cmd.AccessToken)
+	case opRevokeToken:
+		var expiresAt time.Time
+		if cmd.ExpiresAt != nil {
+			expiresAt = *cmd.ExpiresAt
+		}
+		err = tokens.revokeUntil(cmd.Token, expiresAt)
+	case opPutRevocation:
+		if cmd.ExpiresAt == nil {
+			err = fmt.Errorf("raft store: %s without an expiry", cmd.Op)
+			break
+		}
+		err = f.revocations.PutRevocation(ctx, cmd.Key, cmd.Revocation, *cmd.ExpiresAt)
+	default:
+		err = fmt.Errorf("raft store: unknown command %q", cmd.Op)
+	}
+	return raftResult{Error: toWireError(err)}
+}
+
+// Snapshot implements raft.FSM. It runs between calls to Apply, so the state
+// copied matches the applied index.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	users, tokens, revocations := f.users, f.tokens, f.revocations
+	users.usersMutex.RLock()
+	tokens.rLockAll()
+	revocations.revocationsMutex.RLock()
+	state, err := captureState(f.codec, users, tokens, revocations)
+	revocations.revocationsMutex.RUnlock()
+	tokens.rUnlockAll()
+	users.usersMutex.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("raft store: %w", err)
+	}
+
+	return &raftSnapshot{Index: f.appliedIndex(), State: state}, nil
+}
+
+// Restore implements raft.FSM, replacing the state with a snapshot's
+func (f *raftFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	var contents raftSnapshot
+	if err := json.NewDecoder(snapshot).Decode(&contents); err != nil {
+		return fmt.Errorf("raft store: decode snapshot: %w", err)
+	}
+	if err := restoreState(f.codec, f.users, f.tokens, f.revocations, contents.State); err != nil {
+		return fmt.Errorf("raft store: restore snapshot: %w", err)
+	}
+
+	f.mutex.Lock()
+	f.applied = contents.Index
+	close(f.advanced)
+	f.advanced = make(chan struct{})
+	f.mutex.Unlock()
+	return nil
+}
+
+// setApplied records that the command at index has been applied
+func (f *raftFSM) setApplied(index uint64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if index > f.applied {
+		f.applied = index
+		close(f.advanced)
+		f.advanced = make(chan struct{})
+	}
+}
+
+// appliedIndex returns the index of the last command applied
+func (f *raftFSM) appliedIndex() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.applied
+}
+
+// waitApplied waits until the command at index has been applied
+func (f *raftFSM) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		f.mutex.Lock()
+		applied, advanced := f.applied, f.advanced
+		f.mutex.Unlock()
+		if applied >= index {
+			return nil
+		}
+
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// raftSnapshot is the contents of a Raft snapshot
+type raftSnapshot struct {
+	Index uint64        `json:"index"` // last command applied
+	State snapshotState `json:"state"`
+}
+
+// Persist implements raft.FSMSnapshot
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return fmt.Errorf("raft store: write snapshot: %w", err)
+	}
+	return sink.Close()
+}
+
+// Release implements raft.FSMSnapshot
+func (s *raftSnapshot) Release() {}
+
+// Connections to a node's Raft port use mutual TLS and start with a byte
+// saying whether they carry Raft traffic or requests forwarded to the leader
+const (
+	raftStreamByte    byte = 'R'
+	forwardStreamByte byte = 'F'
+)
+
+// errRaftLayerClosed is returned by raftLayer.Accept once the store is closed
+var errRaftLayerClosed = errors.New("raft store: transport closed")
+
+// raftLayer is the raft.StreamLayer for the Raft connections sharing the
+// node's port with forwarded requests
+type raftLayer struct {
+	advertise raftAddr
+	tls       *tls.Config
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// raftAddr is the address a node advertises to its peers
+type raftAddr string
+
+// Network implements net.Addr
+func (a raftAddr) Network() string { return "tcp" }
+
+// String implements net.Addr
+func (a raftAddr) String() string { return string(a) }
+
+// Accept implements net.Listener
+func (l *raftLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errRaftLayerClosed
+	}
+}
+
+// Close implements net.Listener. The shared listener is closed by the store.
+func (l *raftLayer) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr implements net.Listener
+func (l *raftLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+// Dial implements raft.StreamLayer
+func (l *raftLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", string(address), l.tls)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{raftStreamByte}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Requests forwarded to the leader
+const (
+	forwardApply = "apply" // apply a command
+	forwardRead  = "read"  // return an index covering every committed change
+)
+
+// A node keeps a forwarding connection to the leader open for more requests.
+// The leader closes connections idle for forwardIdleTimeout; the forwarding
+// node stops reusing them well before, so it never sends a request on a
+// connection being closed.
+const (
+	forwardIdleTimeout = 90 * time.Second
+	forwardReuseWithin = 30 * time.Second
+	forwardPoolSize    = 8 // idle connections kept per leader
+)
+
+// forwardRequest is a request forwarded to the leader
+type forwardRequest struct {
+	Kind    string       `json:"kind"`
+	Command *raftCommand `json:"command,omitempty"`
+}
+
+// forwardResponse is the leader's answer to a forwarded request. Index is
+// the index the requesting node must apply before answering its caller.
+type forwardResponse struct {
+	Index     uint64     `json:"index"`
+	Result    raftResult `json:"result"`
+	NotLeader bool       `json:"not_leader,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// errNotApplied marks a change that certainly wasn't made, because there was
+// no leader to make it; such changes are retried
+var errNotApplied = errors.New("raft store: no leader")
+
+// raftRetryInterval is how long to wait before retrying a change that found
+// no leader
+const raftRetryInterval = 50 * time.Millisecond
+
+// RaftConfig holds the settings for a RaftStore
+type RaftConfig struct {
+	NodeID string
+	// Address to listen on for Raft traffic and forwarded requests; defaults
+	// to the node's own entry in Peers
+	Address string
+	// Directory for the Raft log and snapshots
+	Dir string
+	// Cluster members, node ID -> advertised "host:port", including this node
+	Peers map[string]string
+	// How long a change may wait for the leader, and for it to commit
+	ApplyTimeout time.Duration
+	// How long token revocations are kept; zero keeps them forever
+	RevocationTTL time.Duration
+	// Encrypts users' personal fields in the log and snapshots, if set;
+	// every node must use the same keys
+	Cipher FieldCipher
+	// Authenticates nodes to each other; required. See LoadRaftTLS.
+	TLS *tls.Config
+}
+
+// LoadRaftTLS returns the TLS settings for a node of the cluster from PEM
+// files: the node's certificate and key, and the CA that issued every node's
+// certificate. Nodes accept only connections presenting a certificate from
+// that CA, and each node's certificate must be valid for the host in its
+// Peers entry.
+func LoadRaftTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("raft store: load certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("raft store: load CA: %w", err)
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("raft store: no certificates in %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      cas,
+		ClientCAs:    cas,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// RaftStore implements Store with the in-memory stores on every node of a
+// Raft cluster. Every change goes through the leader: other nodes forward it
+// and wait until they've applied it themselves, so a caller always reads its
+// own writes. Revocation checks, sign-ins and derived token lookups first
+// catch up with every change committed before the call, so a revoked token
+// is rejected everywhere as soon as the revocation returns. Other reads are
+// served from the local copy, catching up only when they find nothing.
+//
+// The cluster is bootstrapped from Peers when a node starts without Raft
+// state, and whichever node becomes leader adds and removes members to
+// match its Peers. All nodes must share Peers and the password hashing and
+// email settings. Nodes authenticate each other with mutual TLS, on Raft
+// traffic and forwarded requests alike.
+type RaftStore struct {
+	nodeID       raft.ServerID
+	dir          string
+	peers        map[string]string
+	applyTimeout time.Duration
+	tls          *tls.Config
+
+	raft      *raft.Raft
+	fsm       *raftFSM
+	listener  net.Listener
+	layer     *raftLayer
+	transport *raft.NetworkTransport
+	boltStore *raftboltdb.BoltStore
+	logs      raft.LogStore
+	pool      forwardPool
+
+	// Forwarding connections being served, closed when the store closes
+	connMutex sync.Mutex
+	conns     map[net.Conn]struct{}
+
+	users       *raftUserStore
+	tokens      *raftTokenStore
+	revocations *raftRevocationStore
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+}
+
+// OpenRaftStore starts a node of a Raft cluster, restoring its state from
+// cfg.Dir. Passwords are hashed with hasher and emails compared by their
+// normalized key, as in InMemoryUserStore.
+func OpenRaftStore(cfg RaftConfig, hasher *password.Hasher, emails *emailaddr.Normalizer) (*RaftStore, error) {
+	advertise, ok := cfg.Peers[cfg.NodeID]
+	if cfg.NodeID == "" || !ok {
+		return nil, fmt.Errorf("raft store: node %q is not one of the peers", cfg.NodeID)
+	}
+	address := cfg.Address
+	if address == "" {
+		address = advertise
+	}
+	if cfg.TLS == nil {
+		return nil, errors.New("raft store: TLS settings are required")
+	}
+	if cfg.ApplyTimeout <= 0 {
+		cfg.ApplyTimeout = 5 * time.Second
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("raft store: %w", err)
+	}
+
+	s := &RaftStore{
+		nodeID:       raft.ServerID(cfg.NodeID),
+		dir:          cfg.Dir,
+		peers:        cfg.Peers,
+		applyTimeout: cfg.ApplyTimeout,
+		tls:          cfg.TLS,
+		fsm: &raftFSM{
+			codec:       recordCodec{cipher: cfg.Cipher, emails: emails},
+			users:       NewInMemoryUserStore(hasher, emails),
+			tokens:      NewInMemoryTokenStore(cfg.RevocationTTL),
+			revocations: NewInMemoryRevocationStore(),
+			advanced:    make(chan struct{}),
+		},
+		layer: &raftLayer{
+			advertise: raftAddr(advertise),
+			tls:       cfg.TLS,
+			conns:     make(chan net.Conn),
+			closed:    make(chan struct{}),
+		},
+		pool:    forwardPool{idle: make(map[string][]*forwardConn)},
+		conns:   make(map[net.Conn]struct{}),
+		closing: make(chan struct{}),
+	}
+	if cfg.Cipher != nil {
+		s.fsm.users.blindIndex = cfg.Cipher.BlindIndex
+	}
+	s.users = &raftUserStore{store: s, local: s.fsm.users}
+	s.tokens = &raftTokenStore{store: s, local: s.fsm.tokens}
+	s.revocations = &raftRevocationStore{store: s, local: s.fsm.revocations}
+	if err := s.open(address); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open starts the listener and Raft, bootstrapping the cluster if this node
+// has no state yet
+func (s *RaftStore) open(address string) error {
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Output: log.Writer(),
+		Level:  hclog.Warn,
+	})
+
+	var err error
+	if s.boltStore, err = raftboltdb.NewBoltStore(filepath.Join(s.dir, "raft.db")); err != nil {
+		return fmt.Errorf("raft store: %w", err)
+	}
+	if s.logs, err = raft.NewLogCache(512, s.boltStore); err != nil {
+		return fmt.Errorf("raft store: %w", err)
+	}
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(s.dir, 2, logger)
+	if err != nil {
+		return fmt.Errorf("raft store: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("raft store: %w", err)
+	}
+	s.listener = tls.NewListener(listener, s.tls)
+	s.transport = raft.NewNetworkTransportWithLogger(s.layer, 3, 10*time.Second, logger)
+	s.workers.Add(1)
+	go s.serve()
+
+	config := raft.DefaultConfig()
+	config.LocalID = s.nodeID
+	config.Logger = logger
+	if s.raft, err = raft.NewRaft(config, s.fsm, s.logs, s.boltStore, snapshots, s.transport); err != nil {
+		return fmt.Errorf("raft store: %w", err)
+	}
+
+	// Only a node without any state bootstraps; the others already know the cluster
+	var servers []raft.Server
+	for id, peer := range s.peers {
+		servers = append(servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(peer)})
+	}
+	err = s.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		return fmt.Errorf("raft store: bootstrap: %w", err)
+	}
+
+	s.workers.Add(1)
+	go s.watchLeadership()
+	return nil
+}
+
+// Users returns the user store
+func (s *RaftStore) Users() UserStore {
+	return s.users
+}
+
+// Tokens returns the token store
+func (s *RaftStore) Tokens() TokenStore {
+	return s.tokens
+}
+
+// Revocations returns the store of session and user revocations
+func (s *RaftStore) Revocations() RevocationStore {
+	return s.revocations
+}
+
+// Leader reports whether this node is currently the leader
+func (s *RaftStore) Leader() bool {
+	return s.raft != nil && s.raft.State() == raft.Leader
+}
+
+// Close leaves the cluster running without this node and releases its
+// port and files. Later changes fail.
+func (s *RaftStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		if s.raft != nil {
+			err = s.raft.Shutdown().Error()
+		}
+		if s.transport != nil {
+			s.transport.Close()
+		} else {
+			s.layer.Close()
+		}
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.connMutex.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.connMutex.Unlock()
+		s.pool.close()
+		s.workers.Wait()
+		if s.boltStore != nil {
+			if closeErr := s.boltStore.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// serve accepts connections on the node's port and hands each to Raft or
+// the forwarding handler
+func (s *RaftStore) serve() {
+	defer s.workers.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			s.route(conn)
+		}()
+	}
+}
+
+// route reads the first byte of a connection to decide where it goes. The
+// TLS handshake, which rejects peers without a certificate from the
+// cluster's CA, happens on that first read.
+func (s *RaftStore) route(conn net.Conn) {
+	var kind [1]byte
+	conn.SetReadDeadline(time.Now().Add(s.applyTimeout))
+	if _, err := io.ReadFull(conn, kind[:]); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	switch kind[0] {
+	case raftStreamByte:
+		select {
+		case s.layer.conns <- conn:
+		case <-s.layer.closed:
+			conn.Close()
+		}
+	case forwardStreamByte:
+		s.serveForward(conn)
+	default:
+		conn.Close()
+	}
+}
+
+// serveForward answers the requests forwarded by another node on one
+// connection, until it goes idle or the store closes
+func (s *RaftStore) serveForward(conn net.Conn) {
+	defer conn.Close()
+	s.connMutex.Lock()
+	select {
+	case <-s.closing:
+		s.connMutex.Unlock()
+		return
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	s.connMutex.Unlock()
+	defer func() {
+		s.connMutex.Lock()
+		delete(s.conns, conn)
+		s.connMutex.Unlock()
+	}()
+
+	decoder, encoder := json.NewDecoder(conn), json.NewEncoder(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(forwardIdleTimeout))
+		var request forwardRequest
+		if err := decoder.Decode(&request); err != nil {
+			return
+		}
+
+		var response forwardResponse
+		var err error
+		switch {
+		case request.Kind == forwardApply && request.Command != nil:
+			response.Index, response.Result, err = s.applyLocal(*request.Command)
+		case request.Kind == forwardRead:
+			response.Index, err = s.readIndexLocal()
+		default:
+			err = fmt.Errorf("raft store: unknown request %q", request.Kind)
+		}
+		if errors.Is(err, errNotApplied) {
+			response.NotLeader = true
+		} else if err != nil {
+			response.Error = err.Error()
+		}
+		conn.SetWriteDeadline(time.Now().Add(s.applyTimeout))
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+// forward sends a request to the leader, reusing an idle connection if there
+// is one. Requests that certainly didn't reach a leader fail with
+// errNotApplied.
+func (s *RaftStore) forward(ctx context.Context, request forwardRequest) (forwardResponse, error) {
+	address, _ := s.raft.LeaderWithID()
+	if address == "" {
+		return forwardResponse{}, errNotApplied
+	}
+	conn := s.pool.get(string(address))
+	if conn == nil {
+		var err error
+		if conn, err = s.dialForward(ctx, string(address)); err != nil {
+			return forwardResponse{}, errNotApplied
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// A request that couldn't be written in full was never read
+	if err := conn.encoder.Encode(request); err != nil {
+		conn.Close()
+		return forwardResponse{}, errNotApplied
+	}
+	var response forwardResponse
+	if err := conn.decoder.Decode(&response); err != nil {
+		conn.Close()
+		return forwardResponse{}, fmt.Errorf("raft store: forward to leader: %w", err)
+	}
+	s.pool.put(conn)
+	if response.NotLeader {
+		return forwardResponse{}, errNotApplied
+	}
+	if response.Error != "" {
+		return forwardResponse{}, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+// dialForward opens a forwarding connection to the leader at address
+func (s *RaftStore) dialForward(ctx context.Context, address string) (*forwardConn, error) {
+	dialer := &tls.Dialer{Config: s.tls}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{forwardStreamByte}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &forwardConn{
+		Conn:    conn,
+		address: address,
+		encoder: json.NewEncoder(conn),
+		decoder: json.NewDecoder(conn),
+	}, nil
+}
+
+// forwardConn is a connection forwarding requests to a leader
+type forwardConn struct {
+	net.Conn
+	address   string
+	encoder   *json.Encoder
+	decoder   *json.Decoder
+	idleSince time.Time
+}
+
+// forwardPool keeps idle forwarding connections for reuse, by leader address
+type forwardPool struct {
+	mutex  sync.Mutex
+	idle   map[string][]*forwardConn // oldest first
+	closed bool
+}
+
+// get takes the most recently used idle connection to address, if any,
+// closing those idle for too long to reuse
+func (p *forwardPool) get(address string) *forwardConn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	conns := p.idle[address]
+	for len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(conn.idleSince) < forwardReuseWithin {
+			p.idle[address] = conns
+			return conn
+		}
+		conn.Close()
+	}
+	delete(p.idle, address)
+	return nil
+}
+
+// put returns a connection to the pool, or closes it if the pool is full or
+// closed
+func (p *forwardPool) put(conn *forwardConn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed || len(p.idle[conn.address]) >= forwardPoolSize {
+		conn.Close()
+		return
+	}
+	conn.idleSince = time.Now()
+	p.idle[conn.address] = append(p.idle[conn.address], conn)
+}
+
+// close closes the idle connections; connections put back later are closed
+func (p *forwardPool) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	for _, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	p.idle = nil
+}
+
+// applyLocal logs a command on this node, which must be the leader, and
+// returns its index and result once applied
+func (s *RaftStore) applyLocal(cmd raftCommand) (uint64, raftResult, error) {
+	if s.raft.State() != raft.Leader {
+		return 0, raftResult{}, errNotApplied
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return 0, raftResult{}, fmt.Errorf("raft store: %w", err)
+	}
+
+	future := s.raft.Apply(data, s.applyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrEnqueueTimeout) {
+			return 0, raftResult{}, errNotApplied
+		}
+		return 0, raftResult{}, fmt.Errorf("raft store: apply: %w", err)
+	}
+	result, _ := future.Response().(raftResult)
+	return future.Index(), result, nil
+}
+
+// readIndexLocal confirms this node is still the leader and returns an
+// index covering every change committed before the call
+func (s *RaftStore) readIndexLocal() (uint64, error) {
+	if s.raft.State() != raft.Leader {
+		return 0, errNotApplied
+	}
+	// A round of heartbeats confirms that no newer leader has committed
+	// anything this node hasn't seen
+	if err := s.raft.VerifyLeader().Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return 0, errNotApplied
+		}
+		return 0, fmt.Errorf("raft store: verify leader: %w", err)
+	}
+	if index, ok := s.committedCommand(); ok {
+		return index, nil
+	}
+
+	// Right after an election the commit index may not yet cover changes
+	// committed under the previous leader. A barrier commits only under a
+	// current leader, and returns once every change before it is applied.
+	if err := s.raft.Barrier(s.applyTimeout).Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return 0, errNotApplied
+		}
+		return 0, fmt.Errorf("raft store: barrier: %w", err)
+	}
+	return s.fsm.appliedIndex(), nil
+}
+
+// committedCommand returns the index of the last command committed, which is
+// what nodes count the changes they've applied by. It reports false unless
+// the entry at the commit index is from the newest term in the log: only
+// then does the commit index cover every change committed by earlier
+// leaders. It also reports false if the entries it needs have been compacted
+// away.
+func (s *RaftStore) committedCommand() (uint64, bool) {
+	var last, entry raft.Log
+	if err := s.logs.GetLog(s.raft.LastIndex(), &last); err != nil {
+		return 0, false
+	}
+	commit := s.raft.CommitIndex()
+	for index := commit; index > 0; index-- {
+		if err := s.logs.GetLog(index, &entry); err != nil {
+			return 0, false
+		}
+		if index == commit && entry.Term != last.Term {
+			return 0, false
+		}
+		// Other entries, such as a new leader's no-op, don't change the stores
+		if entry.Type == raft.LogCommand {
+			return index, true
+		}
+	}
+	return 0, true
+}
+
+// apply makes a change through the leader and waits until this node has
+// applied it, returning the change's result
+func (s *RaftStore) apply(ctx context.Context, cmd raftCommand) (raftResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.applyTimeout)
+	defer cancel()
+
+	return s.retry(ctx, func() (uint64, raftResult, error) {
+		if s.raft.State() == raft.Leader {
+			return s.applyLocal(cmd)
+		}
+		response, err := s.forward(ctx, forwardRequest{Kind: forwardApply, Command: &cmd})
+		return response.Index, response.Result, err
+	})
+}
+
+// linearizableRead waits until this node has applied every change committed
+// before the call, so a local read that follows sees them
+func (s *RaftStore) linearizableRead(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.applyTimeout)
+	defer cancel()
+
+	_, err := s.retry(ctx, func() (uint64, raftResult, error) {
+		if s.raft.State() == raft.Leader {
+			index, err := s.readIndexLocal()
+			return index, raftResult{}, err
+		}
+		response, err := s.forward(ctx, forwardRequest{Kind: forwardRead})
+		return response.Index, raftResult{}, err
+	})
+	return err
+}
+
+// retry runs attempt until it reaches a leader, then waits for its index to
+// be applied locally and returns its result
+func (s *RaftStore) retry(ctx context.Context, attempt func() (uint64, raftResult, error)) (raftResult, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return raftResult{}, err
+		}
+		index, result, err := attempt()
+		if errors.Is(err, errNotApplied) {
+			select {
+			case <-time.After(raftRetryInterval):
+				continue
+			case <-ctx.Done():
+				return raftResult{}, fmt.Errorf("%w: %w", errNotApplied, ctx.Err())
+			case <-s.closing:
+				return raftResult{}, errRaftLayerClosed
+			}
+		}
+		if err != nil {
+			return raftResult{}, err
+		}
+		if err := s.fsm.waitApplied(ctx, index); err != nil {
+			return raftResult{}, err
+		}
+		return result, result.Error.err()
+	}
+}
+
+// watchLeadership brings the membership in line with the configured peers
+// each time this node becomes leader
+func (s *RaftStore) watchLeadership() {
+	defer s.workers.Done()
+	leaderCh := s.raft.LeaderCh()
+	for {
+		select {
+		case leader := <-leaderCh:
+			if leader {
+				if err := s.reconcileMembership(); err != nil {
+					log.Printf("raft store: reconcile membership: %v", err)
+				}
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// reconcileMembership adds configured peers missing from the cluster, or
+// whose address changed, and removes members no longer configured
+func (s *RaftStore) reconcileMembership() error {
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	members := make(map[raft.ServerID]raft.ServerAddress)
+	for _, server := range future.Configuration().Servers {
+		members[server.ID] = server.Address
+	}
+
+	for id, address := range s.peers {
+		if members[raft.ServerID(id)] == raft.ServerAddress(address) {
+			continue
+		}
+		if err := s.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(address), 0, s.applyTimeout).Error(); err != nil {
+			return fmt.Errorf("add %s: %w", id, err)
+		}
+	}
+	for id := range members {
+		if _, configured := s.peers[string(id)]; configured || id == s.nodeID {
+			continue
+		}
+		if err := s.raft.RemoveServer(id, 0, s.applyTimeout).Error(); err != nil {
+			return fmt.Errorf("remove %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// raftUserStore implements UserStore on a RaftStore. Passwords are hashed
+// and checked on the node handling the request; only the results go through
+// the log.
+type raftUserStore struct {
+	store *RaftStore
+	local *InMemoryUserStore
+}
+
+// Create adds a new user to the store
+func (u *raftUserStore) Create(ctx context.Context, email, password string) (models.User, error) {
+	user, err := u.local.newUser(ctx, email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := u.applyUser(ctx, opCreateUser, user, ""); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// GetByID retrieves a user by ID from this node's copy, catching up with the
+// leader first if the user isn't there yet
+func (u *raftUserStore) GetByID(ctx context.Context, id string) (models.User, error) {
+	user, err := u.local.GetByID(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		if err := u.store.linearizableRead(ctx); err != nil {
+			return models.User{}, err
+		}
+		return u.local.GetByID(ctx, id)
+	}
+	return user, err
+}
+
+// GetByEmail retrieves a user by email from this node's copy, catching up
+// with the leader first if the user isn't there yet
+func (u *raftUserStore) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	user, err := u.local.GetByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		if err := u.store.linearizableRead(ctx); err != nil {
+			return models.User{}, err
+		}
+		return u.local.GetByEmail(ctx, email)
+	}
+	return user, err
+}
+
+// Update replaces an existing user's stored record
+func (u *raftUserStore) Update(ctx context.Context, user models.User) error {
+	email, err := u.local.emails.Normalize(user.Email)
+	if err != nil {
+		return newError(ErrInvalid, models.ErrInvalidEmail)
+	}
+	user.Email = email
+	return u.applyUser(ctx, opUpdateUser, user, "")
+}
+
+// Import adds a user whose password was hashed elsewhere
+func (u *raftUserStore) Import(ctx context.Context, user models.User) (models.User, error) {
+	user, err := u.local.importedUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := u.applyUser(ctx, opCreateUser, user, ""); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// Provision returns the user with email that provider created, creating it
+// through the log if there is no user with that email yet
+func (u *raftUserStore) Provision(ctx context.Context, email, password, provider string) (models.User, error) {
+	existing, err := u.GetByEmail(ctx, email)
+	if err == nil {
+		return provisionedBy(existing, provider)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return models.User{}, err
+	}
+	user, err := u.local.newUser(ctx, email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.ProvisionedBy = provider
+	err = u.applyUser(ctx, opCreateUser, user, "")
+	if errors.Is(err, ErrConflict) {
+		// A concurrent sign-in may have provisioned the user first
+		if existing, err := u.local.GetByEmail(ctx, email); err == nil {
+			return provisionedBy(existing, provider)
+		}
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// SetPassword replaces a user's password, checking it against the user's
+// recent passwords as of the latest committed change
+func (u *raftUserStore) SetPassword(ctx context.Context, userID, password string, historySize int) error {
+	if err := u.store.linearizableRead(ctx); err != nil {
+		return err
+	}
+	previous, updated, err := u.local.newPassword(ctx, userID, password, historySize)
+	if err != nil {
+		return err
+	}
+	return u.applyUser(ctx, opSetPassword, updated, previous.Password)
+}
+
+// SetManagedRoles replaces the roles a user is granted by source as of the
+// latest committed change
+func (u *raftUserStore) SetManagedRoles(ctx context.Context, userID, source string, roles []string) (models.User, error) {
+	command := raftCommand{Op: opSetManagedRoles, UserID: userID, Source: source, Roles: roles}
+	if _, err := u.store.apply(ctx, command); err != nil {
+		return models.User{}, err
+	}
+	return u.local.GetByID(ctx, userID)
+}
+
+// Authenticate verifies user credentials as of the latest committed change,
+// upgrading outdated hashes through the log
+func (u *raftUserStore) Authenticate(ctx context.Context, email, password string) (models.User, error) {
+	if err := u.store.linearizableRead(ctx); err != nil {
+		return models.User{}, err
+	}
+	user, rehash, err := u.local.verify(ctx, email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// Upgrade the hash, unless the password was changed in the meantime
+	if rehash {
+		if upgraded, err := u.local.rehashed(user, password); err == nil {
+			if err := u.applyUser(ctx, opRehashPassword, upgraded, user.Password); err == nil {
+				user = upgraded
+			}
+		}
+	}
+	return user, nil
+}
+
+// applyUser makes a change to a user through the log, encoding the user as
+// it is persisted
+func (u *raftUserStore) applyUser(ctx context.Context, op string, user models.User, expected string) error {
+	record, err := u.store.fsm.codec.encode(user)
+	if err != nil {
+		return err
+	}
+	_, err = u.store.apply(ctx, raftCommand{Op: op, User: record, Expected: expected})
+	return err
+}
+
+// raftTokenStore implements TokenStore on a RaftStore
+type raftTokenStore struct {
+	store *RaftStore
+	local *InMemoryTokenStore
+}
+
+// StoreRefreshToken stores a refresh token with associated userID
+func (t *raftTokenStore) StoreRefreshToken(ctx context.Context, token, userID string) error {
+	_, err := t.store.apply(ctx, raftCommand{Op: opStoreRefreshToken, Token: token, UserID: userID})
+	return err
+}
+
+// GetUserIDByRefreshToken retrieves the userID associated with a refresh
+// token from this node's copy, catching up with the leader first if the
+// token isn't there yet
+func (t *raftTokenStore) GetUserIDByRefreshToken(ctx context.Context, token string) (string, error) {
+	userID, err := t.local.GetUserIDByRefreshToken(ctx, token)
+	if errors.Is(err, ErrNotFound) {
+		if err := t.store.linearizableRead(ctx); err != nil {
+			return "", err
+		}
+		return t.local.GetUserIDByRefreshToken(ctx, token)
+	}
+	return userID, err
+}
+
+// DeleteRefreshToken removes a refresh token from the store
+func (t *raftTokenStore) DeleteRefreshToken(ctx context.Context, token string) error {
+	_, err := t.store.apply(ctx, raftCommand{Op: opDeleteRefreshToken, Token: token})
+	return err
+}
+
+// ConsumeRefreshToken removes a refresh token and returns its userID and
+// derived access tokens. The leader orders concurrent calls, so a refresh
+// token can be rotated at most once across the cluster.
+func (t *raftTokenStore) ConsumeRefreshToken(ctx context.Context, token string) (string, []string, error) {
+	result, err := t.store.apply(ctx, raftCommand{Op: opConsumeRefreshToken, Token: token})
+	if err != nil {
+		return "", nil, err
+	}
+	return result.UserID, result.Derived, nil
+}
+
+// AddDerivedAccessToken records an access token issued together with a refresh token
+func (t *raftTokenStore) AddDerivedAccessToken(ctx context.Context, refreshToken, accessToken string) error {
+	_, err := t.store.apply(ctx, raftCommand{Op: opAddDerivedAccessToken, Token: refreshToken, AccessToken: accessToken})
+	return err
+}
+
+// GetDerivedAccessTokens returns the access tokens issued together with a
+// refresh token, as of the latest committed change
+func (t *raftTokenStore) GetDerivedAccessTokens(ctx context.Context, refreshToken string) ([]string, error) {
+	if err := t.store.linearizableRead(ctx); err != nil {
+		return nil, err
+	}
+	return t.local.GetDerivedAccessTokens(ctx, refreshToken)
+}
+
+// IsTokenRevoked checks if a token has been revoked, after catching up with
+// every revocation committed before the call
+func (t *raftTokenStore) IsTokenRevoked(ctx context.Context, token string) (bool, error) {
+	if err := t.store.linearizableRead(ctx); err != nil {
+		return false, err
+	}
+	return t.local.IsTokenRevoked(ctx, token)
+}
+
+// RevokeToken adds a token to the revoked list until RevocationTTL passes
+func (t *raftTokenStore) RevokeToken(ctx context.Context, token string) error {
+	cmd := raftCommand{Op: opRevokeToken, Token: token}
+	// Every node keeps the revocation until the same time
+	if expiresAt := t.local.revocationExpiry(time.Now()); !expiresAt.IsZero() {
+		cmd.ExpiresAt = &expiresAt
+	}
+	_, err := t.store.apply(ctx, cmd)
+	return err
+}
+
+// raftRevocationStore implements RevocationStore on a RaftStore. Every node
+// applies each revocation to its copy, so watchers on every node hear of it.
+type raftRevocationStore struct {
+	store *RaftStore
+	local *InMemoryRevocationStore
+}
+
+// PutRevocation records a revocation under key until expiresAt on every node
+func (r *raftRevocationStore) PutRevocation(ctx context.Context, key string, record []byte, expiresAt time.Time) error {
+	_, err := r.store.apply(ctx, raftCommand{Op: opPutRevocation, Key: key, Revocation: record, ExpiresAt: &expiresAt})
+	return err
+}
+
+// Revocations returns the records that haven't expired, after catching up
+// with every revocation committed before the call
+func (r *raftRevocationStore) Revocations(ctx context.Context) ([][]byte, error) {
+	if err := r.store.linearizableRead(ctx); err != nil {
+		return nil, err
+	}
+	return r.local.Revocations(ctx)
+}
+
+// WatchRevocations registers put and reset to be called as this node
+// applies changes
+func (r *raftRevocationStore) WatchRevocations(put func(record []byte), reset func()) func() {
+	return r.local.WatchRevocations(put, reset)
+}
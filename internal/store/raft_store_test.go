@@ -0,0 +1,360 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/internal/store/storetest"
+)
+
+// testCA issues certificates for the nodes of a test cluster
+type testCA struct {
+	t           *testing.T
+	key         *ecdsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cluster CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA certificate: %v", err)
+	}
+	return &testCA{t: t, key: key, certificate: certificate}
+}
+
+// tlsConfig issues a node certificate for 127.0.0.1, writes it to PEM files
+// and loads them with LoadRaftTLS
+func (ca *testCA) tlsConfig() *tls.Config {
+	t := ca.t
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]*pem.Block{
+		"node.pem":     {Type: "CERTIFICATE", Bytes: der},
+		"node-key.pem": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+		"ca.pem":       {Type: "CERTIFICATE", Bytes: ca.certificate.Raw},
+	}
+	for name, block := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	config, err := store.LoadRaftTLS(filepath.Join(dir, "node.pem"), filepath.Join(dir, "node-key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatalf("LoadRaftTLS: %v", err)
+	}
+	return config
+}
+
+// testCluster is a Raft cluster of nodes on loopback ports
+type testCluster struct {
+	t     *testing.T
+	ca    *testCA
+	peers map[string]string
+	nodes map[string]*store.RaftStore
+}
+
+// newTestCluster starts size nodes keeping revocations for revocationTTL,
+// and waits for a leader
+func newTestCluster(t *testing.T, size int, revocationTTL time.Duration) *testCluster {
+	c := &testCluster{
+		t:     t,
+		ca:    newTestCA(t),
+		peers: make(map[string]string),
+		nodes: make(map[string]*store.RaftStore),
+	}
+	for i := 0; i < size; i++ {
+		c.peers[string(rune('a'+i))] = freeAddress(t)
+	}
+	for id := range c.peers {
+		node, err := store.OpenRaftStore(store.RaftConfig{
+			NodeID:        id,
+			Dir:           t.TempDir(),
+			Peers:         c.peers,
+			RevocationTTL: revocationTTL,
+			TLS:           c.ca.tlsConfig(),
+		}, newHasher(), emailaddr.NewNormalizer(true))
+		if err != nil {
+			t.Fatalf("OpenRaftStore(%s): %v", id, err)
+		}
+		c.nodes[id] = node
+		t.Cleanup(func() { node.Close() })
+	}
+	c.leader()
+	return c
+}
+
+// freeAddress returns a loopback address nothing is listening on
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// leader waits until one of the running nodes leads the cluster
+func (c *testCluster) leader() (string, *store.RaftStore) {
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		for id, node := range c.nodes {
+			if node.Leader() {
+				return id, node
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.t.Fatal("no leader elected")
+	return "", nil
+}
+
+// follower returns a running node other than the leader
+func (c *testCluster) follower() *store.RaftStore {
+	leaderID, _ := c.leader()
+	for id, node := range c.nodes {
+		if id != leaderID {
+			return node
+		}
+	}
+	c.t.Fatal("no follower running")
+	return nil
+}
+
+// stop closes a node, leaving the others running
+func (c *testCluster) stop(id string) {
+	if err := c.nodes[id].Close(); err != nil {
+		c.t.Errorf("Close(%s): %v", id, err)
+	}
+	delete(c.nodes, id)
+}
+
+func TestRaftStore(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a Raft cluster")
+	}
+	c := newTestCluster(t, 3, time.Hour)
+	_, leader := c.leader()
+	nodes := map[string]*store.RaftStore{"leader": leader, "follower": c.follower()}
+
+	// The suites' cases use fresh emails and tokens, so they share the cluster
+	for name, node := range nodes {
+		t.Run(name, func(t *testing.T) {
+			storetest.TestUserStore(t, func(t *testing.T) store.UserStore {
+				return node.Users()
+			})
+			storetest.TestTokenStore(t, func(t *testing.T) store.TokenStore {
+				return node.Tokens()
+			})
+		})
+	}
+}
+
+func TestRaftStoreRevocationExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a Raft cluster")
+	}
+	storetest.TestRevocationExpiry(t, func(t *testing.T, revocationTTL time.Duration) (store.TokenStore, func(time.Duration)) {
+		c := newTestCluster(t, 1, revocationTTL)
+		_, leader := c.leader()
+		return leader.Tokens(), time.Sleep
+	})
+}
+
+func TestRaftStoreFailover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a Raft cluster")
+	}
+	c := newTestCluster(t, 3, time.Hour)
+	ctx := context.Background()
+	email := uuid.New().String() + "@example.com"
+	accessToken, refreshToken := uuid.New().String(), uuid.New().String()
+
+	// Make changes through a follower, then lose the leader
+	follower := c.follower()
+	user, err := follower.Users().Create(ctx, email, "Correct-Horse-Battery-9")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := follower.Tokens().StoreRefreshToken(ctx, refreshToken, user.ID); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if err := follower.Tokens().RevokeToken(ctx, accessToken); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	oldLeader, _ := c.leader()
+	c.stop(oldLeader)
+
+	// The remaining nodes elect a new leader and keep every change
+	newLeader, _ := c.leader()
+	if newLeader == oldLeader {
+		t.Fatalf("node %s still leads after closing", oldLeader)
+	}
+	for id, node := range c.nodes {
+		if got, err := node.Users().Authenticate(ctx, email, "Correct-Horse-Battery-9"); err != nil || got.ID != user.ID {
+			t.Errorf("node %s: Authenticate: got %+v, %v; want user %s", id, got, err, user.ID)
+		}
+		if revoked, err := node.Tokens().IsTokenRevoked(ctx, accessToken); err != nil || !revoked {
+			t.Errorf("node %s: IsTokenRevoked: got %v, %v; want true", id, revoked, err)
+		}
+	}
+
+	// Both nodes still take changes, and a refresh token is consumed only once
+	var consumed int
+	for id, node := range c.nodes {
+		if _, _, err := node.Tokens().ConsumeRefreshToken(ctx, refreshToken); err == nil {
+			consumed++
+		} else if !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("node %s: ConsumeRefreshToken: %v", id, err)
+		}
+		if err := node.Tokens().RevokeToken(ctx, uuid.New().String()); err != nil {
+			t.Errorf("node %s: RevokeToken: %v", id, err)
+		}
+	}
+	if consumed != 1 {
+		t.Errorf("refresh token consumed %d times, want 1", consumed)
+	}
+}
+
+func TestRaftStoreRejectsUnauthenticatedPeers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a Raft cluster")
+	}
+	c := newTestCluster(t, 1, time.Hour)
+	address := c.peers["a"]
+	outsider := newTestCA(t).tlsConfig()
+	anonymous := &tls.Config{RootCAs: x509.NewCertPool()}
+	anonymous.RootCAs.AddCert(c.ca.certificate)
+
+	dials := map[string]func() (net.Conn, error){
+		"plain TCP": func() (net.Conn, error) {
+			return net.Dial("tcp", address)
+		},
+		"no client certificate": func() (net.Conn, error) {
+			return tls.Dial("tcp", address, anonymous)
+		},
+		"certificate from another CA": func() (net.Conn, error) {
+			config := outsider.Clone()
+			config.InsecureSkipVerify = true
+			return tls.Dial("tcp", address, config)
+		},
+	}
+	for name, dial := range dials {
+		t.Run(name, func(t *testing.T) {
+			conn, err := dial()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			// Ask for a read index as a forwarding node would; the node may
+			// send a TLS alert, but never an answer
+			if _, err := conn.Write([]byte("F{\"kind\":\"read\"}\n")); err != nil {
+				return
+			}
+			if response, _ := io.ReadAll(conn); bytes.Contains(response, []byte(`"index"`)) {
+				t.Errorf("got response %q", response)
+			}
+		})
+	}
+}
+
+func TestOpenRaftStoreRequiresTLS(t *testing.T) {
+	_, err := store.OpenRaftStore(store.RaftConfig{
+		NodeID: "a",
+		Dir:    t.TempDir(),
+		Peers:  map[string]string{"a": freeAddress(t)},
+	}, newHasher(), emailaddr.NewNormalizer(true))
+	if err == nil {
+		t.Error("OpenRaftStore without TLS settings succeeded")
+	}
+}
+
+func TestRaftStoreReplicatesRevocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a Raft cluster")
+	}
+	c := newTestCluster(t, 3, time.Hour)
+	ctx := context.Background()
+	follower := c.follower()
+	received := make(chan []byte, 1)
+	unwatch := follower.Revocations().WatchRevocations(func(record []byte) {
+		received <- record
+	}, func() {})
+	defer unwatch()
+
+	// A revocation put through the leader reaches the follower's watchers
+	_, leader := c.leader()
+	record := []byte(`{"kind":"session","subject":"session-1"}`)
+	if err := leader.Revocations().PutRevocation(ctx, "session:session-1", record, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PutRevocation: %v", err)
+	}
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, record) {
+			t.Errorf("watcher got %s, want %s", got, record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower's watcher never heard of the revocation")
+	}
+	records, err := follower.Revocations().Revocations(ctx)
+	if err != nil || len(records) != 1 || !bytes.Equal(records[0], record) {
+		t.Errorf("Revocations: got %s, %v; want [%s]", records, err, record)
+	}
+}
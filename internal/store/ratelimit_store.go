@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// RateLimitStore defines the interface for rate-limit counters. Implementations
+// backed by shared storage let several replicas enforce the same limits.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy models.RateLimitPolicy) (models.RateLimitResult, error)
+}
+
+// tokenBucket holds the state of a single rate-limit bucket
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// InMemoryRateLimitStore implements RateLimitStore with in-memory token buckets
+type InMemoryRateLimitStore struct {
+	buckets      map[string]*tokenBucket
+	lastSweep    time.Time
+	bucketsMutex sync.Mutex
+}
+
+// NewInMemoryRateLimitStore creates a new instance of InMemoryRateLimitStore
+func NewInMemoryRateLimitStore() *InMemoryRateLimitStore {
+	return &InMemoryRateLimitStore{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Take removes a token from the bucket for key, refilling it first for the
+// time elapsed since it was last used
+func (s *InMemoryRateLimitStore) Take(ctx context.Context, key string, policy models.RateLimitPolicy) (models.RateLimitResult, error) {
+	s.bucketsMutex.Lock()
+	defer s.bucketsMutex.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	limit := float64(policy.Limit)
+	bucket, exists := s.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: limit, updated: now, period: policy.Period}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(limit, bucket.tokens+now.Sub(bucket.updated).Seconds()*refillRate(policy))
+	bucket.updated = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return bucketResult(policy, allowed, bucket.tokens), nil
+}
+
+// refillRate returns how many tokens per second a policy's buckets regain
+func refillRate(policy models.RateLimitPolicy) float64 {
+	return float64(policy.Limit) / policy.Period.Seconds()
+}
+
+// bucketResult describes a bucket left with tokens after a take that was
+// allowed or denied
+func bucketResult(policy models.RateLimitPolicy, allowed bool, tokens float64) models.RateLimitResult {
+	rate := refillRate(policy)
+	result := models.RateLimitResult{
+		Allowed:   allowed,
+		Limit:     policy.Limit,
+		Remaining: int(tokens),
+		Reset:     secondsToDuration((float64(policy.Limit) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return result
+}
+
+// sweep drops buckets that have refilled completely, at most once a minute;
+// callers must hold the mutex
+func (s *InMemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.updated) >= bucket.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// secondsToDuration converts fractional seconds to a duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// RedisRateLimitStore implements RateLimitStore on a Redis-compatible server,
+// so that every replica draws from the same buckets
+type RedisRateLimitStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// takeScript refills the token bucket in KEYS[1] for the time since it was
+// last used and takes a token if there is one. ARGV holds the limit and the
+// period in milliseconds. Time is read on the server, so replicas with skewed
+// clocks refill buckets at the same rate. The bucket expires once it would be
+// full again. It returns whether the take was allowed and the tokens left.
+var takeScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or limit
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - updated) * limit / period)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], period)
+return {allowed, tostring(tokens)}
+`)
+
+// NewRedisRateLimitStore creates a new instance of RedisRateLimitStore. Keys
+// are namespaced with prefix, e.g. "auth:".
+func NewRedisRateLimitStore(client redis.UniversalClient, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Take removes a token from the bucket for key, refilling it first for the
+// time elapsed since it was last used
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, policy models.RateLimitPolicy) (models.RateLimitResult, error) {
+	keys := []string{s.prefix + "ratelimit:" + key}
+	reply, err := takeScript.Run(ctx, s.client, keys, policy.Limit, policy.Period.Milliseconds()).Slice()
+	if err != nil {
+		return models.RateLimitResult{}, fmt.Errorf("redis: take rate-limit token: %w", err)
+	}
+	if len(reply) != 2 {
+		return models.RateLimitResult{}, fmt.Errorf("redis: take rate-limit token: unexpected reply %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	encoded, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(encoded, 64)
+	if err != nil {
+		return models.RateLimitResult{}, fmt.Errorf("redis: take rate-limit token: %w", err)
+	}
+	return bucketResult(policy, allowed == 1, tokens), nil
+}
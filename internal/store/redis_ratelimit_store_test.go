@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/internal/store/storetest"
+)
+
+// newRedisRateLimitStore returns a store on a fresh in-process Redis server
+// whose clock only moves when the returned function is called
+func newRedisRateLimitStore(t *testing.T) (*store.RedisRateLimitStore, *miniredis.Miniredis, func(time.Duration)) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	now := time.Now()
+	server.SetTime(now)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		server.SetTime(now)
+		server.FastForward(d)
+	}
+	return store.NewRedisRateLimitStore(client, "test:"), server, advance
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	storetest.TestRateLimitStore(t, func(t *testing.T) (store.RateLimitStore, func(time.Duration)) {
+		s, _, advance := newRedisRateLimitStore(t)
+		return s, advance
+	})
+}
+
+func TestRedisRateLimitStoreExpiry(t *testing.T) {
+	s, server, advance := newRedisRateLimitStore(t)
+	policy := models.RateLimitPolicy{Name: "test", Limit: 2, Period: time.Minute}
+
+	if _, err := s.Take(context.Background(), "signin:alice", policy); err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	// Buckets are namespaced, and dropped once they would be full again
+	if ttl := server.TTL("test:ratelimit:signin:alice"); ttl != policy.Period {
+		t.Errorf("bucket TTL %v, want %v", ttl, policy.Period)
+	}
+	advance(policy.Period)
+	if server.Exists("test:ratelimit:signin:alice") {
+		t.Error("bucket still stored after refilling")
+	}
+}
+
+func TestRedisRateLimitStoreUnavailable(t *testing.T) {
+	s, server, _ := newRedisRateLimitStore(t)
+	server.Close()
+
+	policy := models.RateLimitPolicy{Name: "test", Limit: 2, Period: time.Minute}
+	if _, err := s.Take(context.Background(), "signin:alice", policy); err == nil {
+		t.Error("Take with Redis down: got no error")
+	}
+}
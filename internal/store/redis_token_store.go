@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisTokenStore implements TokenStore on a Redis-compatible server, so that
+// several replicas share refresh tokens and revocations. Refresh tokens expire
+// after refreshTTL and revocation entries after revocationTTL, which should be
+// at least the access token lifetime.
+type RedisTokenStore struct {
+	client        redis.UniversalClient
+	prefix        string
+	refreshTTL    time.Duration
+	revocationTTL time.Duration
+}
+
+// addDerivedScript appends an access token to a refresh token's derived list,
+// giving the list the refresh token's remaining lifetime. It returns 0 when
+// the refresh token doesn't exist.
+var addDerivedScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then
+	return 0
+end
+redis.call("RPUSH", KEYS[2], ARGV[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return 1
+`)
+
+// NewRedisTokenStore creates a new instance of RedisTokenStore. Keys are
+// namespaced with prefix, e.g. "auth:".
+func NewRedisTokenStore(client redis.UniversalClient, prefix string, refreshTTL, revocationTTL time.Duration) *RedisTokenStore {
+	return &RedisTokenStore{
+		client:        client,
+		prefix:        prefix,
+		refreshTTL:    refreshTTL,
+		revocationTTL: revocationTTL,
+	}
+}
+
+// refreshKey is the key holding the userID of a refresh token
+func (s *RedisTokenStore) refreshKey(token string) string {
+	return s.prefix + "refresh:" + token
+}
+
+// derivedKey is the key holding the list of access tokens derived from a
+// refresh token
+func (s *RedisTokenStore) derivedKey(token string) string {
+	return s.prefix + "derived:" + token
+}
+
+// revokedKey is the key marking a token as revoked. Access tokens are hashed
+// to keep keys short.
+func (s *RedisTokenStore) revokedKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return s.prefix + "revoked:" + hex.EncodeToString(sum[:])
+}
+
+// StoreRefreshToken stores a refresh token with associated userID
+func (s *RedisTokenStore) StoreRefreshToken(ctx context.Context, token, userID string) error {
+	if err := s.client.Set(ctx, s.refreshKey(token), userID, s.refreshTTL).Err(); err != nil {
+		return fmt.Errorf("redis: store refresh token: %w", err)
+	}
+	return nil
+}
+
+// GetUserIDByRefreshToken retrieves the userID associated with a refresh token
+func (s *RedisTokenStore) GetUserIDByRefreshToken(ctx context.Context, token string) (string, error) {
+	userID, err := s.client.Get(ctx, s.refreshKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis: get refresh token: %w", err)
+	}
+	return userID, nil
+}
+
+// DeleteRefreshToken removes a refresh token from the store
+func (s *RedisTokenStore) DeleteRefreshToken(ctx context.Context, token string) error {
+	if err := s.client.Del(ctx, s.refreshKey(token), s.derivedKey(token)).Err(); err != nil {
+		return fmt.Errorf("redis: delete refresh token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeRefreshToken removes a refresh token and returns its userID and
+// derived access tokens. The reads and deletes run in one transaction, so a
+// refresh token can be rotated at most once.
+func (s *RedisTokenStore) ConsumeRefreshToken(ctx context.Context, token string) (string, []string, error) {
+	var userID *redis.StringCmd
+	var derived *redis.StringSliceCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		userID = pipe.GetDel(ctx, s.refreshKey(token))
+		derived = pipe.LRange(ctx, s.derivedKey(token), 0, -1)
+		pipe.Del(ctx, s.derivedKey(token))
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("redis: consume refresh token: %w", err)
+	}
+	return userID.Val(), derived.Val(), nil
+}
+
+// AddDerivedAccessToken records an access token issued together with a refresh token
+func (s *RedisTokenStore) AddDerivedAccessToken(ctx context.Context, refreshToken, accessToken string) error {
+	keys := []string{s.refreshKey(refreshToken), s.derivedKey(refreshToken)}
+	added, err := addDerivedScript.Run(ctx, s.client, keys, accessToken).Int()
+	if err != nil {
+		return fmt.Errorf("redis: add derived access token: %w", err)
+	}
+	if added == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDerivedAccessTokens returns the access tokens issued together with a refresh token
+func (s *RedisTokenStore) GetDerivedAccessTokens(ctx context.Context, refreshToken string) ([]string, error) {
+	tokens, err := s.client.LRange(ctx, s.derivedKey(refreshToken), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: get derived access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// IsTokenRevoked checks if a token has been revoked
+func (s *RedisTokenStore) IsTokenRevoked(ctx context.Context, token string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.revokedKey(token)).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis: check revocation: %w", err)
+	}
+	return n > 0, nil
+}
+
+// RevokeToken adds a token to the revoked list until revocationTTL passes
+func (s *RedisTokenStore) RevokeToken(ctx context.Context, token string) error {
+	if err := s.client.Set(ctx, s.revokedKey(token), 1, s.revocationTTL).Err(); err != nil {
+		return fmt.Errorf("redis: revoke token: %w", err)
+	}
+	return nil
+}
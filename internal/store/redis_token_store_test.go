@@ -0,0 +1,102 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/internal/store/storetest"
+)
+
+const refreshTTL = time.Hour
+
+// newRedisTokenStore returns a store on a fresh in-process Redis server
+func newRedisTokenStore(t *testing.T, revocationTTL time.Duration) (*store.RedisTokenStore, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return store.NewRedisTokenStore(client, "test:", refreshTTL, revocationTTL), server
+}
+
+func TestRedisTokenStore(t *testing.T) {
+	storetest.TestTokenStore(t, func(t *testing.T) store.TokenStore {
+		s, _ := newRedisTokenStore(t, time.Hour)
+		return s
+	})
+	storetest.TestRevocationExpiry(t, func(t *testing.T, revocationTTL time.Duration) (store.TokenStore, func(time.Duration)) {
+		s, server := newRedisTokenStore(t, revocationTTL)
+		return s, server.FastForward
+	})
+}
+
+func TestRedisTokenStoreRefreshTTL(t *testing.T) {
+	s, server := newRedisTokenStore(t, time.Hour)
+	ctx := context.Background()
+
+	if err := s.StoreRefreshToken(ctx, "refresh-1", "user-1"); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if err := s.AddDerivedAccessToken(ctx, "refresh-1", "access-1"); err != nil {
+		t.Fatalf("AddDerivedAccessToken: %v", err)
+	}
+	// Keys are namespaced, and the derived list lives as long as its refresh token
+	if ttl := server.TTL("test:refresh:refresh-1"); ttl != refreshTTL {
+		t.Errorf("refresh token TTL %v, want %v", ttl, refreshTTL)
+	}
+	if ttl := server.TTL("test:derived:refresh-1"); ttl <= 0 || ttl > refreshTTL {
+		t.Errorf("derived list TTL %v, want at most %v", ttl, refreshTTL)
+	}
+
+	server.FastForward(refreshTTL)
+	if _, err := s.GetUserIDByRefreshToken(ctx, "refresh-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetUserIDByRefreshToken(expired): got error %v, want %v", err, store.ErrNotFound)
+	}
+	if derived, err := s.GetDerivedAccessTokens(ctx, "refresh-1"); err != nil || len(derived) != 0 {
+		t.Errorf("GetDerivedAccessTokens(expired): got %v, %v; want none", derived, err)
+	}
+	if err := s.AddDerivedAccessToken(ctx, "refresh-1", "access-2"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("AddDerivedAccessToken(expired): got error %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func TestRedisTokenStoreConnectionFailure(t *testing.T) {
+	s, server := newRedisTokenStore(t, time.Hour)
+	ctx := context.Background()
+	if err := s.RevokeToken(ctx, "access-1"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	server.Close()
+
+	// Every operation reports the failure rather than an answer; in
+	// particular a token is never taken to be unrevoked
+	if revoked, err := s.IsTokenRevoked(ctx, "access-1"); err == nil {
+		t.Errorf("IsTokenRevoked: got %v with no error", revoked)
+	}
+	operations := map[string]func() error{
+		"StoreRefreshToken": func() error { return s.StoreRefreshToken(ctx, "refresh-1", "user-1") },
+		"GetUserIDByRefreshToken": func() error {
+			_, err := s.GetUserIDByRefreshToken(ctx, "refresh-1")
+			return err
+		},
+		"DeleteRefreshToken": func() error { return s.DeleteRefreshToken(ctx, "refresh-1") },
+		"ConsumeRefreshToken": func() error {
+			_, _, err := s.ConsumeRefreshToken(ctx, "refresh-1")
+			return err
+		},
+		"AddDerivedAccessToken": func() error { return s.AddDerivedAccessToken(ctx, "refresh-1", "access-2") },
+		"GetDerivedAccessTokens": func() error {
+			_, err := s.GetDerivedAccessTokens(ctx, "refresh-1")
+			return err
+		},
+		"RevokeToken": func() error { return s.RevokeToken(ctx, "access-2") },
+	}
+	for name, operation := range operations {
+		if err := operation(); err == nil || errors.Is(err, store.ErrNotFound) {
+			t.Errorf("%s: got error %v, want a connection error", name, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayStore defines the interface for remembering one-time identifiers,
+// such as SAML assertion IDs, until they expire
+type ReplayStore interface {
+	MarkUsed(id string, expiresAt time.Time) bool
+}
+
+// InMemoryReplayStore implements ReplayStore with in-memory storage
+type InMemoryReplayStore struct {
+	used      map[string]time.Time // id -> expiry
+	expiries  expiryQueue
+	usedMutex sync.Mutex
+}
+
+// NewInMemoryReplayStore creates a new instance of InMemoryReplayStore
+func NewInMemoryReplayStore() *InMemoryReplayStore {
+	return &InMemoryReplayStore{
+		used: make(map[string]time.Time),
+	}
+}
+
+// MarkUsed records id as used until expiresAt. It returns false if id has
+// already been used and hasn't expired yet.
+func (s *InMemoryReplayStore) MarkUsed(id string, expiresAt time.Time) bool {
+	s.usedMutex.Lock()
+	defer s.usedMutex.Unlock()
+
+	// Forget expired identifiers so the map doesn't grow without bound
+	now := time.Now()
+	s.expiries.expire(now, func(usedID string) {
+		if expiry, exists := s.used[usedID]; exists && now.After(expiry) {
+			delete(s.used, usedID)
+		}
+	})
+
+	if expiry, used := s.used[id]; used && !now.After(expiry) {
+		return false
+	}
+	s.used[id] = expiresAt
+	s.expiries.add(id, expiresAt)
+	return true
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// RevocationStore defines the interface for keeping revocations of sessions
+// and users. Records are opaque to the store; each is kept under a key,
+// replacing any earlier record with that key, until it expires.
+type RevocationStore interface {
+	PutRevocation(ctx context.Context, key string, record []byte, expiresAt time.Time) error
+	Revocations(ctx context.Context) ([][]byte, error)
+	// WatchRevocations calls put with each record put from now on, on this
+	// node or another, and reset whenever the records are replaced
+	// wholesale, such as by a snapshot from the leader
+	WatchRevocations(put func(record []byte), reset func()) (unwatch func())
+}
+
+// revocationRecord is a revocation and when it may be forgotten
+type revocationRecord struct {
+	Record    json.RawMessage `json:"record"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// revocationWatcher is a caller of WatchRevocations
+type revocationWatcher struct {
+	put   func(record []byte)
+	reset func()
+}
+
+// InMemoryRevocationStore implements RevocationStore with in-memory storage
+type InMemoryRevocationStore struct {
+	records          map[string]revocationRecord // key -> revocation
+	expiries         expiryQueue
+	revocationsMutex sync.RWMutex
+
+	watchers    map[int]revocationWatcher
+	nextWatcher int
+	watchMutex  sync.Mutex
+
+	// journal, if set, is called with each change while the lock is held,
+	// before the change is applied; an error cancels it
+	journal func(walRecord) error
+}
+
+// NewInMemoryRevocationStore creates a new instance of InMemoryRevocationStore
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		records:  make(map[string]revocationRecord),
+		watchers: make(map[int]revocationWatcher),
+	}
+}
+
+// PutRevocation records a revocation under key until expiresAt and tells
+// the watchers
+func (s *InMemoryRevocationStore) PutRevocation(ctx context.Context, key string, record []byte, expiresAt time.Time) error {
+	s.revocationsMutex.Lock()
+	if s.journal != nil {
+		err := s.journal(walRecord{Op: opPutRevocation, Key: key, Revocation: record, ExpiresAt: &expiresAt})
+		if err != nil {
+			s.revocationsMutex.Unlock()
+			return err
+		}
+	}
+	s.put(key, record, expiresAt, time.Now())
+	s.revocationsMutex.Unlock()
+
+	for _, watcher := range s.watching() {
+		watcher.put(record)
+	}
+	return nil
+}
+
+// put records a revocation, forgetting those that have expired by now;
+// callers must hold the write lock
+func (s *InMemoryRevocationStore) put(key string, record []byte, expiresAt, now time.Time) {
+	s.expiries.expire(now, func(key string) {
+		if existing, exists := s.records[key]; exists && now.After(existing.ExpiresAt) {
+			delete(s.records, key)
+		}
+	})
+	s.records[key] = revocationRecord{Record: record, ExpiresAt: expiresAt}
+	s.expiries.add(key, expiresAt)
+}
+
+// Revocations returns the records that haven't expired
+func (s *InMemoryRevocationStore) Revocations(ctx context.Context) ([][]byte, error) {
+	s.revocationsMutex.RLock()
+	defer s.revocationsMutex.RUnlock()
+
+	now := time.Now()
+	records := make([][]byte, 0, len(s.records))
+	for _, revocation := range s.records {
+		if !now.After(revocation.ExpiresAt) {
+			records = append(records, revocation.Record)
+		}
+	}
+	return records, nil
+}
+
+// WatchRevocations registers put and reset to be called as records change
+func (s *InMemoryRevocationStore) WatchRevocations(put func(record []byte), reset func()) func() {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	id := s.nextWatcher
+	s.nextWatcher++
+	s.watchers[id] = revocationWatcher{put: put, reset: reset}
+	return func() {
+		s.watchMutex.Lock()
+		defer s.watchMutex.Unlock()
+		delete(s.watchers, id)
+	}
+}
+
+// watching returns the current watchers, so they can be called without
+// holding any lock
+func (s *InMemoryRevocationStore) watching() []revocationWatcher {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+	watchers := make([]revocationWatcher, 0, len(s.watchers))
+	for _, watcher := range s.watchers {
+		watchers = append(watchers, watcher)
+	}
+	return watchers
+}
+
+// capture copies the records that haven't expired; callers must hold the
+// read lock
+func (s *InMemoryRevocationStore) capture(now time.Time) map[string]revocationRecord {
+	records := make(map[string]revocationRecord, len(s.records))
+	for key, revocation := range s.records {
+		if now.Before(revocation.ExpiresAt) {
+			records[key] = revocation
+		}
+	}
+	return records
+}
+
+// restore replaces the records and tells the watchers to reload them
+func (s *InMemoryRevocationStore) restore(records map[string]revocationRecord) {
+	s.revocationsMutex.Lock()
+	s.records = make(map[string]revocationRecord, len(records))
+	s.expiries = nil
+	for key, revocation := range records {
+		s.records[key] = revocation
+		s.expiries.add(key, revocation.ExpiresAt)
+	}
+	s.revocationsMutex.Unlock()
+
+	for _, watcher := range s.watching() {
+		watcher.reset()
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+)
+
+// FieldCipher encrypts the personal fields of persisted user records and
+// computes the blind index emails are matched by
+type FieldCipher interface {
+	Encrypt(plaintext, scope string) (string, error)
+	Decrypt(ciphertext, scope string) (string, error)
+	BlindIndex(value string) string
+}
+
+// userRecord is the persisted form of a user, including the fields hidden
+// from API responses
+type userRecord struct {
+	ID                string              `json:"id"`
+	Email             string              `json:"email"`
+	EmailIndex        string              `json:"email_index,omitempty"` // set when Email is encrypted
+	Password          string              `json:"password"`
+	Roles             []string            `json:"roles,omitempty"`
+	CreatedAt         time.Time           `json:"created_at"`
+	PasswordHistory   []string            `json:"password_history,omitempty"`
+	PasswordChangedAt time.Time           `json:"password_changed_at"`
+	ProvisionedBy     string              `json:"provisioned_by,omitempty"`
+	ManagedRoles      map[string][]string `json:"managed_roles,omitempty"`
+}
+
+// newUserRecord converts a user to its persisted form
+func newUserRecord(user models.User) *userRecord {
+	return &userRecord{
+		ID:                user.ID,
+		Email:             user.Email,
+		Password:          user.Password,
+		Roles:             user.Roles,
+		CreatedAt:         user.CreatedAt,
+		PasswordHistory:   user.PasswordHistory,
+		PasswordChangedAt: user.PasswordChangedAt,
+		ProvisionedBy:     user.ProvisionedBy,
+		ManagedRoles:      user.ManagedRoles,
+	}
+}
+
+// user converts a persisted user back to the model
+func (r *userRecord) user() models.User {
+	return models.User{
+		ID:                r.ID,
+		Email:             r.Email,
+		Password:          r.Password,
+		Roles:             r.Roles,
+		CreatedAt:         r.CreatedAt,
+		PasswordHistory:   r.PasswordHistory,
+		PasswordChangedAt: r.PasswordChangedAt,
+		ProvisionedBy:     r.ProvisionedBy,
+		ManagedRoles:      r.ManagedRoles,
+	}
+}
+
+// recordCodec converts users to and from their persisted form, encrypting
+// their personal fields if it has a cipher
+type recordCodec struct {
+	cipher FieldCipher
+	emails *emailaddr.Normalizer
+}
+
+// encode converts a user to its persisted form
+func (c recordCodec) encode(user models.User) (*userRecord, error) {
+	record := newUserRecord(user)
+	if c.cipher == nil {
+		return record, nil
+	}
+	email, err := c.cipher.Encrypt(user.Email, "user:"+user.ID+":email")
+	if err != nil {
+		return nil, err
+	}
+	record.Email, record.EmailIndex = email, c.cipher.BlindIndex(c.emails.Key(user.Email))
+	return record, nil
+}
+
+// decode converts a persisted user back to the model. Records written before
+// encryption was enabled are read as they are.
+func (c recordCodec) decode(record *userRecord) (models.User, error) {
+	user := record.user()
+	if record.EmailIndex == "" {
+		return user, nil
+	}
+	if c.cipher == nil {
+		return models.User{}, fmt.Errorf("user %s is encrypted but no keyring is configured", record.ID)
+	}
+	email, err := c.cipher.Decrypt(record.Email, "user:"+record.ID+":email")
+	if err != nil {
+		return models.User{}, fmt.Errorf("user %s: %w", record.ID, err)
+	}
+	if c.cipher.BlindIndex(c.emails.Key(email)) != record.EmailIndex {
+		return models.User{}, fmt.Errorf("user %s: email doesn't match its index", record.ID)
+	}
+	user.Email = email
+	return user, nil
+}
+
+// snapshotState is the full contents of a snapshot file
+type snapshotState struct {
+	Users         []*userRecord       `json:"users"`
+	RefreshTokens map[string]string   `json:"refresh_tokens"`
+	DerivedTokens map[string][]string `json:"derived_tokens"`
+	RevokedTokens []string            `json:"revoked_tokens"` // revoked forever
+	// Revocations that may be forgotten, token -> when
+	ExpiringRevocations map[string]time.Time `json:"expiring_revocations,omitempty"`
+	// Revocations of sessions and users, key -> revocation
+	Revocations map[string]revocationRecord `json:"revocations,omitempty"`
+}
+
+// captureState copies the contents of the stores, leaving out revocations
+// that have passed; callers must hold their read locks
+func captureState(codec recordCodec, users *InMemoryUserStore, tokens *InMemoryTokenStore, revocations *InMemoryRevocationStore) (snapshotState, error) {
+	state := snapshotState{
+		Users:               make([]*userRecord, 0, len(users.users)),
+		RefreshTokens:       make(map[string]string),
+		DerivedTokens:       make(map[string][]string),
+		RevokedTokens:       make([]string, 0),
+		ExpiringRevocations: make(map[string]time.Time),
+	}
+	now := time.Now()
+	for _, user := range users.users {
+		record, err := codec.encode(user)
+		if err != nil {
+			return snapshotState{}, err
+		}
+		state.Users = append(state.Users, record)
+	}
+	for i := range tokens.shards {
+		shard := &tokens.shards[i]
+		for token, userID := range shard.refreshTokens {
+			state.RefreshTokens[token] = userID
+		}
+		for token, derived := range shard.derivedTokens {
+			state.DerivedTokens[token] = append([]string(nil), derived...)
+		}
+		for token, expiresAt := range shard.revokedTokens {
+			switch {
+			case expiresAt.IsZero():
+				state.RevokedTokens = append(state.RevokedTokens, token)
+			case now.Before(expiresAt):
+				state.ExpiringRevocations[token] = expiresAt
+			}
+		}
+	}
+	state.Revocations = revocations.capture(now)
+	return state, nil
+}
+
+// restoreState replaces the contents of the stores with a captured state. If
+// a user can't be decoded, the stores are left as they were.
+func restoreState(codec recordCodec, users *InMemoryUserStore, tokens *InMemoryTokenStore, revocations *InMemoryRevocationStore, state snapshotState) error {
+	decoded := make([]models.User, 0, len(state.Users))
+	for _, record := range state.Users {
+		user, err := codec.decode(record)
+		if err != nil {
+			return err
+		}
+		decoded = append(decoded, user)
+	}
+
+	users.usersMutex.Lock()
+	users.users = make(map[string]models.User, len(decoded))
+	users.emailIndex = make(map[string]string, len(decoded))
+	for _, user := range decoded {
+		users.users[user.ID] = user
+		users.emailIndex[users.emailKey(user.Email)] = user.ID
+	}
+	users.usersMutex.Unlock()
+
+	tokens.lockAll()
+	for i := range tokens.shards {
+		tokens.shards[i].reset()
+	}
+	for token, userID := range state.RefreshTokens {
+		tokens.shard(token).refreshTokens[token] = userID
+	}
+	for token, derived := range state.DerivedTokens {
+		tokens.shard(token).derivedTokens[token] = derived
+	}
+	for _, token := range state.RevokedTokens {
+		tokens.shard(token).revoke(token, time.Time{})
+	}
+	for token, expiresAt := range state.ExpiringRevocations {
+		tokens.shard(token).revoke(token, expiresAt)
+	}
+	tokens.unlockAll()
+
+	revocations.restore(state.Revocations)
+	return nil
+}
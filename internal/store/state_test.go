@@ -0,0 +1,86 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/pii"
+)
+
+// newTestKeyring creates a keyring wrapped by a FileKMS
+func newTestKeyring(t *testing.T) *pii.Keyring {
+	kms, err := pii.NewFileKMS(map[int][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("NewFileKMS: %v", err)
+	}
+	keyring, err := pii.CreateKeyring(context.Background(), filepath.Join(t.TempDir(), "keyring.json"), kms)
+	if err != nil {
+		t.Fatalf("CreateKeyring: %v", err)
+	}
+	return keyring
+}
+
+func TestRecordCodecRoundTrip(t *testing.T) {
+	user := models.User{
+		ID:                "user-1",
+		Email:             "alice@example.com",
+		Password:          "hash",
+		Roles:             []string{"admin"},
+		CreatedAt:         time.Now().UTC().Truncate(time.Second),
+		PasswordChangedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	emails := emailaddr.NewNormalizer(false)
+	encrypting := recordCodec{cipher: newTestKeyring(t), emails: emails}
+
+	record, err := encrypting.encode(user)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if record.EmailIndex == "" || strings.Contains(record.Email, "alice") || strings.Contains(record.EmailIndex, "alice") {
+		t.Errorf("encoded email %q, index %q; want both hidden", record.Email, record.EmailIndex)
+	}
+	decoded, err := encrypting.decode(record)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, user) {
+		t.Errorf("decode: got %+v, want %+v", decoded, user)
+	}
+
+	// An encrypted record can't be read without the keyring, or as another user
+	if _, err := (recordCodec{emails: emails}).decode(record); err == nil {
+		t.Error("decode without a cipher succeeded")
+	}
+	moved := *record
+	moved.ID = "user-2"
+	if _, err := encrypting.decode(&moved); err == nil {
+		t.Error("decode of an email moved to another user succeeded")
+	}
+
+	// The email must match the index the record is found by
+	other, err := encrypting.encode(models.User{ID: user.ID, Email: "bob@example.com"})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	swapped := *record
+	swapped.EmailIndex = other.EmailIndex
+	if _, err := encrypting.decode(&swapped); err == nil {
+		t.Error("decode of an email with another email's index succeeded")
+	}
+
+	// Records written before encryption was enabled are read as they are
+	plain, err := (recordCodec{emails: emails}).encode(user)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if decoded, err := encrypting.decode(plain); err != nil || !reflect.DeepEqual(decoded, user) {
+		t.Errorf("decode plaintext record: got %+v, %v; want %+v", decoded, err, user)
+	}
+}
@@ -1,5 +1,10 @@
 package store
 
+import (
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/password"
+)
+
 // Store defines the interface for all storage operations
 // This can be extended in the future to include other data stores
 type Store interface {
@@ -14,10 +19,10 @@ type InMemoryStore struct {
 }
 
 // NewInMemoryStore creates a new instance of InMemoryStore
-func NewInMemoryStore() *InMemoryStore {
+func NewInMemoryStore(hasher *password.Hasher, emails *emailaddr.Normalizer) *InMemoryStore {
 	return &InMemoryStore{
-		userStore:  NewInMemoryUserStore(),
-		tokenStore: NewInMemoryTokenStore(),
+		userStore:  NewInMemoryUserStore(hasher, emails),
+		tokenStore: NewInMemoryTokenStore(0),
 	}
 }
 
@@ -0,0 +1,259 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/password"
+	"github.com/sanskarm98/auth-service/internal/pii"
+	"github.com/sanskarm98/auth-service/internal/store"
+	"github.com/sanskarm98/auth-service/internal/store/storetest"
+)
+
+// newHasher returns a hasher cheap enough for the suites
+func newHasher() *password.Hasher {
+	return password.NewHasher(nil, password.NewBcrypt(4))
+}
+
+// openFileStore opens a FileStore in a fresh directory, compacting often so
+// the suites run across snapshots too
+func openFileStore(t *testing.T, revocationTTL time.Duration) *store.FileStore {
+	f, err := store.OpenFileStore(t.TempDir(), newHasher(), emailaddr.NewNormalizer(true), 16, revocationTTL, nil)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestInMemoryUserStore(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T) store.UserStore {
+		return store.NewInMemoryUserStore(newHasher(), emailaddr.NewNormalizer(true))
+	})
+}
+
+func TestInMemoryTokenStore(t *testing.T) {
+	storetest.TestTokenStore(t, func(t *testing.T) store.TokenStore {
+		return store.NewInMemoryTokenStore(time.Hour)
+	})
+	storetest.TestRevocationExpiry(t, func(t *testing.T, revocationTTL time.Duration) (store.TokenStore, func(time.Duration)) {
+		return store.NewInMemoryTokenStore(revocationTTL), time.Sleep
+	})
+}
+
+func TestInMemoryRateLimitStore(t *testing.T) {
+	storetest.TestRateLimitStore(t, func(t *testing.T) (store.RateLimitStore, func(time.Duration)) {
+		return store.NewInMemoryRateLimitStore(), time.Sleep
+	})
+}
+
+func TestFileStoreUsers(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T) store.UserStore {
+		return openFileStore(t, time.Hour).Users()
+	})
+}
+
+// openKeyring creates a keyring in a fresh directory, wrapped by a FileKMS
+func openKeyring(t *testing.T) *pii.Keyring {
+	kms, err := pii.NewFileKMS(map[int][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("NewFileKMS: %v", err)
+	}
+	keyring, err := pii.CreateKeyring(context.Background(), filepath.Join(t.TempDir(), "keyring.json"), kms)
+	if err != nil {
+		t.Fatalf("CreateKeyring: %v", err)
+	}
+	return keyring
+}
+
+func TestFileStoreEncryptedUsers(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T) store.UserStore {
+		f, err := store.OpenFileStore(t.TempDir(), newHasher(), emailaddr.NewNormalizer(true), 16, time.Hour, openKeyring(t))
+		if err != nil {
+			t.Fatalf("OpenFileStore: %v", err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f.Users()
+	})
+}
+
+func TestFileStoreTokens(t *testing.T) {
+	storetest.TestTokenStore(t, func(t *testing.T) store.TokenStore {
+		return openFileStore(t, time.Hour).Tokens()
+	})
+	storetest.TestRevocationExpiry(t, func(t *testing.T, revocationTTL time.Duration) (store.TokenStore, func(time.Duration)) {
+		return openFileStore(t, revocationTTL).Tokens(), time.Sleep
+	})
+}
+
+// reopenFileStore opens the FileStore in dir, compacting rarely enough that
+// a test's records all stay in the log
+func reopenFileStore(t *testing.T, dir string) *store.FileStore {
+	t.Helper()
+	f, err := store.OpenFileStore(dir, newHasher(), emailaddr.NewNormalizer(true), 1000, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	return f
+}
+
+// logSegments returns the paths of the log segments in dir, oldest first
+func logSegments(t *testing.T, dir string) []string {
+	t.Helper()
+	segments, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	sort.Strings(segments)
+	return segments
+}
+
+func TestFileStoreReplaysLog(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	const newPassword = "Another-Horse-Battery-7"
+
+	f := reopenFileStore(t, dir)
+	alice, err := f.Users().Create(ctx, "alice@example.com", "Correct-Horse-Battery-9")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := f.Users().SetPassword(ctx, alice.ID, newPassword, 0); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if err := f.Tokens().StoreRefreshToken(ctx, "refresh-1", alice.ID); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if err := f.Tokens().RevokeToken(ctx, "access-1"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if snapshots, _ := filepath.Glob(filepath.Join(dir, "snapshot-*")); len(snapshots) != 0 {
+		t.Fatalf("snapshots %v written, want the state only in the log", snapshots)
+	}
+
+	// Everything is rebuilt from the log alone
+	f = reopenFileStore(t, dir)
+	defer f.Close()
+	if _, err := f.Users().Authenticate(ctx, "alice@example.com", newPassword); err != nil {
+		t.Errorf("Authenticate(changed password) after reopen: %v", err)
+	}
+	if user, err := f.Users().GetByID(ctx, alice.ID); err != nil || user.Email != alice.Email {
+		t.Errorf("GetByID after reopen: %+v, %v", user, err)
+	}
+	if userID, err := f.Tokens().GetUserIDByRefreshToken(ctx, "refresh-1"); err != nil || userID != alice.ID {
+		t.Errorf("GetUserIDByRefreshToken after reopen: %q, %v; want %q", userID, err, alice.ID)
+	}
+	if revoked, err := f.Tokens().IsTokenRevoked(ctx, "access-1"); err != nil || !revoked {
+		t.Errorf("IsTokenRevoked after reopen: %v, %v; want true", revoked, err)
+	}
+}
+
+func TestFileStoreDiscardsTornRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f := reopenFileStore(t, dir)
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		if _, err := f.Users().Create(ctx, email, "Correct-Horse-Battery-9"); err != nil {
+			t.Fatalf("Create(%q): %v", email, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Cut the last record short, as a crash in the middle of a write would
+	segments := logSegments(t, dir)
+	last := segments[len(segments)-1]
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if err := os.Truncate(last, info.Size()-5); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	// Earlier records survive, and the torn one is dropped
+	f = reopenFileStore(t, dir)
+	if _, err := f.Users().GetByEmail(ctx, "alice@example.com"); err != nil {
+		t.Errorf("GetByEmail(before torn record): %v", err)
+	}
+	if _, err := f.Users().GetByEmail(ctx, "bob@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetByEmail(torn record): got error %v, want %v", err, store.ErrNotFound)
+	}
+
+	// The log is cut back to the last whole record, so new records follow it
+	if _, err := f.Users().Create(ctx, "carol@example.com", "Correct-Horse-Battery-9"); err != nil {
+		t.Fatalf("Create after reopen: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	f = reopenFileStore(t, dir)
+	defer f.Close()
+	for _, email := range []string{"alice@example.com", "carol@example.com"} {
+		if _, err := f.Users().GetByEmail(ctx, email); err != nil {
+			t.Errorf("GetByEmail(%q) after second reopen: %v", email, err)
+		}
+	}
+}
+
+func TestFileStoreKeysEmailsByBlindIndex(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyring := openKeyring(t)
+	open := func() *store.FileStore {
+		f, err := store.OpenFileStore(dir, newHasher(), emailaddr.NewNormalizer(true), 2, time.Hour, keyring)
+		if err != nil {
+			t.Fatalf("OpenFileStore: %v", err)
+		}
+		return f
+	}
+
+	f := open()
+	alice, err := f.Users().Create(ctx, "alice@example.com", "Correct-Horse-Battery-9")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for _, email := range []string{"bob@example.com", "carol@example.com"} {
+		if _, err := f.Users().Create(ctx, email, "Correct-Horse-Battery-9"); err != nil {
+			t.Fatalf("Create(%q): %v", email, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Neither the log nor the snapshots hold the email in plaintext
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if bytes.Contains(data, []byte("alice")) {
+			t.Errorf("%s contains the email in plaintext", filepath.Base(file))
+		}
+	}
+
+	// Reopened, users are still found by any spelling of their email, and
+	// the index still guards against duplicates
+	f = open()
+	defer f.Close()
+	if user, err := f.Users().GetByEmail(ctx, " Alice@Example.com"); err != nil || user.ID != alice.ID {
+		t.Errorf("GetByEmail after reopen: %+v, %v; want %s", user, err, alice.ID)
+	}
+	if _, err := f.Users().Create(ctx, "ALICE@example.com", "Correct-Horse-Battery-9"); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("Create(taken email) after reopen: got %v, want %v", err, store.ErrConflict)
+	}
+}
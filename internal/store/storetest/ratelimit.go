@@ -0,0 +1,130 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// RateLimitStoreFactory returns an empty rate-limit store for one test, and a
+// function that lets d pass on the store's clock
+type RateLimitStoreFactory func(t *testing.T) (s store.RateLimitStore, advance func(d time.Duration))
+
+// testPolicy allows a burst of three requests, refilled one every 100ms
+var testPolicy = models.RateLimitPolicy{Name: "test", Limit: 3, Period: 300 * time.Millisecond}
+
+// refillMargin is added to waits for a token, so that one is available however
+// the store rounds elapsed time
+const refillMargin = 20 * time.Millisecond
+
+// TestRateLimitStore runs the rate-limit store conformance suite against
+// stores created by newStore
+func TestRateLimitStore(t *testing.T, newStore RateLimitStoreFactory) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s store.RateLimitStore, advance func(time.Duration))
+	}{
+		{"Burst", testBurst},
+		{"Refill", testRefill},
+		{"IndependentKeys", testIndependentKeys},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, advance := newStore(t)
+			c.run(t, s, advance)
+		})
+	}
+}
+
+// take takes a token for key or fails the test
+func take(t *testing.T, s store.RateLimitStore, key string) models.RateLimitResult {
+	t.Helper()
+	result, err := s.Take(context.Background(), key, testPolicy)
+	if err != nil {
+		t.Fatalf("Take(%q): %v", key, err)
+	}
+	if result.Limit != testPolicy.Limit {
+		t.Errorf("Take(%q): limit %d, want %d", key, result.Limit, testPolicy.Limit)
+	}
+	return result
+}
+
+// drain takes every token left for key
+func drain(t *testing.T, s store.RateLimitStore, key string) {
+	t.Helper()
+	for i := 0; i < testPolicy.Limit; i++ {
+		if result := take(t, s, key); !result.Allowed {
+			return
+		}
+	}
+}
+
+func testBurst(t *testing.T, s store.RateLimitStore, _ func(time.Duration)) {
+	key := uuid.New().String()
+	interval := testPolicy.Period / time.Duration(testPolicy.Limit)
+
+	// A new bucket is full, and each request takes one token
+	for want := testPolicy.Limit - 1; want >= 0; want-- {
+		result := take(t, s, key)
+		if !result.Allowed || result.Remaining != want {
+			t.Fatalf("Take: allowed %v, remaining %d; want allowed with %d remaining", result.Allowed, result.Remaining, want)
+		}
+		if result.Reset <= 0 || result.Reset > testPolicy.Period {
+			t.Errorf("Take: reset %v, want within (0, %v]", result.Reset, testPolicy.Period)
+		}
+	}
+
+	// Once empty, requests are denied until the next token is due
+	result := take(t, s, key)
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Take(empty): allowed %v, remaining %d; want denied with none remaining", result.Allowed, result.Remaining)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > interval {
+		t.Errorf("Take(empty): retry after %v, want within (0, %v]", result.RetryAfter, interval)
+	}
+	if result.Reset <= testPolicy.Period-interval || result.Reset > testPolicy.Period {
+		t.Errorf("Take(empty): reset %v, want within (%v, %v]", result.Reset, testPolicy.Period-interval, testPolicy.Period)
+	}
+}
+
+func testRefill(t *testing.T, s store.RateLimitStore, advance func(time.Duration)) {
+	key := uuid.New().String()
+	interval := testPolicy.Period / time.Duration(testPolicy.Limit)
+	drain(t, s, key)
+
+	// Tokens come back one interval at a time
+	advance(interval + refillMargin)
+	if result := take(t, s, key); !result.Allowed {
+		t.Errorf("Take(after one interval): denied, retry after %v", result.RetryAfter)
+	}
+	if result := take(t, s, key); result.Allowed {
+		t.Error("Take(second after one interval): allowed, want denied")
+	}
+
+	// An idle bucket refills to the limit, but not beyond it
+	advance(2 * testPolicy.Period)
+	for i := 0; i < testPolicy.Limit; i++ {
+		if result := take(t, s, key); !result.Allowed {
+			t.Fatalf("Take %d after refill: denied", i+1)
+		}
+	}
+	if result := take(t, s, key); result.Allowed {
+		t.Error("Take(beyond limit after refill): allowed, want denied")
+	}
+}
+
+func testIndependentKeys(t *testing.T, s store.RateLimitStore, _ func(time.Duration)) {
+	key, other := uuid.New().String(), uuid.New().String()
+	drain(t, s, key)
+
+	if result := take(t, s, other); !result.Allowed || result.Remaining != testPolicy.Limit-1 {
+		t.Errorf("Take(other key): allowed %v, remaining %d; want allowed with %d remaining", result.Allowed, result.Remaining, testPolicy.Limit-1)
+	}
+	if result := take(t, s, key); result.Allowed {
+		t.Error("Take(drained key): allowed, want denied")
+	}
+}
@@ -0,0 +1,310 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// TokenStoreFactory returns an empty token store for one test
+type TokenStoreFactory func(t *testing.T) store.TokenStore
+
+// TestTokenStore runs the token store conformance suite against stores
+// created by newStore
+func TestTokenStore(t *testing.T, newStore TokenStoreFactory) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s store.TokenStore)
+	}{
+		{"RefreshTokenLifecycle", testRefreshTokenLifecycle},
+		{"DerivedAccessTokens", testDerivedAccessTokens},
+		{"ConsumeRefreshToken", testConsumeRefreshToken},
+		{"ConcurrentConsume", testConcurrentConsume},
+		{"Revocation", testRevocation},
+		{"ConcurrentAccess", testConcurrentTokenAccess},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+// ExpiringTokenStoreFactory returns an empty token store for one test that
+// keeps revocations for revocationTTL, and a function that lets d pass on the
+// store's clock
+type ExpiringTokenStoreFactory func(t *testing.T, revocationTTL time.Duration) (s store.TokenStore, advance func(d time.Duration))
+
+// revocationTTL is how long TestRevocationExpiry's stores keep revocations;
+// long enough that a revocation doesn't expire before it is checked
+const revocationTTL = 200 * time.Millisecond
+
+// TestRevocationExpiry checks that a store created by newStore forgets
+// revocations once their TTL has passed, and only then
+func TestRevocationExpiry(t *testing.T, newStore ExpiringTokenStoreFactory) {
+	t.Run("RevocationExpiry", func(t *testing.T) {
+		s, advance := newStore(t, revocationTTL)
+		testRevocationExpiry(t, s, advance)
+	})
+}
+
+func testRevocationExpiry(t *testing.T, s store.TokenStore, advance func(d time.Duration)) {
+	ctx := context.Background()
+	token, refreshToken, userID := newToken(), newToken(), newToken()
+
+	if err := s.RevokeToken(ctx, token); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if err := s.StoreRefreshToken(ctx, refreshToken, userID); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if revoked, err := s.IsTokenRevoked(ctx, token); err != nil || !revoked {
+		t.Fatalf("IsTokenRevoked(before expiry): got %v, %v; want true", revoked, err)
+	}
+
+	advance(2 * revocationTTL)
+	if revoked, err := s.IsTokenRevoked(ctx, token); err != nil || revoked {
+		t.Errorf("IsTokenRevoked(after expiry): got %v, %v; want false", revoked, err)
+	}
+	// Revocation expiry leaves refresh tokens alone
+	if got, err := s.GetUserIDByRefreshToken(ctx, refreshToken); err != nil || got != userID {
+		t.Errorf("GetUserIDByRefreshToken(after expiry): got %q, %v; want %q", got, err, userID)
+	}
+
+	// An expired revocation can be made again
+	if err := s.RevokeToken(ctx, token); err != nil {
+		t.Fatalf("RevokeToken(again): %v", err)
+	}
+	if revoked, err := s.IsTokenRevoked(ctx, token); err != nil || !revoked {
+		t.Errorf("IsTokenRevoked(revoked again): got %v, %v; want true", revoked, err)
+	}
+}
+
+// newToken returns a token no other test uses
+func newToken() string {
+	return uuid.New().String()
+}
+
+func testRefreshTokenLifecycle(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	token, other, userID := newToken(), newToken(), newToken()
+
+	_, err := s.GetUserIDByRefreshToken(ctx, token)
+	wantError(t, "GetUserIDByRefreshToken(unknown)", err, store.ErrNotFound)
+
+	if err := s.StoreRefreshToken(ctx, token, userID); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if err := s.StoreRefreshToken(ctx, other, userID); err != nil {
+		t.Fatalf("StoreRefreshToken(other): %v", err)
+	}
+	if got, err := s.GetUserIDByRefreshToken(ctx, token); err != nil || got != userID {
+		t.Errorf("GetUserIDByRefreshToken: got %q, %v; want %q", got, err, userID)
+	}
+
+	// Deleting is final and leaves other tokens alone
+	if err := s.DeleteRefreshToken(ctx, token); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	_, err = s.GetUserIDByRefreshToken(ctx, token)
+	wantError(t, "GetUserIDByRefreshToken(deleted)", err, store.ErrNotFound)
+	if got, err := s.GetUserIDByRefreshToken(ctx, other); err != nil || got != userID {
+		t.Errorf("GetUserIDByRefreshToken(other): got %q, %v; want %q", got, err, userID)
+	}
+
+	// Deleting an unknown token is not a failure of the store
+	if err := s.DeleteRefreshToken(ctx, newToken()); err != nil && !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("DeleteRefreshToken(unknown): %v", err)
+	}
+}
+
+func testDerivedAccessTokens(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	refreshToken := newToken()
+	if err := s.StoreRefreshToken(ctx, refreshToken, newToken()); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+
+	derived := []string{newToken(), newToken(), newToken()}
+	for _, accessToken := range derived {
+		if err := s.AddDerivedAccessToken(ctx, refreshToken, accessToken); err != nil {
+			t.Fatalf("AddDerivedAccessToken: %v", err)
+		}
+	}
+	got, err := s.GetDerivedAccessTokens(ctx, refreshToken)
+	if err != nil || !sameTokens(got, derived) {
+		t.Errorf("GetDerivedAccessTokens: got %v, %v; want %v", got, err, derived)
+	}
+
+	// Access tokens can only be derived from a stored refresh token
+	err = s.AddDerivedAccessToken(ctx, newToken(), newToken())
+	wantError(t, "AddDerivedAccessToken(unknown)", err, store.ErrNotFound)
+
+	// Deleting the refresh token forgets its derived tokens
+	if err := s.DeleteRefreshToken(ctx, refreshToken); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	got, err = s.GetDerivedAccessTokens(ctx, refreshToken)
+	if len(got) != 0 || (err != nil && !errors.Is(err, store.ErrNotFound)) {
+		t.Errorf("GetDerivedAccessTokens(deleted): got %v, %v; want none", got, err)
+	}
+}
+
+func testConsumeRefreshToken(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	token, userID := newToken(), newToken()
+	if err := s.StoreRefreshToken(ctx, token, userID); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	derived := []string{newToken(), newToken()}
+	for _, accessToken := range derived {
+		if err := s.AddDerivedAccessToken(ctx, token, accessToken); err != nil {
+			t.Fatalf("AddDerivedAccessToken: %v", err)
+		}
+	}
+
+	gotUserID, gotDerived, err := s.ConsumeRefreshToken(ctx, token)
+	if err != nil || gotUserID != userID || !sameTokens(gotDerived, derived) {
+		t.Errorf("ConsumeRefreshToken: got %q, %v, %v; want %q, %v", gotUserID, gotDerived, err, userID, derived)
+	}
+
+	// A consumed token is gone
+	_, err = s.GetUserIDByRefreshToken(ctx, token)
+	wantError(t, "GetUserIDByRefreshToken(consumed)", err, store.ErrNotFound)
+	_, _, err = s.ConsumeRefreshToken(ctx, token)
+	wantError(t, "ConsumeRefreshToken(consumed)", err, store.ErrNotFound)
+	err = s.AddDerivedAccessToken(ctx, token, newToken())
+	wantError(t, "AddDerivedAccessToken(consumed)", err, store.ErrNotFound)
+
+	// A refresh token without derived tokens can be consumed too
+	bare := newToken()
+	if err := s.StoreRefreshToken(ctx, bare, userID); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+	if gotUserID, gotDerived, err := s.ConsumeRefreshToken(ctx, bare); err != nil || gotUserID != userID || len(gotDerived) != 0 {
+		t.Errorf("ConsumeRefreshToken(bare): got %q, %v, %v; want %q, none", gotUserID, gotDerived, err, userID)
+	}
+}
+
+func testConcurrentConsume(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	token := newToken()
+	if err := s.StoreRefreshToken(ctx, token, newToken()); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+
+	// Exactly one of several simultaneous rotations wins
+	var wg sync.WaitGroup
+	errs := make([]error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = s.ConsumeRefreshToken(ctx, token)
+		}(i)
+	}
+	wg.Wait()
+
+	consumed := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			consumed++
+		case !errors.Is(err, store.ErrNotFound):
+			t.Errorf("ConsumeRefreshToken: unexpected error %v", err)
+		}
+	}
+	if consumed != 1 {
+		t.Errorf("%d concurrent consumes succeeded, want 1", consumed)
+	}
+}
+
+func testRevocation(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	token, other := newToken(), newToken()
+
+	if revoked, err := s.IsTokenRevoked(ctx, token); err != nil || revoked {
+		t.Errorf("IsTokenRevoked(new): got %v, %v; want false", revoked, err)
+	}
+	if err := s.RevokeToken(ctx, token); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if revoked, err := s.IsTokenRevoked(ctx, token); err != nil || !revoked {
+		t.Errorf("IsTokenRevoked(revoked): got %v, %v; want true", revoked, err)
+	}
+	if revoked, err := s.IsTokenRevoked(ctx, other); err != nil || revoked {
+		t.Errorf("IsTokenRevoked(other): got %v, %v; want false", revoked, err)
+	}
+
+	// Revoking twice is harmless
+	if err := s.RevokeToken(ctx, token); err != nil {
+		t.Errorf("RevokeToken(again): %v", err)
+	}
+	if revoked, err := s.IsTokenRevoked(ctx, token); err != nil || !revoked {
+		t.Errorf("IsTokenRevoked(revoked twice): got %v, %v; want true", revoked, err)
+	}
+}
+
+func testConcurrentTokenAccess(t *testing.T, s store.TokenStore) {
+	ctx := context.Background()
+	shared := newToken()
+	if err := s.StoreRefreshToken(ctx, shared, newToken()); err != nil {
+		t.Fatalf("StoreRefreshToken: %v", err)
+	}
+
+	// Derive from a shared refresh token while issuing and revoking others
+	var wg sync.WaitGroup
+	derived := make([]string, concurrency)
+	for i := 0; i < concurrency; i++ {
+		derived[i] = fmt.Sprintf("access-%d-%s", i, newToken())
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.AddDerivedAccessToken(ctx, shared, derived[i]); err != nil {
+				t.Errorf("AddDerivedAccessToken: %v", err)
+			}
+			own := newToken()
+			if err := s.StoreRefreshToken(ctx, own, newToken()); err != nil {
+				t.Errorf("StoreRefreshToken: %v", err)
+			}
+			if _, err := s.GetUserIDByRefreshToken(ctx, own); err != nil {
+				t.Errorf("GetUserIDByRefreshToken: %v", err)
+			}
+			if err := s.RevokeToken(ctx, derived[i]); err != nil {
+				t.Errorf("RevokeToken: %v", err)
+			}
+			if err := s.DeleteRefreshToken(ctx, own); err != nil {
+				t.Errorf("DeleteRefreshToken: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// No derived token was lost, and every one was revoked
+	got, err := s.GetDerivedAccessTokens(ctx, shared)
+	if err != nil || !sameTokens(got, derived) {
+		t.Errorf("GetDerivedAccessTokens: got %d tokens, %v; want %d", len(got), err, len(derived))
+	}
+	for _, accessToken := range derived {
+		if revoked, err := s.IsTokenRevoked(ctx, accessToken); err != nil || !revoked {
+			t.Errorf("IsTokenRevoked(%s): got %v, %v; want true", accessToken, revoked, err)
+		}
+	}
+}
+
+// sameTokens reports whether a and b hold the same tokens in any order
+func sameTokens(a, b []string) bool {
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
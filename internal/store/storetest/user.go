@@ -0,0 +1,426 @@
+// Package storetest provides conformance suites for store implementations.
+// A backend proves it behaves like the in-memory stores by running the suites
+// from its own tests:
+//
+//	func TestUserStore(t *testing.T) {
+//		storetest.TestUserStore(t, func(t *testing.T) store.UserStore {
+//			return newTestBackend(t)
+//		})
+//	}
+//
+// Suites run their cases as subtests and are meant to be run with -race.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/store"
+)
+
+// UserStoreFactory returns an empty user store for one test. Stores should
+// use a cheap password hasher, since the suite hashes many passwords.
+type UserStoreFactory func(t *testing.T) store.UserStore
+
+// testPassword is the password users are created with
+const testPassword = "Correct-Horse-Battery-9"
+
+// concurrency is the number of goroutines used by the concurrency cases
+const concurrency = 16
+
+// TestUserStore runs the user store conformance suite against stores
+// created by newStore
+func TestUserStore(t *testing.T, newStore UserStoreFactory) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, s store.UserStore)
+	}{
+		{"CreateAndLookup", testCreateAndLookup},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"NotFound", testUserNotFound},
+		{"Update", testUpdate},
+		{"Authenticate", testAuthenticate},
+		{"SetPassword", testSetPassword},
+		{"SetManagedRoles", testSetManagedRoles},
+		{"Import", testImport},
+		{"Provision", testProvision},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"ConcurrentAccess", testConcurrentAccess},
+		{"CanceledContext", testCanceledContext},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+// newEmail returns an email address no other test uses
+func newEmail() string {
+	return "user-" + uuid.New().String() + "@example.com"
+}
+
+// mustCreate creates a user with testPassword or fails the test
+func mustCreate(t *testing.T, s store.UserStore, email string) models.User {
+	t.Helper()
+	user, err := s.Create(context.Background(), email, testPassword)
+	if err != nil {
+		t.Fatalf("Create(%q): %v", email, err)
+	}
+	return user
+}
+
+// wantError fails the test unless err matches target
+func wantError(t *testing.T, op string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("%s: got error %v, want %v", op, err, target)
+	}
+}
+
+func testCreateAndLookup(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	email := newEmail()
+	user := mustCreate(t, s, email)
+
+	if user.ID == "" {
+		t.Error("Create: empty user ID")
+	}
+	if user.Email != email {
+		t.Errorf("Create: email %q, want %q", user.Email, email)
+	}
+	if user.Password == "" || user.Password == testPassword {
+		t.Error("Create: password is not stored as a hash")
+	}
+	if user.CreatedAt.IsZero() {
+		t.Error("Create: zero CreatedAt")
+	}
+
+	byID, err := s.GetByID(ctx, user.ID)
+	if err != nil || byID.ID != user.ID || byID.Email != email {
+		t.Errorf("GetByID: got %+v, %v; want user %s", byID, err, user.ID)
+	}
+
+	// Lookups by email ignore case
+	byEmail, err := s.GetByEmail(ctx, strings.ToUpper(email))
+	if err != nil || byEmail.ID != user.ID {
+		t.Errorf("GetByEmail(upper case): got %+v, %v; want user %s", byEmail, err, user.ID)
+	}
+}
+
+func testDuplicateEmail(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	email := newEmail()
+	first := mustCreate(t, s, email)
+
+	for _, duplicate := range []string{email, strings.ToUpper(email), " " + email + " "} {
+		_, err := s.Create(ctx, duplicate, testPassword)
+		wantError(t, fmt.Sprintf("Create(%q)", duplicate), err, store.ErrConflict)
+	}
+
+	// The original account is untouched
+	user, err := s.GetByEmail(ctx, email)
+	if err != nil || user.ID != first.ID {
+		t.Errorf("GetByEmail after duplicates: got %+v, %v; want user %s", user, err, first.ID)
+	}
+
+	_, err = s.Create(ctx, "not an email", testPassword)
+	wantError(t, "Create(invalid email)", err, store.ErrInvalid)
+}
+
+func testUserNotFound(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+
+	_, err := s.GetByID(ctx, uuid.New().String())
+	wantError(t, "GetByID(unknown)", err, store.ErrNotFound)
+
+	_, err = s.GetByEmail(ctx, newEmail())
+	wantError(t, "GetByEmail(unknown)", err, store.ErrNotFound)
+
+	err = s.Update(ctx, models.User{ID: uuid.New().String(), Email: newEmail()})
+	wantError(t, "Update(unknown)", err, store.ErrNotFound)
+
+	err = s.SetPassword(ctx, uuid.New().String(), "Another-Horse-Battery-7", 0)
+	wantError(t, "SetPassword(unknown)", err, store.ErrNotFound)
+}
+
+func testUpdate(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	user := mustCreate(t, s, newEmail())
+	other := mustCreate(t, s, newEmail())
+
+	// Changes are persisted
+	user.Roles = []string{"admin", "auditor"}
+	if err := s.Update(ctx, user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	stored, err := s.GetByID(ctx, user.ID)
+	if err != nil || strings.Join(stored.Roles, ",") != "admin,auditor" {
+		t.Errorf("GetByID after Update: roles %v, %v; want [admin auditor]", stored.Roles, err)
+	}
+
+	// Another user's email can't be taken
+	taken := user
+	taken.Email = strings.ToUpper(other.Email)
+	wantError(t, "Update(taken email)", s.Update(ctx, taken), store.ErrConflict)
+
+	// A new email moves the lookup
+	oldEmail := user.Email
+	user.Email = newEmail()
+	if err := s.Update(ctx, user); err != nil {
+		t.Fatalf("Update(new email): %v", err)
+	}
+	if found, err := s.GetByEmail(ctx, user.Email); err != nil || found.ID != user.ID {
+		t.Errorf("GetByEmail(new email): got %+v, %v; want user %s", found, err, user.ID)
+	}
+	_, err = s.GetByEmail(ctx, oldEmail)
+	wantError(t, "GetByEmail(old email)", err, store.ErrNotFound)
+
+	// The old email is free again
+	if _, err := s.Create(ctx, oldEmail, testPassword); err != nil {
+		t.Errorf("Create(old email): %v", err)
+	}
+}
+
+func testAuthenticate(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	user := mustCreate(t, s, newEmail())
+
+	authenticated, err := s.Authenticate(ctx, strings.ToUpper(user.Email), testPassword)
+	if err != nil || authenticated.ID != user.ID {
+		t.Errorf("Authenticate: got %+v, %v; want user %s", authenticated, err, user.ID)
+	}
+
+	_, err = s.Authenticate(ctx, user.Email, "wrong password")
+	wantError(t, "Authenticate(wrong password)", err, store.ErrInvalidCredentials)
+
+	// Unknown emails look the same as wrong passwords
+	_, err = s.Authenticate(ctx, newEmail(), testPassword)
+	wantError(t, "Authenticate(unknown email)", err, store.ErrInvalidCredentials)
+}
+
+func testSetPassword(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	user := mustCreate(t, s, newEmail())
+	const newPassword = "Another-Horse-Battery-7"
+
+	// Make the change observable at timestamp resolution
+	time.Sleep(10 * time.Millisecond)
+	if err := s.SetPassword(ctx, user.ID, newPassword, 2); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+
+	if _, err := s.Authenticate(ctx, user.Email, newPassword); err != nil {
+		t.Errorf("Authenticate(new password): %v", err)
+	}
+	_, err := s.Authenticate(ctx, user.Email, testPassword)
+	wantError(t, "Authenticate(old password)", err, store.ErrInvalidCredentials)
+
+	// The change restarts the password's age, which drives expiry
+	stored, err := s.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if !stored.PasswordChangedAt.After(user.PasswordChangedAt) {
+		t.Errorf("PasswordChangedAt %v not after %v", stored.PasswordChangedAt, user.PasswordChangedAt)
+	}
+
+	// Recent passwords can't be reused, older ones can
+	wantError(t, "SetPassword(current password)", s.SetPassword(ctx, user.ID, newPassword, 2), store.ErrInvalid)
+	wantError(t, "SetPassword(previous password)", s.SetPassword(ctx, user.ID, testPassword, 2), store.ErrInvalid)
+	if err := s.SetPassword(ctx, user.ID, testPassword, 1); err != nil {
+		t.Errorf("SetPassword(previous password, history 1): %v", err)
+	}
+}
+
+func testSetManagedRoles(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	user := mustCreate(t, s, newEmail())
+	const newPassword = "Another-Horse-Battery-7"
+
+	// Roles granted here are kept alongside the managed ones
+	user.Roles = []string{"admin"}
+	if err := s.Update(ctx, user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// A password change made after the caller read the user isn't undone
+	if err := s.SetPassword(ctx, user.ID, newPassword, 0); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	updated, err := s.SetManagedRoles(ctx, user.ID, "directory", []string{"auditor", "admin"})
+	if err != nil {
+		t.Fatalf("SetManagedRoles: %v", err)
+	}
+	if got := strings.Join(updated.AllRoles(), ","); got != "admin,auditor" {
+		t.Errorf("SetManagedRoles: roles %q, want admin,auditor", got)
+	}
+	if _, err := s.Authenticate(ctx, user.Email, newPassword); err != nil {
+		t.Errorf("Authenticate(new password) after SetManagedRoles: %v", err)
+	}
+
+	// Each source only replaces its own roles
+	if _, err := s.SetManagedRoles(ctx, user.ID, "idp", []string{"billing"}); err != nil {
+		t.Fatalf("SetManagedRoles(idp): %v", err)
+	}
+	if _, err := s.SetManagedRoles(ctx, user.ID, "directory", nil); err != nil {
+		t.Fatalf("SetManagedRoles(directory, none): %v", err)
+	}
+	stored, err := s.GetByID(ctx, user.ID)
+	if got := strings.Join(stored.AllRoles(), ","); err != nil || got != "admin,billing" {
+		t.Errorf("GetByID after SetManagedRoles: roles %q, %v; want admin,billing", got, err)
+	}
+	if got := strings.Join(updated.ManagedRoles["directory"], ","); got != "auditor,admin" {
+		t.Errorf("earlier copy of the user changed: directory roles %q, want auditor,admin", got)
+	}
+
+	_, err = s.SetManagedRoles(ctx, uuid.New().String(), "directory", []string{"admin"})
+	wantError(t, "SetManagedRoles(unknown)", err, store.ErrNotFound)
+}
+
+func testImport(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	source := mustCreate(t, s, newEmail())
+
+	// Import a user with a hash the store produced itself
+	createdAt := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	imported, err := s.Import(ctx, models.User{
+		ID:        uuid.New().String(),
+		Email:     newEmail(),
+		Password:  source.Password,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !imported.CreatedAt.Equal(createdAt) {
+		t.Errorf("Import: CreatedAt %v, want %v", imported.CreatedAt, createdAt)
+	}
+	if _, err := s.Authenticate(ctx, imported.Email, testPassword); err != nil {
+		t.Errorf("Authenticate(imported): %v", err)
+	}
+
+	_, err = s.Import(ctx, models.User{Email: newEmail(), Password: "plaintext"})
+	wantError(t, "Import(unsupported hash)", err, store.ErrInvalid)
+
+	_, err = s.Import(ctx, models.User{ID: imported.ID, Email: newEmail(), Password: source.Password})
+	wantError(t, "Import(duplicate ID)", err, store.ErrConflict)
+
+	_, err = s.Import(ctx, models.User{Email: source.Email, Password: source.Password})
+	wantError(t, "Import(duplicate email)", err, store.ErrConflict)
+}
+
+func testProvision(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	email := newEmail()
+
+	provisioned, err := s.Provision(ctx, email, testPassword, "example")
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if provisioned.ProvisionedBy != "example" {
+		t.Errorf("Provision: ProvisionedBy %q, want example", provisioned.ProvisionedBy)
+	}
+	stored, err := s.GetByID(ctx, provisioned.ID)
+	if err != nil || stored.ProvisionedBy != "example" {
+		t.Errorf("GetByID after Provision: ProvisionedBy %q, %v; want example", stored.ProvisionedBy, err)
+	}
+
+	// The same provider gets the same user back
+	again, err := s.Provision(ctx, email, "Another-Horse-Battery-7", "example")
+	if err != nil || again.ID != provisioned.ID {
+		t.Errorf("Provision(again): user %q, %v; want %q", again.ID, err, provisioned.ID)
+	}
+
+	// Accounts created any other way, or by another provider, aren't adopted
+	_, err = s.Provision(ctx, email, testPassword, "other")
+	wantError(t, "Provision(other provider)", err, store.ErrConflict)
+	local := mustCreate(t, s, newEmail())
+	_, err = s.Provision(ctx, local.Email, testPassword, "example")
+	wantError(t, "Provision(local account)", err, store.ErrConflict)
+
+	_, err = s.Provision(ctx, "not an email", testPassword, "example")
+	wantError(t, "Provision(invalid email)", err, store.ErrInvalid)
+}
+
+func testConcurrentCreate(t *testing.T, s store.UserStore) {
+	email := newEmail()
+
+	// Exactly one of several simultaneous sign-ups with the same email wins
+	var wg sync.WaitGroup
+	errs := make([]error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.Create(context.Background(), email, testPassword)
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, store.ErrConflict):
+			t.Errorf("Create: unexpected error %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d concurrent creates succeeded, want 1", created)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, s store.UserStore) {
+	ctx := context.Background()
+	user := mustCreate(t, s, newEmail())
+
+	// Mix reads, writes and sign-ins to give the race detector something to find
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			own, err := s.Create(ctx, newEmail(), testPassword)
+			if err != nil {
+				t.Errorf("Create: %v", err)
+				return
+			}
+			if _, err := s.GetByEmail(ctx, own.Email); err != nil {
+				t.Errorf("GetByEmail: %v", err)
+			}
+			if _, err := s.GetByID(ctx, user.ID); err != nil {
+				t.Errorf("GetByID: %v", err)
+			}
+			if _, err := s.Authenticate(ctx, user.Email, testPassword); err != nil {
+				t.Errorf("Authenticate: %v", err)
+			}
+			own.Roles = []string{fmt.Sprintf("role-%d", i)}
+			if err := s.Update(ctx, own); err != nil {
+				t.Errorf("Update: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func testCanceledContext(t *testing.T, s store.UserStore) {
+	user := mustCreate(t, s, newEmail())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Operations that hash or verify passwords must give up on a done context
+	_, err := s.Create(ctx, newEmail(), testPassword)
+	wantError(t, "Create(canceled)", err, context.Canceled)
+
+	_, err = s.Authenticate(ctx, user.Email, testPassword)
+	wantError(t, "Authenticate(canceled)", err, context.Canceled)
+}
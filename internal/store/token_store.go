@@ -1,67 +1,270 @@
 package store
 
 import (
+	"context"
+	"hash/maphash"
 	"sync"
+	"time"
 )
 
 // TokenStore defines the interface for token operations
 type TokenStore interface {
-	StoreRefreshToken(token, userID string)
-	GetUserIDByRefreshToken(token string) (string, bool)
-	DeleteRefreshToken(token string)
-	IsTokenRevoked(token string) bool
-	RevokeToken(token string)
+	StoreRefreshToken(ctx context.Context, token, userID string) error
+	GetUserIDByRefreshToken(ctx context.Context, token string) (string, error)
+	DeleteRefreshToken(ctx context.Context, token string) error
+	ConsumeRefreshToken(ctx context.Context, token string) (string, []string, error)
+	AddDerivedAccessToken(ctx context.Context, refreshToken, accessToken string) error
+	GetDerivedAccessTokens(ctx context.Context, refreshToken string) ([]string, error)
+	IsTokenRevoked(ctx context.Context, token string) (bool, error)
+	RevokeToken(ctx context.Context, token string) error
 }
 
-// InMemoryTokenStore implements TokenStore with in-memory storage
+// tokenShardCount is how many shards an InMemoryTokenStore splits its tokens
+// across; it must be a power of two
+const tokenShardCount = 64
+
+// InMemoryTokenStore implements TokenStore with in-memory storage. Tokens are
+// hash-partitioned across shards, each with its own locks, so that requests
+// for different tokens rarely wait on each other.
 type InMemoryTokenStore struct {
-	refreshTokens     map[string]string // token -> userID
-	revokedTokens     map[string]bool   // Blacklist for revoked tokens
+	shards [tokenShardCount]tokenShard
+	seed   maphash.Seed
+
+	// How long a revocation is kept; zero keeps it forever
+	revocationTTL time.Duration
+
+	// journal, if set, is called with each change while the lock guarding
+	// the changed map is held, before the change is applied; an error
+	// cancels it
+	journal func(walRecord) error
+}
+
+// tokenShard holds the tokens hashed to one shard. A refresh token's derived
+// access tokens are kept in the refresh token's shard.
+type tokenShard struct {
+	refreshTokens     map[string]string    // token -> userID
+	derivedTokens     map[string][]string  // refresh token -> access tokens issued with it
+	revokedTokens     map[string]time.Time // token -> when its revocation may be forgotten, zero for never
+	revokedExpiries   expiryQueue
 	refreshTokenMutex sync.RWMutex
 	revokedTokenMutex sync.RWMutex
+
+	// Keeps neighbouring shards' locks off the same cache line
+	_ [64]byte
+}
+
+// NewInMemoryTokenStore creates a new instance of InMemoryTokenStore.
+// Revocations are kept for revocationTTL, which should be at least the access
+// token lifetime, or forever if it is zero.
+func NewInMemoryTokenStore(revocationTTL time.Duration) *InMemoryTokenStore {
+	s := &InMemoryTokenStore{seed: maphash.MakeSeed(), revocationTTL: revocationTTL}
+	for i := range s.shards {
+		s.shards[i].reset()
+	}
+	return s
+}
+
+// reset empties a shard; callers must hold both its locks or be its only user
+func (shard *tokenShard) reset() {
+	shard.refreshTokens = make(map[string]string)
+	shard.derivedTokens = make(map[string][]string)
+	shard.revokedTokens = make(map[string]time.Time)
+	shard.revokedExpiries = nil
+}
+
+// revoke records a revocation until expiresAt, or forever if it is zero;
+// callers must hold revokedTokenMutex
+func (shard *tokenShard) revoke(token string, expiresAt time.Time) {
+	shard.revokedTokens[token] = expiresAt
+	if !expiresAt.IsZero() {
+		shard.revokedExpiries.add(token, expiresAt)
+	}
+}
+
+// expireRevocations forgets the revocations that have passed; callers must
+// hold revokedTokenMutex
+func (shard *tokenShard) expireRevocations(now time.Time) {
+	shard.revokedExpiries.expire(now, func(token string) {
+		if expiresAt := shard.revokedTokens[token]; !expiresAt.IsZero() && !now.Before(expiresAt) {
+			delete(shard.revokedTokens, token)
+		}
+	})
+}
+
+// revokedAt reports whether a revocation that lasts until expiresAt is still
+// in force at now
+func revokedAt(expiresAt, now time.Time) bool {
+	return expiresAt.IsZero() || now.Before(expiresAt)
+}
+
+// shard returns the shard holding token
+func (s *InMemoryTokenStore) shard(token string) *tokenShard {
+	return &s.shards[maphash.String(s.seed, token)&(tokenShardCount-1)]
+}
+
+// rLockAll read-locks every shard, so the store can be copied as a whole
+func (s *InMemoryTokenStore) rLockAll() {
+	for i := range s.shards {
+		s.shards[i].refreshTokenMutex.RLock()
+		s.shards[i].revokedTokenMutex.RLock()
+	}
+}
+
+// rUnlockAll releases the locks taken by rLockAll
+func (s *InMemoryTokenStore) rUnlockAll() {
+	for i := range s.shards {
+		s.shards[i].revokedTokenMutex.RUnlock()
+		s.shards[i].refreshTokenMutex.RUnlock()
+	}
+}
+
+// lockAll write-locks every shard, so the store can be replaced as a whole
+func (s *InMemoryTokenStore) lockAll() {
+	for i := range s.shards {
+		s.shards[i].refreshTokenMutex.Lock()
+		s.shards[i].revokedTokenMutex.Lock()
+	}
 }
 
-// NewInMemoryTokenStore creates a new instance of InMemoryTokenStore
-func NewInMemoryTokenStore() *InMemoryTokenStore {
-	return &InMemoryTokenStore{
-		refreshTokens: make(map[string]string),
-		revokedTokens: make(map[string]bool),
+// unlockAll releases the locks taken by lockAll
+func (s *InMemoryTokenStore) unlockAll() {
+	for i := range s.shards {
+		s.shards[i].revokedTokenMutex.Unlock()
+		s.shards[i].refreshTokenMutex.Unlock()
 	}
 }
 
 // StoreRefreshToken stores a refresh token with associated userID
-func (s *InMemoryTokenStore) StoreRefreshToken(token, userID string) {
-	s.refreshTokenMutex.Lock()
-	defer s.refreshTokenMutex.Unlock()
-	s.refreshTokens[token] = userID
+func (s *InMemoryTokenStore) StoreRefreshToken(ctx context.Context, token, userID string) error {
+	shard := s.shard(token)
+	shard.refreshTokenMutex.Lock()
+	defer shard.refreshTokenMutex.Unlock()
+	if err := s.record(walRecord{Op: opStoreRefreshToken, Token: token, UserID: userID}); err != nil {
+		return err
+	}
+	shard.refreshTokens[token] = userID
+	return nil
 }
 
 // GetUserIDByRefreshToken retrieves the userID associated with a refresh token
-func (s *InMemoryTokenStore) GetUserIDByRefreshToken(token string) (string, bool) {
-	s.refreshTokenMutex.RLock()
-	defer s.refreshTokenMutex.RUnlock()
-	userID, exists := s.refreshTokens[token]
-	return userID, exists
+func (s *InMemoryTokenStore) GetUserIDByRefreshToken(ctx context.Context, token string) (string, error) {
+	shard := s.shard(token)
+	shard.refreshTokenMutex.RLock()
+	defer shard.refreshTokenMutex.RUnlock()
+	userID, exists := shard.refreshTokens[token]
+	if !exists {
+		return "", ErrNotFound
+	}
+	return userID, nil
 }
 
 // DeleteRefreshToken removes a refresh token from the store
-func (s *InMemoryTokenStore) DeleteRefreshToken(token string) {
-	s.refreshTokenMutex.Lock()
-	defer s.refreshTokenMutex.Unlock()
-	delete(s.refreshTokens, token)
+func (s *InMemoryTokenStore) DeleteRefreshToken(ctx context.Context, token string) error {
+	shard := s.shard(token)
+	shard.refreshTokenMutex.Lock()
+	defer shard.refreshTokenMutex.Unlock()
+	if _, exists := shard.refreshTokens[token]; !exists {
+		return nil
+	}
+	if err := s.record(walRecord{Op: opDeleteRefreshToken, Token: token}); err != nil {
+		return err
+	}
+	delete(shard.refreshTokens, token)
+	delete(shard.derivedTokens, token)
+	return nil
+}
+
+// ConsumeRefreshToken removes a refresh token and returns its userID and
+// derived access tokens. Of several concurrent calls for the same token only
+// one succeeds, so a refresh token can be rotated at most once.
+func (s *InMemoryTokenStore) ConsumeRefreshToken(ctx context.Context, token string) (string, []string, error) {
+	shard := s.shard(token)
+	shard.refreshTokenMutex.Lock()
+	defer shard.refreshTokenMutex.Unlock()
+	userID, exists := shard.refreshTokens[token]
+	if !exists {
+		return "", nil, ErrNotFound
+	}
+	if err := s.record(walRecord{Op: opDeleteRefreshToken, Token: token}); err != nil {
+		return "", nil, err
+	}
+	derived := shard.derivedTokens[token]
+	delete(shard.refreshTokens, token)
+	delete(shard.derivedTokens, token)
+	return userID, derived, nil
+}
+
+// AddDerivedAccessToken records an access token issued together with a refresh token
+func (s *InMemoryTokenStore) AddDerivedAccessToken(ctx context.Context, refreshToken, accessToken string) error {
+	shard := s.shard(refreshToken)
+	shard.refreshTokenMutex.Lock()
+	defer shard.refreshTokenMutex.Unlock()
+	if _, exists := shard.refreshTokens[refreshToken]; !exists {
+		return ErrNotFound
+	}
+	if err := s.record(walRecord{Op: opAddDerivedAccessToken, Token: refreshToken, AccessToken: accessToken}); err != nil {
+		return err
+	}
+	shard.derivedTokens[refreshToken] = append(shard.derivedTokens[refreshToken], accessToken)
+	return nil
+}
+
+// GetDerivedAccessTokens returns the access tokens issued together with a refresh token
+func (s *InMemoryTokenStore) GetDerivedAccessTokens(ctx context.Context, refreshToken string) ([]string, error) {
+	shard := s.shard(refreshToken)
+	shard.refreshTokenMutex.RLock()
+	defer shard.refreshTokenMutex.RUnlock()
+	return append([]string(nil), shard.derivedTokens[refreshToken]...), nil
 }
 
 // IsTokenRevoked checks if a token has been revoked
-func (s *InMemoryTokenStore) IsTokenRevoked(token string) bool {
-	s.revokedTokenMutex.RLock()
-	defer s.revokedTokenMutex.RUnlock()
-	_, revoked := s.revokedTokens[token]
-	return revoked
-}
-
-// RevokeToken adds a token to the revoked list
-func (s *InMemoryTokenStore) RevokeToken(token string) {
-	s.revokedTokenMutex.Lock()
-	defer s.revokedTokenMutex.Unlock()
-	s.revokedTokens[token] = true
+func (s *InMemoryTokenStore) IsTokenRevoked(ctx context.Context, token string) (bool, error) {
+	shard := s.shard(token)
+	shard.revokedTokenMutex.RLock()
+	defer shard.revokedTokenMutex.RUnlock()
+	expiresAt, revoked := shard.revokedTokens[token]
+	return revoked && revokedAt(expiresAt, time.Now()), nil
+}
+
+// RevokeToken adds a token to the revoked list until revocationTTL passes
+func (s *InMemoryTokenStore) RevokeToken(ctx context.Context, token string) error {
+	return s.revokeUntil(token, s.revocationExpiry(time.Now()))
+}
+
+// revocationExpiry returns when a revocation made at now may be forgotten
+func (s *InMemoryTokenStore) revocationExpiry(now time.Time) time.Time {
+	if s.revocationTTL <= 0 {
+		return time.Time{}
+	}
+	return now.Add(s.revocationTTL)
+}
+
+// revokeUntil adds a token to the revoked list until expiresAt, or forever if
+// it is zero. A revocation already lasting as long is left alone.
+func (s *InMemoryTokenStore) revokeUntil(token string, expiresAt time.Time) error {
+	shard := s.shard(token)
+	shard.revokedTokenMutex.Lock()
+	defer shard.revokedTokenMutex.Unlock()
+	shard.expireRevocations(time.Now())
+	if current, exists := shard.revokedTokens[token]; exists && (current.IsZero() || (!expiresAt.IsZero() && !current.Before(expiresAt))) {
+		return nil
+	}
+	rec := walRecord{Op: opRevokeToken, Token: token}
+	if !expiresAt.IsZero() {
+		rec.ExpiresAt = &expiresAt
+	}
+	if err := s.record(rec); err != nil {
+		return err
+	}
+	shard.revoke(token, expiresAt)
+	return nil
+}
+
+// record passes a change to the journal, if any; callers must hold the lock
+// guarding the changed map
+func (s *InMemoryTokenStore) record(rec walRecord) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal(rec)
 }
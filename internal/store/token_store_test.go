@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// singleLockTokenStore is the baseline the sharded store is measured
+// against: the same maps behind one pair of locks, as InMemoryTokenStore was
+// before it was sharded
+type singleLockTokenStore struct {
+	refreshTokens     map[string]string
+	revokedTokens     map[string]bool
+	refreshTokenMutex sync.RWMutex
+	revokedTokenMutex sync.RWMutex
+}
+
+func newSingleLockTokenStore() *singleLockTokenStore {
+	return &singleLockTokenStore{
+		refreshTokens: make(map[string]string),
+		revokedTokens: make(map[string]bool),
+	}
+}
+
+func (s *singleLockTokenStore) StoreRefreshToken(ctx context.Context, token, userID string) error {
+	s.refreshTokenMutex.Lock()
+	defer s.refreshTokenMutex.Unlock()
+	s.refreshTokens[token] = userID
+	return nil
+}
+
+func (s *singleLockTokenStore) GetUserIDByRefreshToken(ctx context.Context, token string) (string, error) {
+	s.refreshTokenMutex.RLock()
+	defer s.refreshTokenMutex.RUnlock()
+	userID, exists := s.refreshTokens[token]
+	if !exists {
+		return "", ErrNotFound
+	}
+	return userID, nil
+}
+
+func (s *singleLockTokenStore) IsTokenRevoked(ctx context.Context, token string) (bool, error) {
+	s.revokedTokenMutex.RLock()
+	defer s.revokedTokenMutex.RUnlock()
+	return s.revokedTokens[token], nil
+}
+
+func (s *singleLockTokenStore) RevokeToken(ctx context.Context, token string) error {
+	s.revokedTokenMutex.Lock()
+	defer s.revokedTokenMutex.Unlock()
+	s.revokedTokens[token] = true
+	return nil
+}
+
+// benchTokenStore is the part of TokenStore the benchmarks exercise
+type benchTokenStore interface {
+	StoreRefreshToken(ctx context.Context, token, userID string) error
+	GetUserIDByRefreshToken(ctx context.Context, token string) (string, error)
+	IsTokenRevoked(ctx context.Context, token string) (bool, error)
+	RevokeToken(ctx context.Context, token string) error
+}
+
+// benchTokens is how many tokens each benchmark store starts with
+const benchTokens = 100_000
+
+// fillTokenStore gives s benchTokens refresh tokens and revocations named
+// by benchToken
+func fillTokenStore(b *testing.B, s benchTokenStore) benchTokenStore {
+	ctx := context.Background()
+	for i := 0; i < benchTokens; i++ {
+		if err := s.StoreRefreshToken(ctx, benchToken(i), "user"); err != nil {
+			b.Fatal(err)
+		}
+		if err := s.RevokeToken(ctx, benchToken(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+func benchToken(i int) string {
+	return fmt.Sprintf("token-%d", i)
+}
+
+// runTokenBenchmark runs op in parallel against each store; op is given a
+// distinct number per call. Run with -cpu to see how the stores scale.
+func runTokenBenchmark(b *testing.B, op func(s benchTokenStore, i int) error) {
+	stores := []struct {
+		name     string
+		newStore func() benchTokenStore
+	}{
+		{"single-lock", func() benchTokenStore { return newSingleLockTokenStore() }},
+		{"sharded", func() benchTokenStore { return NewInMemoryTokenStore(0) }},
+	}
+	for _, store := range stores {
+		s := fillTokenStore(b, store.newStore())
+		b.Run(store.name, func(b *testing.B) {
+			var workers atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Count locally, so the goroutines share nothing but the store
+				i := int(workers.Add(1)) << 32
+				for pb.Next() {
+					i++
+					if err := op(s, i); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkTokenStoreIsTokenRevoked(b *testing.B) {
+	ctx := context.Background()
+	runTokenBenchmark(b, func(s benchTokenStore, i int) error {
+		_, err := s.IsTokenRevoked(ctx, benchToken(i%benchTokens))
+		return err
+	})
+}
+
+func BenchmarkTokenStoreRevokeToken(b *testing.B) {
+	ctx := context.Background()
+	runTokenBenchmark(b, func(s benchTokenStore, i int) error {
+		return s.RevokeToken(ctx, benchToken(benchTokens+i))
+	})
+}
+
+// BenchmarkTokenStoreMixed models an API under load: mostly revocation
+// checks, with a refresh token lookup, a new refresh token and a revocation
+// in every twenty requests
+func BenchmarkTokenStoreMixed(b *testing.B) {
+	ctx := context.Background()
+	runTokenBenchmark(b, func(s benchTokenStore, i int) error {
+		switch i % 20 {
+		case 0:
+			_, err := s.GetUserIDByRefreshToken(ctx, benchToken(i%benchTokens))
+			return err
+		case 1:
+			return s.StoreRefreshToken(ctx, benchToken(benchTokens+i), "user")
+		case 2:
+			return s.RevokeToken(ctx, benchToken(benchTokens+i))
+		default:
+			_, err := s.IsTokenRevoked(ctx, benchToken(i%benchTokens))
+			return err
+		}
+	})
+}
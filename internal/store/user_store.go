@@ -1,105 +1,462 @@
 package store
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
 	"github.com/sanskarm98/auth-service/internal/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sanskarm98/auth-service/internal/password"
 )
 
-// UserStore defines the interface for user data operations
+// UserStore defines the interface for user data operations. Methods report
+// ErrNotFound, ErrConflict, ErrInvalid or ErrInvalidCredentials, possibly
+// wrapped with a more specific message, or a backend error.
 type UserStore interface {
-	Create(email, password string) (models.User, error)
-	GetByID(id string) (models.User, bool)
-	GetByEmail(email string) (models.User, bool)
-	Authenticate(email, password string) (models.User, bool)
+	Create(ctx context.Context, email, password string) (models.User, error)
+	GetByID(ctx context.Context, id string) (models.User, error)
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	Update(ctx context.Context, user models.User) error
+	Import(ctx context.Context, user models.User) (models.User, error)
+	Provision(ctx context.Context, email, password, provider string) (models.User, error)
+	SetPassword(ctx context.Context, userID, password string, historySize int) error
+	SetManagedRoles(ctx context.Context, userID, source string, roles []string) (models.User, error)
+	Authenticate(ctx context.Context, email, password string) (models.User, error)
 }
 
-// InMemoryUserStore implements UserStore with in-memory storage
+// InMemoryUserStore implements UserStore with in-memory storage. Users are
+// indexed by ID and by normalized email, and both maps are only changed
+// together under the write lock.
 type InMemoryUserStore struct {
 	users      map[string]models.User
+	emailIndex map[string]string // email key -> user ID
 	usersMutex sync.RWMutex
+	hasher     *password.Hasher
+	dummyHash  string // compared against when no user matches
+	emails     *emailaddr.Normalizer
+
+	// blindIndex, if set, hashes email keys before they are indexed, so the
+	// index matches the one persisted with encrypted records
+	blindIndex func(string) string
+
+	// journal, if set, is called with each new or changed user while the
+	// write lock is held, before the change is applied; an error cancels it
+	journal func(models.User) error
 }
 
-// NewInMemoryUserStore creates a new instance of InMemoryUserStore
-func NewInMemoryUserStore() *InMemoryUserStore {
+// NewInMemoryUserStore creates a new instance of InMemoryUserStore. Passwords
+// are hashed with hasher, and emails are stored in canonical form and
+// compared by their normalized key.
+func NewInMemoryUserStore(hasher *password.Hasher, emails *emailaddr.Normalizer) *InMemoryUserStore {
+	// Unknown emails are checked against a dummy hash, so they cost as much as known ones
+	dummyHash, err := hasher.Hash("dummy password")
+	if err != nil {
+		panic(err)
+	}
 	return &InMemoryUserStore{
-		users: make(map[string]models.User),
+		users:      make(map[string]models.User),
+		emailIndex: make(map[string]string),
+		hasher:     hasher,
+		dummyHash:  dummyHash,
+		emails:     emails,
 	}
 }
 
 // Create adds a new user to the store
-func (s *InMemoryUserStore) Create(email, password string) (models.User, error) {
-	// Check if email already exists
-	s.usersMutex.RLock()
-	for _, user := range s.users {
-		if user.Email == email {
-			s.usersMutex.RUnlock()
-			return models.User{}, errors.New(models.ErrEmailAlreadyExists)
-		}
+func (s *InMemoryUserStore) Create(ctx context.Context, email, password string) (models.User, error) {
+	user, err := s.newUser(ctx, email, password)
+	if err != nil {
+		return models.User{}, err
 	}
-	s.usersMutex.RUnlock()
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	// Store user, checking the email is still free in the same critical section
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+
+	if err := s.insert(user, s.emailKey(user.Email)); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// newUser builds a new user with a normalized email and hashed password,
+// without storing it
+func (s *InMemoryUserStore) newUser(ctx context.Context, email, password string) (models.User, error) {
+	email, err := s.emails.Normalize(email)
 	if err != nil {
-		return models.User{}, errors.New(models.ErrInternalServerError)
+		return models.User{}, newError(ErrInvalid, models.ErrInvalidEmail)
+	}
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
 	}
 
-	// Create user
-	user := models.User{
-		ID:        uuid.New().String(),
-		Email:     email,
-		Password:  string(hashedPassword),
-		CreatedAt: time.Now(),
+	// Hash password outside the lock; this also makes a taken email cost as much as a new one
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return models.User{}, fmt.Errorf("hash password: %w", err)
 	}
 
-	// Store user
-	s.usersMutex.Lock()
-	s.users[user.ID] = user
-	s.usersMutex.Unlock()
+	now := time.Now()
+	return models.User{
+		ID:                uuid.New().String(),
+		Email:             email,
+		Password:          hashedPassword,
+		CreatedAt:         now,
+		PasswordChangedAt: now,
+	}, nil
+}
 
-	return user, nil
+// insert adds a user and its email key to the indexes, failing if either is
+// taken; callers must hold the write lock
+func (s *InMemoryUserStore) insert(user models.User, key string) error {
+	if _, exists := s.users[user.ID]; exists {
+		return newError(ErrConflict, models.ErrUserAlreadyExists)
+	}
+	if _, exists := s.emailIndex[key]; exists {
+		return newError(ErrConflict, models.ErrEmailAlreadyExists)
+	}
+	if err := s.record(user); err != nil {
+		return err
+	}
+	s.users[user.ID] = user
+	s.emailIndex[key] = user.ID
+	return nil
 }
 
 // GetByID retrieves a user by ID
-func (s *InMemoryUserStore) GetByID(id string) (models.User, bool) {
+func (s *InMemoryUserStore) GetByID(ctx context.Context, id string) (models.User, error) {
 	s.usersMutex.RLock()
 	defer s.usersMutex.RUnlock()
 
 	user, exists := s.users[id]
-	return user, exists
+	if !exists {
+		return models.User{}, newError(ErrNotFound, models.ErrUserNotFound)
+	}
+	return user, nil
 }
 
-// GetByEmail retrieves a user by email
-func (s *InMemoryUserStore) GetByEmail(email string) (models.User, bool) {
+// GetByEmail retrieves a user by email, matching any equivalent form of it
+func (s *InMemoryUserStore) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	key := s.emailKey(email)
+
 	s.usersMutex.RLock()
 	defer s.usersMutex.RUnlock()
 
-	for _, user := range s.users {
-		if user.Email == email {
-			return user, true
+	id, exists := s.emailIndex[key]
+	if !exists {
+		return models.User{}, newError(ErrNotFound, models.ErrUserNotFound)
+	}
+	return s.users[id], nil
+}
+
+// Update replaces an existing user's stored record
+func (s *InMemoryUserStore) Update(ctx context.Context, user models.User) error {
+	email, err := s.emails.Normalize(user.Email)
+	if err != nil {
+		return newError(ErrInvalid, models.ErrInvalidEmail)
+	}
+	user.Email = email
+	key := s.emailKey(email)
+
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+
+	existing, exists := s.users[user.ID]
+	if !exists {
+		return newError(ErrNotFound, models.ErrUserNotFound)
+	}
+
+	// Check the new email isn't taken by someone else, and move the index entry
+	if id, taken := s.emailIndex[key]; taken && id != user.ID {
+		return newError(ErrConflict, models.ErrEmailAlreadyExists)
+	}
+	if err := s.record(user); err != nil {
+		return err
+	}
+	if oldKey := s.emailKey(existing.Email); oldKey != key {
+		delete(s.emailIndex, oldKey)
+		s.emailIndex[key] = user.ID
+	}
+
+	s.users[user.ID] = user
+	return nil
+}
+
+// Import adds a user whose password was hashed elsewhere. The hash is kept
+// as-is and replaced with a current one at the first successful sign-in.
+func (s *InMemoryUserStore) Import(ctx context.Context, user models.User) (models.User, error) {
+	user, err := s.importedUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+
+	if err := s.insert(user, s.emailKey(user.Email)); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// Provision returns the user with email that provider created, creating it
+// with password if there is no user with that email yet. A provider vouching
+// for an email doesn't prove that whoever created an account with it
+// controls it, so any other user with the email fails with ErrConflict.
+func (s *InMemoryUserStore) Provision(ctx context.Context, email, password, provider string) (models.User, error) {
+	if existing, err := s.GetByEmail(ctx, email); err == nil {
+		return provisionedBy(existing, provider)
+	}
+	user, err := s.newUser(ctx, email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.ProvisionedBy = provider
+
+	// A concurrent sign-in may have provisioned the user first
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+	key := s.emailKey(user.Email)
+	if id, exists := s.emailIndex[key]; exists {
+		return provisionedBy(s.users[id], provider)
+	}
+	if err := s.insert(user, key); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// provisionedBy returns user if provider created it, and ErrConflict otherwise
+func provisionedBy(user models.User, provider string) (models.User, error) {
+	if user.ProvisionedBy != provider {
+		return models.User{}, newError(ErrConflict, models.ErrAccountExists)
+	}
+	return user, nil
+}
+
+// importedUser validates an imported user and fills in a missing ID and
+// creation time, without storing it
+func (s *InMemoryUserStore) importedUser(user models.User) (models.User, error) {
+	if !s.hasher.Supports(user.Password) {
+		return models.User{}, newError(ErrInvalid, models.ErrUnsupportedPasswordHash)
+	}
+	email, err := s.emails.Normalize(user.Email)
+	if err != nil {
+		return models.User{}, newError(ErrInvalid, models.ErrInvalidEmail)
+	}
+	user.Email = email
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	return user, nil
+}
+
+// SetPassword replaces a user's password. The new password may not match the
+// current one or any of the historySize-1 before it; up to that many earlier
+// hashes are kept to enforce this.
+func (s *InMemoryUserStore) SetPassword(ctx context.Context, userID, password string, historySize int) error {
+	previous, updated, err := s.newPassword(ctx, userID, password, historySize)
+	if err != nil {
+		return err
+	}
+
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+	return s.swapPassword(previous.Password, updated, true)
+}
+
+// SetManagedRoles replaces the roles a user is granted by source, leaving the
+// rest of the record, including roles granted here or by other sources, as it
+// is at the time of the change. It returns the updated user.
+func (s *InMemoryUserStore) SetManagedRoles(ctx context.Context, userID, source string, roles []string) (models.User, error) {
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return models.User{}, newError(ErrNotFound, models.ErrUserNotFound)
+	}
+
+	// Copies of the user handed out earlier share the map, so replace it
+	managed := make(map[string][]string, len(user.ManagedRoles)+1)
+	for existing, existingRoles := range user.ManagedRoles {
+		managed[existing] = existingRoles
+	}
+	if len(roles) == 0 {
+		delete(managed, source)
+	} else {
+		managed[source] = roles
+	}
+	if len(managed) == 0 {
+		managed = nil
+	}
+	user.ManagedRoles = managed
+	if err := s.record(user); err != nil {
+		return models.User{}, err
+	}
+	s.users[userID] = user
+	return user, nil
+}
+
+// newPassword checks a new password against the user's recent ones and
+// returns the user as read and with the new password, history and change
+// time, without storing it
+func (s *InMemoryUserStore) newPassword(ctx context.Context, userID, password string, historySize int) (models.User, models.User, error) {
+	user, err := s.GetByID(ctx, userID)
+	if err != nil {
+		return models.User{}, models.User{}, err
+	}
+
+	// Check the recent passwords and hash the new one outside the lock
+	var recent []string
+	if historySize > 0 {
+		recent = append([]string{user.Password}, user.PasswordHistory...)
+		if len(recent) > historySize {
+			recent = recent[:historySize]
 		}
 	}
+	for _, hash := range recent {
+		if err := ctx.Err(); err != nil {
+			return models.User{}, models.User{}, err
+		}
+		if reused, _, _ := s.hasher.Verify(password, hash); reused {
+			return models.User{}, models.User{}, newError(ErrInvalid, models.ErrPasswordReused)
+		}
+	}
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return models.User{}, models.User{}, fmt.Errorf("hash password: %w", err)
+	}
 
-	return models.User{}, false
+	var history []string
+	if historySize > 1 {
+		history = recent
+		if len(history) > historySize-1 {
+			history = history[:historySize-1]
+		}
+	}
+	updated := user
+	updated.Password = hashedPassword
+	updated.PasswordHistory = history
+	updated.PasswordChangedAt = time.Now()
+	return user, updated, nil
 }
 
-// Authenticate verifies user credentials and returns the user if valid
-func (s *InMemoryUserStore) Authenticate(email, password string) (models.User, bool) {
-	user, found := s.GetByEmail(email)
-	if !found {
-		return models.User{}, false
+// swapPassword stores updated's password hash, provided the user's hash is
+// still expected. With changed, the password itself changed and its history
+// and change time are stored too; otherwise only its hash was upgraded.
+// Callers must hold the write lock.
+func (s *InMemoryUserStore) swapPassword(expected string, updated models.User, changed bool) error {
+	// Refuse if the password changed while the new one was being prepared
+	current, exists := s.users[updated.ID]
+	if !exists {
+		return newError(ErrNotFound, models.ErrUserNotFound)
+	}
+	if current.Password != expected {
+		return newError(ErrConflict, models.ErrPasswordChanged)
+	}
+
+	current.Password = updated.Password
+	if changed {
+		current.PasswordHistory = updated.PasswordHistory
+		current.PasswordChangedAt = updated.PasswordChangedAt
+	}
+	if err := s.record(current); err != nil {
+		return err
+	}
+	s.users[current.ID] = current
+	return nil
+}
+
+// Authenticate verifies user credentials and returns the user if valid. Hashes
+// made with an outdated algorithm or cost are upgraded on success. Unknown
+// emails and wrong passwords both report ErrInvalidCredentials.
+func (s *InMemoryUserStore) Authenticate(ctx context.Context, email, password string) (models.User, error) {
+	user, rehash, err := s.verify(ctx, email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// Upgrade the hash, unless the password was changed in the meantime
+	if rehash {
+		if upgraded, err := s.rehashed(user, password); err == nil {
+			s.usersMutex.Lock()
+			if s.swapPassword(user.Password, upgraded, false) == nil {
+				user = upgraded
+			}
+			s.usersMutex.Unlock()
+		}
+	}
+	return user, nil
+}
+
+// verify checks user credentials and reports whether the stored hash should
+// be upgraded
+func (s *InMemoryUserStore) verify(ctx context.Context, email, password string) (models.User, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, false, err
+	}
+	user, err := s.GetByEmail(ctx, email)
+	if err != nil {
+		// Spend the same time as a real check to avoid revealing the email is unknown
+		_, _, _ = s.hasher.Verify(password, s.dummyHash)
+		return models.User{}, false, ErrInvalidCredentials
 	}
 
 	// Validate password
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	ok, rehash, err := s.hasher.Verify(password, user.Password)
 	if err != nil {
-		return models.User{}, false
+		return models.User{}, false, fmt.Errorf("verify password of user %s: %w", user.ID, err)
+	}
+	if !ok {
+		return models.User{}, false, ErrInvalidCredentials
 	}
+	return user, rehash, nil
+}
+
+// rehashed returns user with password hashed by the current algorithm
+func (s *InMemoryUserStore) rehashed(user models.User, password string) (models.User, error) {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.Password = hashedPassword
+	return user, nil
+}
+
+// record passes a new or changed user to the journal, if any; callers must
+// hold the write lock
+func (s *InMemoryUserStore) record(user models.User) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal(user)
+}
+
+// emailKey returns the key email is indexed by
+func (s *InMemoryUserStore) emailKey(email string) string {
+	key := s.emails.Key(email)
+	if s.blindIndex == nil {
+		return key
+	}
+	return s.blindIndex(key)
+}
 
-	return user, true
+// restore stores a user as-is, replacing any user with the same ID. It is
+// used to rebuild the store from persisted state.
+func (s *InMemoryUserStore) restore(user models.User) {
+	s.usersMutex.Lock()
+	defer s.usersMutex.Unlock()
+
+	if existing, exists := s.users[user.ID]; exists {
+		delete(s.emailIndex, s.emailKey(existing.Email))
+	}
+	s.users[user.ID] = user
+	s.emailIndex[s.emailKey(user.Email)] = user.ID
 }
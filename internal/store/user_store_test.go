@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sanskarm98/auth-service/internal/emailaddr"
+	"github.com/sanskarm98/auth-service/internal/models"
+	"github.com/sanskarm98/auth-service/internal/password"
+)
+
+// newTestUserStore returns an empty user store with a cheap hasher
+func newTestUserStore() *InMemoryUserStore {
+	return NewInMemoryUserStore(password.NewHasher(nil, password.NewBcrypt(4)), emailaddr.NewNormalizer(true))
+}
+
+func TestConcurrentCreateSameEmail(t *testing.T) {
+	s := newTestUserStore()
+
+	// Every spelling of one address races to sign up; exactly one wins
+	spellings := []string{"Alice@Example.com", "alice@example.com", " ALICE@EXAMPLE.COM ", "alice@EXAMPLE.com"}
+	const rounds = 8
+	var wg sync.WaitGroup
+	errs := make([]error, rounds*len(spellings))
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.Create(context.Background(), spellings[i%len(spellings)], "Correct-Horse-Battery-9")
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrConflict):
+			t.Errorf("Create: unexpected error %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d concurrent creates succeeded, want 1", created)
+	}
+	if len(s.users) != 1 || len(s.emailIndex) != 1 {
+		t.Errorf("store holds %d users and %d email keys, want 1 of each", len(s.users), len(s.emailIndex))
+	}
+}
+
+// BenchmarkGetByEmail looks up users in stores of increasing size. The email
+// index makes lookups O(1): the time per lookup grows only with CPU cache
+// misses as the maps outgrow the cache, a few times from 1,000 to 1,000,000
+// users, where a scan would grow a thousandfold.
+func BenchmarkGetByEmail(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("users=%d", size), func(b *testing.B) {
+			s := newTestUserStore()
+			emails := make([]string, size)
+			for i := range emails {
+				emails[i] = fmt.Sprintf("user-%d@example.com", i)
+				user := models.User{ID: uuid.New().String(), Email: emails[i], Password: s.dummyHash}
+				if err := s.insert(user, s.emails.Key(user.Email)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			ctx := context.Background()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				email := emails[i%size]
+				if i%2 == 1 {
+					email = strings.ToUpper(email)
+				}
+				if _, err := s.GetByEmail(ctx, email); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
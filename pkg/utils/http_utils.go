@@ -28,6 +28,16 @@ func SendErrorResponse(w http.ResponseWriter, status int, message string) {
 	SendJSONResponse(w, status, errResponse)
 }
 
+// SendOAuthErrorResponse sends an error response in the format defined by RFC 6749 section 5.2
+func SendOAuthErrorResponse(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	SendJSONResponse(w, status, models.OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
 // ExtractTokenFromHeader extracts JWT from Authorization header
 func ExtractTokenFromHeader(r *http.Request) string {
 	bearerToken := r.Header.Get("Authorization")